@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+	"github.com/dewitt/a-simple-plan/pkg/plan"
+)
+
+// configCmd prints every setting with its effective value and the layer it
+// came from. Secrets are masked.
+func configCmd(ctx *PlanContext) {
+	if env := ctx.ConfigOptions.Env; env != "" {
+		fmt.Printf("Environment: %s\n\n", env)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, s := range ctx.Config.Settings() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, formatSetting(s), ctx.ConfigSources[s.Key])
+	}
+	w.Flush()
+}
+
+func formatSetting(s config.Setting) string {
+	switch v := s.Value.(type) {
+	case string:
+		if s.Key == "mail.password" && v != "" {
+			return `"********"`
+		}
+	case []config.DeployTarget:
+		masked := append([]config.DeployTarget(nil), v...)
+		for i := range masked {
+			if masked[i].SecretAccessKey != "" {
+				masked[i].SecretAccessKey = "********"
+			}
+		}
+		s.Value = masked
+	}
+	data, err := json.Marshal(s.Value)
+	if err != nil {
+		return fmt.Sprint(s.Value)
+	}
+	return string(data)
+}
+
+// validateConfig checks every configuration layer of the plan at path and
+// reports all the problems found, exiting with an error if there are any.
+func validateConfig(path string, opts config.Options) {
+	dir, _, err := resolvePlanPath(path)
+	if err != nil {
+		log.Fatalf("Initialization failed: %v", err)
+	}
+	cfg, sources, err := config.LoadLayers(dir, opts)
+	var verr *config.ValidationError
+	if errors.As(err, &verr) {
+		for _, p := range verr.Problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		fmt.Fprintf(os.Stderr, "%d problem(s) found.\n", len(verr.Problems))
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if _, err := plan.LoadTemplate(dir, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, config.Problem{Source: sources["theme"], Key: "theme", Message: err.Error()})
+		os.Exit(1)
+	}
+	fmt.Println("Configuration is valid.")
+}
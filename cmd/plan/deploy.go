@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+	"github.com/dewitt/a-simple-plan/internal/deploy"
+)
+
+type deployOptions struct {
+	DryRun  bool
+	NoBuild bool
+}
+
+// deployCmd builds the site and publishes it to the targets configured in
+// settings.json, or only to those named.
+func deployCmd(ctx *PlanContext, names []string, opts deployOptions) {
+	if len(ctx.Config.Deploy) == 0 {
+		log.Fatalf("No deploy targets configured: add a \"deploy\" list to settings.json")
+	}
+	targets := ctx.Config.Deploy
+	if len(names) > 0 {
+		targets = nil
+		for _, name := range names {
+			i := slices.IndexFunc(ctx.Config.Deploy, func(t config.DeployTarget) bool { return targetName(t) == name })
+			if i < 0 {
+				log.Fatalf("Unknown deploy target %q", name)
+			}
+			targets = append(targets, ctx.Config.Deploy[i])
+		}
+	}
+
+	if !opts.NoBuild {
+		if _, _, err := buildSite(ctx, false); err != nil {
+			log.Fatalf("Build failed: %v", err)
+		}
+	}
+
+	message := "Deploy plan"
+	if info, err := getCommitInfo(ctx.PlanDir, "HEAD", ""); err == nil {
+		message = fmt.Sprintf("Deploy plan at %s", shortHash(info.Hash))
+	}
+
+	for _, t := range targets {
+		target, err := newTarget(t)
+		if err != nil {
+			log.Fatalf("Invalid deploy target %q: %v", targetName(t), err)
+		}
+		fmt.Printf("Deploying to %s...\n", targetName(t))
+		err = target.Deploy(context.Background(), ctx.OutputDir, deploy.Options{
+			DryRun:  opts.DryRun,
+			Message: message,
+			Log:     os.Stdout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to deploy to %s: %v", targetName(t), err)
+		}
+	}
+	if opts.DryRun {
+		fmt.Println("Dry run complete, nothing was changed.")
+	} else {
+		fmt.Println("Deploy complete.")
+		// The site is live, so the hub can fetch the new feed.
+		notifyHub(ctx)
+	}
+}
+
+func targetName(t config.DeployTarget) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Type
+}
+
+// newTarget returns the deploy target described by t.
+func newTarget(t config.DeployTarget) (deploy.Target, error) {
+	switch t.Type {
+	case "s3":
+		if t.Bucket == "" {
+			return nil, fmt.Errorf("s3 target needs a bucket")
+		}
+		s := &deploy.S3{
+			Bucket:            t.Bucket,
+			Region:            t.Region,
+			Endpoint:          t.Endpoint,
+			Prefix:            t.Prefix,
+			AccessKeyID:       t.AccessKeyID,
+			SecretAccessKey:   t.SecretAccessKey,
+			SessionToken:      os.Getenv("AWS_SESSION_TOKEN"),
+			Gzip:              t.Gzip,
+			PageCacheControl:  t.PageCacheControl,
+			AssetCacheControl: t.AssetCacheControl,
+		}
+		if s.AccessKeyID == "" {
+			s.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if s.SecretAccessKey == "" {
+			s.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		if s.Region == "" {
+			s.Region = os.Getenv("AWS_REGION")
+		}
+		return s, nil
+	case "rsync":
+		if t.Dest == "" {
+			return nil, fmt.Errorf("rsync target needs a dest")
+		}
+		return &deploy.Rsync{Dest: t.Dest, Args: t.Args}, nil
+	case "git":
+		if t.Remote == "" {
+			return nil, fmt.Errorf("git target needs a remote")
+		}
+		return &deploy.GitBranch{Remote: t.Remote, Branch: t.Branch}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q (want s3, rsync or git)", t.Type)
+}
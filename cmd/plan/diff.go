@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dewitt/a-simple-plan/internal/diff"
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+type diffOptions struct {
+	Rendered bool
+	Words    bool
+	Context  int
+}
+
+// diffCmd prints the differences between two versions of the plan. Either
+// side may be any spec accepted by resolveVersion, or "working" for the file
+// as it currently is on disk.
+func diffCmd(ctx *PlanContext, from, to string, opts diffOptions) {
+	a, aName, err := loadVersion(ctx, from)
+	if err != nil {
+		log.Fatalf("Failed to load %q: %v", from, err)
+	}
+	b, bName, err := loadVersion(ctx, to)
+	if err != nil {
+		log.Fatalf("Failed to load %q: %v", to, err)
+	}
+
+	if opts.Rendered {
+		r := render.NewTerminal(terminalWidth(), false)
+		if a, err = r.Render(a); err != nil {
+			log.Fatalf("Failed to render %s: %v", aName, err)
+		}
+		if b, err = r.Render(b); err != nil {
+			log.Fatalf("Failed to render %s: %v", bName, err)
+		}
+	}
+
+	dopts := diff.Options{
+		FromName: aName,
+		ToName:   bName,
+		Context:  opts.Context,
+		Color:    useColor(os.Stdout),
+	}
+	write := diff.WriteUnified
+	if opts.Words {
+		write = diff.WriteWords
+	}
+	if err := write(os.Stdout, string(a), string(b), dopts); err != nil {
+		log.Fatalf("Failed to write diff: %v", err)
+	}
+}
+
+// loadVersion returns the content of the plan at spec and a label for it.
+func loadVersion(ctx *PlanContext, spec string) ([]byte, string, error) {
+	if spec == "working" {
+		content, err := os.ReadFile(filepath.Join(ctx.PlanDir, ctx.PlanFile))
+		return content, ctx.PlanFile + " (working)", err
+	}
+	info, err := resolveVersion(ctx, spec)
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := getGitContent(ctx.PlanDir, info.Hash, ctx.PlanFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading %s at %s: %w", ctx.PlanFile, shortHash(info.Hash), err)
+	}
+	label := fmt.Sprintf("%s @ %s (%s)", ctx.PlanFile, info.Time.Format("2006-01-02"), shortHash(info.Hash))
+	return content, label, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+// buildError is a build failure, located in the file that caused it where
+// that is known. Preview shows them in the browser.
+type buildError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *buildError) Error() string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	case e.File != "":
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return e.Message
+}
+
+// buildErrors breaks err down into build errors, one for each problem it
+// reports.
+func buildErrors(ctx *PlanContext, err error) []buildError {
+	if err == nil {
+		return nil
+	}
+	var (
+		berr  *buildError
+		verr  *config.ValidationError
+		terr  *render.TemplateError
+		prerr *render.PrivateError
+		perr  *fs.PathError
+	)
+	switch {
+	case errors.As(err, &berr):
+		return []buildError{*berr}
+	case errors.As(err, &verr):
+		var errs []buildError
+		for _, p := range verr.Problems {
+			errs = append(errs, buildError{File: p.Source, Line: p.Line, Message: p.Key + ": " + p.Message})
+		}
+		return errs
+	case errors.As(err, &terr):
+		return []buildError{{File: templateSource(ctx), Line: terr.Line, Message: terr.Message}}
+	case errors.As(err, &prerr):
+		return []buildError{{File: ctx.PlanFile, Line: prerr.Line, Message: "private block is never closed"}}
+	case errors.As(err, &perr):
+		if rel, err := filepath.Rel(ctx.PlanDir, perr.Path); err == nil && filepath.IsLocal(rel) {
+			return []buildError{{File: rel, Message: perr.Err.Error()}}
+		}
+	}
+	return []buildError{{Message: err.Error()}}
+}
+
+// templateSource names where the page template of ctx comes from.
+func templateSource(ctx *PlanContext) string {
+	if _, err := os.Stat(filepath.Join(ctx.PlanDir, "template.html")); err == nil {
+		return "template.html"
+	}
+	if ctx.Config.Theme != "" {
+		return "theme " + ctx.Config.Theme
+	}
+	return "built-in template"
+}
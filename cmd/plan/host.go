@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+	"github.com/dewitt/a-simple-plan/pkg/plan"
+)
+
+// validUsername matches the usernames a host serves plans under, as
+// /~username/: letters, digits, dots, underscores and hyphens, not starting
+// with a dot.
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// hostUser is a single plan on a multi-user host.
+type hostUser struct {
+	Ctx     *PlanContext
+	Updated time.Time
+}
+
+// isHostDir reports whether dir is a multi-user host: it has no plan file of
+// its own but contains subdirectories that do.
+func isHostDir(ctx *PlanContext) bool {
+	if _, err := os.Stat(filepath.Join(ctx.PlanDir, ctx.PlanFile)); err == nil {
+		return false
+	}
+	return len(findHostUsers(ctx)) > 0
+}
+
+// findHostUsers returns the subdirectories of the host that contain a plan.
+func findHostUsers(ctx *PlanContext) []string {
+	entries, err := os.ReadDir(ctx.PlanDir)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || strings.HasPrefix(name, ".") || name == "public" || name == "assets" {
+			continue
+		}
+		dir := filepath.Join(ctx.PlanDir, name)
+		if _, err := os.Stat(filepath.Join(dir, ctx.PlanFile)); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// buildHost builds every plan found under the host directory into
+// /~username/, then generates a root "who" index and a combined feed. A plan
+// that fails to build is skipped. The result lists the pages of every plan,
+// under its directory, and the items of the combined feed.
+func buildHost(ctx *PlanContext) (*plan.Result, error) {
+	fmt.Printf("Building host %s...\n", ctx.PlanDir)
+
+	var users []hostUser
+	host := &plan.Result{}
+	seen := make(map[string]string)
+	for _, dir := range findHostUsers(ctx) {
+		userCtx, err := initContext(dir, ctx.ConfigOptions)
+		if err != nil {
+			log.Printf("Warning: Skipping %s: %v", dir, err)
+			continue
+		}
+
+		// Without a username of its own, a plan is known by its directory.
+		if userCtx.ConfigSources["username"] == config.SourceDefault || userCtx.Config.Username == "" {
+			userCtx.Config.Username = filepath.Base(dir)
+		}
+		username := userCtx.Config.Username
+		if !validUsername.MatchString(username) {
+			log.Printf("Warning: Skipping %s: username %q may only contain letters, digits, '.', '_' and '-', and not start with '.'", dir, username)
+			continue
+		}
+		if other, ok := seen[username]; ok {
+			log.Printf("Warning: Skipping %s: username %q is already used by %s", dir, username, other)
+			continue
+		}
+		seen[username] = dir
+
+		userCtx.Config.BaseURL = ctx.Config.BaseURL
+		userCtx.BasePath = ctx.BasePath + "/~" + username
+		userCtx.OutputDir = filepath.Join(ctx.OutputDir, "~"+username)
+		userCtx.LiveReload = ctx.LiveReload
+		userCtx.At = ctx.At
+
+		res, err := build(userCtx)
+		if err != nil {
+			log.Printf("Warning: Skipping %s: %v", dir, err)
+			continue
+		}
+		for _, page := range res.Pages {
+			page.Path = "~" + username + "/" + page.Path
+			host.Pages = append(host.Pages, page)
+		}
+		for _, item := range res.Items {
+			item.Title = username + ": " + item.Title
+			host.Items = append(host.Items, item)
+		}
+		for _, w := range res.Warnings {
+			host.Warnings = append(host.Warnings, username+": "+w)
+		}
+		if next := res.NextPublish; !next.IsZero() && (host.NextPublish.IsZero() || next.Before(host.NextPublish)) {
+			host.NextPublish = next
+		}
+		users = append(users, hostUser{Ctx: userCtx, Updated: lastUpdate(userCtx)})
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Updated.After(users[j].Updated)
+	})
+
+	b, err := ctx.builder()
+	if err != nil {
+		return nil, err
+	}
+	now := ctx.now()
+	page, err := b.WritePage("index.html", []byte(whoIndex(ctx, users, now)), now)
+	if err != nil {
+		return nil, fmt.Errorf("building who index: %w", err)
+	}
+	host.Pages = append(host.Pages, page)
+
+	// Combined feed, newest first across all users.
+	items := host.Items
+	sort.SliceStable(items, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC1123Z, items[i].PubDate)
+		tj, _ := time.Parse(time.RFC1123Z, items[j].PubDate)
+		return ti.After(tj)
+	})
+	feed := plan.Feed{
+		Title:       ctx.Config.Title,
+		Link:        ctx.Config.BaseURL + ctx.BasePath,
+		Description: fmt.Sprintf("Updates from everyone on %s", ctx.Config.Title),
+		Items:       items,
+	}
+	feeds, err := b.WriteFeeds(feed)
+	if err != nil {
+		return nil, err
+	}
+	host.Pages = append(host.Pages, feeds...)
+
+	if page, err := b.BuildNotFound(); err != nil {
+		log.Printf("Warning: Failed to generate 404 page: %v", err)
+	} else {
+		host.Pages = append(host.Pages, page)
+	}
+
+	fmt.Printf("Host build complete (%d users).\n", len(users))
+	return host, nil
+}
+
+// whoIndex renders the list of users on the host as markdown, in the manner
+// of who(1): login, name, idle time and a link to each plan.
+func whoIndex(ctx *PlanContext, users []hostUser, now time.Time) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# who\n\n")
+	if len(users) == 0 {
+		sb.WriteString("Nobody is logged in.\n")
+		return sb.String()
+	}
+	sb.WriteString("| Login | Name | Idle | Last update |\n")
+	sb.WriteString("|-------|------|-----:|-------------|\n")
+	for _, u := range users {
+		cfg := u.Ctx.Config
+		fmt.Fprintf(&sb, "| [%s](%s/) | %s | %s | %s |\n",
+			cfg.Username, u.Ctx.BasePath, escapeCell(cfg.FullName),
+			idleTime(now.Sub(u.Updated)), u.Updated.In(ctx.Location()).Format("Mon Jan _2 15:04"))
+	}
+	sb.WriteString("\n[All updates (RSS)](rss.xml)\n")
+	return sb.String()
+}
+
+// lastUpdate returns when the plan was last changed: the time of its newest
+// published version, or the file's modification time when it has none.
+func lastUpdate(ctx *PlanContext) time.Time {
+	if info, err := latestPublished(ctx); err == nil {
+		return info.Time
+	}
+	if info, err := os.Stat(filepath.Join(ctx.PlanDir, ctx.PlanFile)); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// idleTime formats a duration like the idle column of finger(1).
+func idleTime(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+	var s string
+	if days > 0 {
+		s += fmt.Sprintf("%dd ", days)
+	}
+	if hours > 0 {
+		s += fmt.Sprintf("%dh ", hours)
+	}
+	return s + fmt.Sprintf("%dm", minutes)
+}
+
+func escapeCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
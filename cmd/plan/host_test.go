@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+)
+
+func git(t *testing.T, dir string, env []string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+}
+
+// writePlan creates a plan repository in dir with a single version of
+// plan.md committed at date, and settings.json when settings is not empty.
+func writePlan(t *testing.T, dir, settings, content, date string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if settings != "" {
+		if err := os.WriteFile(filepath.Join(dir, "settings.json"), []byte(settings), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "plan.md"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{
+		"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_AUTHOR_DATE=" + date,
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com", "GIT_COMMITTER_DATE=" + date,
+	}
+	git(t, dir, env, "init", "--quiet")
+	git(t, dir, env, "add", ".")
+	git(t, dir, env, "commit", "--quiet", "-m", "Update plan")
+}
+
+func newHost(t *testing.T) *PlanContext {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	host := t.TempDir()
+	if err := os.WriteFile(filepath.Join(host, "settings.json"), []byte(`{"title": "Host", "base_url": "https://host.example"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writePlan(t, filepath.Join(host, "alice"), `{"username": "alice"}`, "# Alice\n\nWorking on the host.\n", "2024-03-01T10:00:00Z")
+	writePlan(t, filepath.Join(host, "bob"), "", "# Bob\n\nNo username of my own.\n", "2024-03-02T10:00:00Z")
+	return hostContext(t, host)
+}
+
+func hostContext(t *testing.T, dir string) *PlanContext {
+	t.Helper()
+	ctx, err := initContext(dir, config.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func TestIsHostDir(t *testing.T) {
+	ctx := newHost(t)
+	if !isHostDir(ctx) {
+		t.Fatal("isHostDir = false for a directory of plans")
+	}
+	// The output and hidden directories are never plans.
+	for _, name := range []string{"public", ".hidden"} {
+		writePlan(t, filepath.Join(ctx.PlanDir, name), "", "# Not a user\n", "2024-03-03T10:00:00Z")
+	}
+	var got []string
+	for _, dir := range findHostUsers(ctx) {
+		got = append(got, filepath.Base(dir))
+	}
+	if strings.Join(got, ",") != "alice,bob" {
+		t.Errorf("findHostUsers = %v, want [alice bob]", got)
+	}
+
+	single := t.TempDir()
+	writePlan(t, single, "", "# Just me\n", "2024-03-01T10:00:00Z")
+	if isHostDir(hostContext(t, single)) {
+		t.Error("isHostDir = true for a single plan")
+	}
+}
+
+func TestBuildHost(t *testing.T) {
+	ctx := newHost(t)
+	// A username taken by another plan, and ones that would escape /~username/.
+	writePlan(t, filepath.Join(ctx.PlanDir, "carol"), `{"username": "alice"}`, "# Carol\n", "2024-03-03T10:00:00Z")
+	writePlan(t, filepath.Join(ctx.PlanDir, "dave"), `{"username": "../../dave"}`, "# Dave\n", "2024-03-03T10:00:00Z")
+	writePlan(t, filepath.Join(ctx.PlanDir, "erin"), `{"username": ".."}`, "# Erin\n", "2024-03-03T10:00:00Z")
+	// A username set explicitly is kept, even when it is the default one.
+	writePlan(t, filepath.Join(ctx.PlanDir, "frank"), `{"username": "`+config.DefaultConfig().Username+`"}`, "# Frank\n", "2024-03-04T10:00:00Z")
+	username := config.DefaultConfig().Username
+	if !validUsername.MatchString(username) {
+		username = "frank"
+	}
+
+	if _, err := buildHost(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"~alice/index.html", "~bob/index.html", "~" + username + "/index.html", "index.html", "rss.xml"} {
+		if _, err := os.Stat(filepath.Join(ctx.OutputDir, name)); err != nil {
+			t.Errorf("%s not built: %v", name, err)
+		}
+	}
+	entries, _ := os.ReadDir(ctx.OutputDir)
+	for _, e := range entries {
+		if e.Name() == "~carol" || e.Name() == "~.." || strings.Contains(e.Name(), "dave") {
+			t.Errorf("built %s", e.Name())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(ctx.PlanDir), "dave")); err == nil {
+		t.Error("built dave's plan outside the output directory")
+	}
+	alice, err := os.ReadFile(filepath.Join(ctx.OutputDir, "~alice", "index.html"))
+	if err != nil || !strings.Contains(string(alice), "Working on the host") {
+		t.Errorf("~alice/index.html is not Alice's plan: %v", err)
+	}
+
+	// The combined feed has everyone, newest first.
+	rss, err := os.ReadFile(filepath.Join(ctx.OutputDir, "rss.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := string(rss)
+	a, b := strings.Index(feed, "<title>alice: "), strings.Index(feed, "<title>bob: ")
+	if a < 0 || b < 0 || b > a {
+		t.Errorf("combined feed does not list bob then alice:\n%s", feed)
+	}
+	if n := strings.Count(feed, "<title>alice: "); n != 1 {
+		t.Errorf("combined feed has carol's plan as alice's:\n%s", feed)
+	}
+}
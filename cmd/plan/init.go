@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+type initOptions struct {
+	Username string
+	Name     string
+	Title    string
+	Timezone string
+	BaseURL  string
+	Theme    string
+	Eject    bool
+	Yes      bool
+}
+
+// starterPlan is the plan.md of a new plan repository.
+const starterPlan = `### Hello
+
+This is my plan. Edit this file with ` + "`plan edit`" + `, look at it with
+` + "`plan preview`" + `, and save each day's version with ` + "`plan save`" + `.
+`
+
+// initCmd sets up a plan repository in dir: a git repository with a starter
+// plan.md, a settings.json, an optional template.html, .gitignore entries for
+// generated files, and an initial commit. Anything that already exists is
+// left alone, so it is safe to run again on an existing plan.
+func initCmd(dir string, opts initOptions) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", dir, err)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := &prompter{in: bufio.NewReader(os.Stdin), interactive: !opts.Yes && isTerminal(os.Stdin)}
+
+	check := exec.Command("git", "rev-parse", "--is-inside-work-tree")
+	check.Dir = dir
+	if check.Run() != nil {
+		if err := runCmd(dir, "git", "init", "--quiet"); err != nil {
+			log.Fatalf("Failed to create the git repository: %v", err)
+		}
+		fmt.Printf("Created a git repository in %s\n", dir)
+	}
+
+	var created []string
+	create := func(name string, content func() ([]byte, error)) {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			fmt.Printf("Keeping the existing %s\n", name)
+			return
+		}
+		data, err := content()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			log.Fatalf("Failed to write %s: %v", name, err)
+		}
+		fmt.Printf("Created %s\n", name)
+		created = append(created, name)
+	}
+
+	create("plan.md", func() ([]byte, error) { return []byte(starterPlan), nil })
+	create("settings.json", func() ([]byte, error) { return initSettings(p, opts) })
+	if opts.Eject || p.confirm("Copy the page template to template.html to customize it?") {
+		create("template.html", func() ([]byte, error) {
+			theme := opts.Theme
+			if theme == "" {
+				theme = render.DefaultTheme
+			}
+			tmpl, err := render.ThemeTemplate(theme)
+			return []byte(tmpl), err
+		})
+	}
+
+	added, err := ensureLines(filepath.Join(dir, ".gitignore"), "public/", "public.*/", ".plan/")
+	if err != nil {
+		log.Fatalf("Failed to update .gitignore: %v", err)
+	}
+	if len(added) > 0 {
+		fmt.Printf("Added %s to .gitignore\n", strings.Join(added, ", "))
+		created = append(created, ".gitignore")
+	}
+
+	if gitHead(dir) != "" {
+		if len(created) == 0 {
+			fmt.Println("Nothing to do; the plan is already set up.")
+		} else {
+			fmt.Println("The repository already has commits, so the new files are left for you to commit.")
+		}
+		return
+	}
+
+	// Commit everything init manages, including files left by an earlier
+	// run that could not commit.
+	var files []string
+	for _, name := range []string{"plan.md", "settings.json", "template.html", ".gitignore"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			files = append(files, name)
+		}
+	}
+	if err := runCmd(dir, "git", append([]string{"add", "--"}, files...)...); err != nil {
+		log.Fatalf("Failed to add files: %v", err)
+	}
+	if err := runCmd(dir, "git", "commit", "--quiet", "-m", "Start a plan"); err != nil {
+		log.Fatalf("Failed to make the initial commit: %v", err)
+	}
+	fmt.Println("Made the initial commit. Run 'plan preview' to see your plan.")
+}
+
+// initSettings asks for the settings a new plan needs, starting from the
+// defaults, and returns them as the contents of settings.json.
+func initSettings(p *prompter, opts initOptions) ([]byte, error) {
+	cfg := config.DefaultConfig()
+	cfg.Username = p.ask("Username", opts.Username, cfg.Username, nil)
+	cfg.FullName = p.ask("Name", opts.Name, cfg.Username, nil)
+	cfg.Title = p.ask("Title", opts.Title, cfg.Title, nil)
+	cfg.Timezone = p.ask("Time zone", opts.Timezone, cfg.Timezone, func(v string) string {
+		c := cfg
+		c.Timezone = v
+		return problemFor(c, "timezone")
+	})
+	cfg.BaseURL = p.ask("Base URL", opts.BaseURL, cfg.BaseURL, func(v string) string {
+		c := cfg
+		c.BaseURL = v
+		return problemFor(c, "base_url")
+	})
+	if opts.Theme != "" && !opts.Eject {
+		if _, err := render.ThemeCSS(opts.Theme); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the settings a new plan needs are written; the rest keep their
+	// defaults and are listed by `plan config`.
+	settings := struct {
+		Username  string `json:"username"`
+		FullName  string `json:"name"`
+		Directory string `json:"directory"`
+		Shell     string `json:"shell"`
+		Timezone  string `json:"timezone"`
+		Title     string `json:"title"`
+		BaseURL   string `json:"base_url"`
+		Theme     string `json:"theme,omitempty"`
+	}{cfg.Username, cfg.FullName, cfg.Directory, cfg.Shell, cfg.Timezone, cfg.Title, cfg.BaseURL, ""}
+	if !opts.Eject {
+		settings.Theme = opts.Theme
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	return append(data, '\n'), err
+}
+
+// problemFor returns the message of the problem Validate finds with key in
+// cfg, if any.
+func problemFor(cfg config.Config, key string) string {
+	for _, p := range cfg.Validate() {
+		if p.Key == key {
+			return p.Message
+		}
+	}
+	return ""
+}
+
+// prompter asks questions on the terminal. When it is not interactive, every
+// question takes its default.
+type prompter struct {
+	in          *bufio.Reader
+	interactive bool
+}
+
+// ask returns the answer to a question. A value given by flag is used without
+// asking. check returns a message for answers that are not acceptable.
+func (p *prompter) ask(question, flagValue, def string, check func(string) string) string {
+	if flagValue != "" || !p.interactive {
+		v := flagValue
+		if v == "" {
+			v = def
+		}
+		if check != nil {
+			if msg := check(v); msg != "" {
+				log.Fatalf("%s: %s", question, msg)
+			}
+		}
+		return v
+	}
+	for {
+		fmt.Printf("%s [%s]: ", question, def)
+		line, err := p.in.ReadString('\n')
+		v := strings.TrimSpace(line)
+		if v == "" {
+			v = def
+		}
+		if check == nil {
+			return v
+		}
+		msg := check(v)
+		if msg == "" {
+			return v
+		}
+		if err == io.EOF {
+			log.Fatalf("%s: %s", question, msg)
+		}
+		fmt.Println("  " + msg)
+	}
+}
+
+// confirm asks a yes or no question, defaulting to no.
+func (p *prompter) confirm(question string) bool {
+	if !p.interactive {
+		return false
+	}
+	fmt.Printf("%s [y/N]: ", question)
+	line, _ := p.in.ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// ensureLines appends the lines missing from the file at path, creating it
+// if needed, and returns the ones it added.
+func ensureLines(path string, lines ...string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	existing := strings.Split(string(data), "\n")
+	for i := range existing {
+		existing[i] = strings.TrimSpace(existing[i])
+	}
+	var added []string
+	for _, l := range lines {
+		if !slices.Contains(existing, l) && !slices.Contains(existing, "/"+l) {
+			added = append(added, l)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+	data = append(data, strings.Join(added, "\n")+"\n"...)
+	return added, os.WriteFile(path, data, 0644)
+}
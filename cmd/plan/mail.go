@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/diff"
+	"github.com/dewitt/a-simple-plan/internal/mail"
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+type mailOptions struct {
+	DryRun bool
+	Force  bool
+	Out    string
+}
+
+// mailState records the last version of the plan that was mailed.
+type mailState struct {
+	LastHash string    `json:"last_hash"`
+	LastSent time.Time `json:"last_sent"`
+}
+
+func mailStatePath(ctx *PlanContext) string {
+	return filepath.Join(ctx.PlanDir, ".plan", "mail.json")
+}
+
+func loadMailState(path string) (mailState, error) {
+	var s mailState
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s mailState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// mailCmd emails the latest version of the plan, or what changed since the
+// last mailing, to the configured recipients. Nothing is sent when the plan
+// has not changed since the last mailing, unless opts.Force is set.
+func mailCmd(ctx *PlanContext, opts mailOptions) error {
+	cfg := ctx.Config.Mail
+	if cfg.From == "" || len(cfg.To) == 0 {
+		return fmt.Errorf("mail is not configured: set mail.from and mail.to in settings.json")
+	}
+	if cfg.SMTPHost == "" && !opts.DryRun {
+		return fmt.Errorf("mail is not configured: set mail.smtp_host in settings.json")
+	}
+
+	statePath := mailStatePath(ctx)
+	state, err := loadMailState(statePath)
+	if err != nil {
+		return fmt.Errorf("loading mail state: %w", err)
+	}
+
+	latest, err := latestPublished(ctx)
+	if err != nil {
+		return fmt.Errorf("finding the latest version: %w", err)
+	}
+	if latest.Hash == state.LastHash && !opts.Force {
+		fmt.Println("Nothing new to mail.")
+		return nil
+	}
+
+	msg, err := composeMail(ctx, state, latest)
+	if err != nil {
+		return fmt.Errorf("composing mail: %w", err)
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return fmt.Errorf("encoding mail: %w", err)
+	}
+
+	if opts.DryRun {
+		dir := opts.Out
+		if dir == "" {
+			dir = filepath.Join(ctx.PlanDir, ".plan", "mail")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%s-%s.eml", latest.Time.In(ctx.Location()).Format("2006-01-02"), shortHash(latest.Hash))
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s (not sent).\n", path)
+		return nil
+	}
+
+	server := &mail.Server{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		StartTLS: cfg.StartTLS,
+	}
+	fmt.Printf("Mailing %q to %s...\n", msg.Subject, strings.Join(cfg.To, ", "))
+	if err := server.Send(cfg.From, cfg.To, data); err != nil {
+		return fmt.Errorf("sending mail: %w", err)
+	}
+
+	state.LastHash = latest.Hash
+	state.LastSent = time.Now().UTC()
+	if err := state.save(statePath); err != nil {
+		log.Printf("Warning: Failed to save mail state: %v", err)
+	}
+	fmt.Println("Mail sent.")
+	return nil
+}
+
+// latestPublished returns the newest published version of the plan, which
+// is not private or retracted.
+func latestPublished(ctx *PlanContext) (CommitInfo, error) {
+	b, err := ctx.builder()
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	days, err := b.Days()
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	if len(days) == 0 {
+		return CommitInfo{}, fmt.Errorf("%s has no published versions", ctx.PlanFile)
+	}
+	return CommitInfo{Hash: days[0].Hash, Time: days[0].Time}, nil
+}
+
+// composeMail builds the message for latest. In "diff" mode it contains the
+// changes since the last mailed version; otherwise, or when there is no
+// earlier mailing to compare against, it contains the whole plan.
+func composeMail(ctx *PlanContext, state mailState, latest CommitInfo) (*mail.Message, error) {
+	content, err := getGitContent(ctx.PlanDir, latest.Hash, ctx.PlanFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s at %s: %w", ctx.PlanFile, shortHash(latest.Hash), err)
+	}
+	if content, err = render.StripPrivate(content); err != nil {
+		return nil, fmt.Errorf("%s at %s: %w", ctx.PlanFile, shortHash(latest.Hash), err)
+	}
+
+	date := latest.Time.In(ctx.Location()).Format("Mon Jan 2, 2006")
+	link := ctx.Config.BaseURL + dayPath(ctx, latest.Time)
+	r := render.New(&ctx.Config, ctx.Template, false, ctx.Config.BaseURL+ctx.BasePath+"/")
+
+	var text, body []byte
+	subject := fmt.Sprintf("%s: %s", ctx.Config.Title, date)
+	var previous []byte
+	if ctx.Config.Mail.Mode == "diff" && state.LastHash != "" && state.LastHash != latest.Hash {
+		previous, err = getGitContent(ctx.PlanDir, state.LastHash, ctx.PlanFile)
+		if err != nil {
+			log.Printf("Warning: Failed to read last mailed version %s, sending the whole plan: %v", shortHash(state.LastHash), err)
+			previous = nil
+		}
+	}
+
+	if previous != nil {
+		// A block left open in a version already mailed was stripped to the
+		// end then, too.
+		previous, _ = render.StripPrivate(previous)
+		subject = fmt.Sprintf("%s: changes on %s", ctx.Config.Title, date)
+		var buf bytes.Buffer
+		if err := diff.WriteUnified(&buf, string(previous), string(content), diff.Options{
+			FromName: "last mailing (" + shortHash(state.LastHash) + ")",
+			ToName:   "latest (" + shortHash(latest.Hash) + ")",
+			Context:  3,
+		}); err != nil {
+			return nil, err
+		}
+		text = buf.Bytes()
+		body = diffHTML(string(previous), string(content))
+	} else {
+		if text, err = render.NewTerminal(72, false).Render(content); err != nil {
+			return nil, fmt.Errorf("rendering text: %w", err)
+		}
+		if body, err = r.RenderBody(content); err != nil {
+			return nil, fmt.Errorf("rendering html: %w", err)
+		}
+	}
+
+	text = append(text, fmt.Sprintf("\n-- \n%s\n", link)...)
+	body = append(body, fmt.Sprintf("\n<p><a href=\"%s\">View on the web</a></p>\n", html.EscapeString(link))...)
+
+	return &mail.Message{
+		From:    ctx.Config.Mail.From,
+		To:      ctx.Config.Mail.To,
+		Subject: subject,
+		Date:    latest.Time,
+		Text:    string(text),
+		HTML:    string(r.ComposeEmail(body, subject, latest.Time)),
+		Headers: map[string]string{"Auto-Submitted": "auto-generated"},
+	}, nil
+}
+
+// diffHTML renders the changes between a and b as a preformatted block, with
+// removed lines in <del> and added lines in <ins>.
+func diffHTML(a, b string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<pre>")
+	for i, h := range diff.Hunks(diff.Diff(diff.Lines(a), diff.Lines(b)), 3) {
+		if i > 0 {
+			buf.WriteString("…\n")
+		}
+		for _, e := range h.Edits {
+			line := html.EscapeString(e.Text)
+			switch e.Op {
+			case diff.Equal:
+				fmt.Fprintf(&buf, " %s\n", line)
+			case diff.Delete:
+				fmt.Fprintf(&buf, "<del>-%s</del>\n", line)
+			case diff.Insert:
+				fmt.Fprintf(&buf, "<ins>+%s</ins>\n", line)
+			}
+		}
+	}
+	buf.WriteString("</pre>\n")
+	return buf.Bytes()
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"encoding/xml"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -13,14 +13,12 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
-	"sort"
 	"strings"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
-
 	"github.com/dewitt/a-simple-plan/internal/config"
 	"github.com/dewitt/a-simple-plan/internal/render"
+	"github.com/dewitt/a-simple-plan/pkg/plan"
 )
 
 type PlanContext struct {
@@ -32,32 +30,46 @@ type PlanContext struct {
 	CreationTime time.Time
 	LiveReload   bool
 	HasAssets    bool
-}
-
-type Rss struct {
-	XMLName xml.Name `xml:"rss"`
-
-	Version string `xml:"version,attr"`
+	BasePath     string    // URL path the site is served under, e.g. "/~alice" on a host
+	At           time.Time // The instant to build as, for embargoes; zero for now
 
-	ContentNs string `xml:"xmlns:content,attr"`
+	ConfigOptions config.Options // Layers the config was loaded with
+	ConfigSources config.Sources // Where each setting came from
+}
 
-	Channel Channel `xml:"channel"`
+// Location returns the configured timezone, falling back to local time.
+func (ctx *PlanContext) Location() *time.Location {
+	loc, err := time.LoadLocation(ctx.Config.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
 }
 
-type Channel struct {
-	Title       string `xml:"title"`
-	Link        string `xml:"link"`
-	Description string `xml:"description"`
-	Items       []Item `xml:"item"`
+// now returns the instant ctx builds as.
+func (ctx *PlanContext) now() time.Time {
+	if !ctx.At.IsZero() {
+		return ctx.At
+	}
+	return time.Now()
 }
 
-type Item struct {
-	Title       string `xml:"title"`
-	Link        string `xml:"link"`
-	Description string `xml:"description"`
-	Content     string `xml:"content:encoded"`
-	PubDate     string `xml:"pubDate"`
-	Guid        string `xml:"guid"`
+// builder returns a Builder for the plan as ctx describes it, writing to
+// ctx.OutputDir.
+func (ctx *PlanContext) builder() (*plan.Builder, error) {
+	return plan.New(ctx.PlanDir,
+		plan.WithClock(ctx.now),
+		plan.WithFile(ctx.PlanFile),
+		plan.WithConfig(ctx.Config),
+		plan.WithTemplate(ctx.Template),
+		plan.WithOutput(plan.DirOutput(ctx.OutputDir)),
+		plan.WithLogger(log.Default()),
+		plan.WithCreated(ctx.CreationTime),
+		plan.WithLiveReload(ctx.LiveReload),
+		// Only preview reloads live, and it shows the private blocks.
+		plan.WithShowPrivate(ctx.LiveReload),
+		plan.WithBasePath(ctx.BasePath),
+	)
 }
 
 func main() {
@@ -65,17 +77,34 @@ func main() {
 	var inputPath string
 	flag.StringVar(&inputPath, "f", ".", "Path to the plan file or directory")
 	flag.StringVar(&inputPath, "file", ".", "Path to the plan file or directory")
+	configOpts := config.Options{Env: os.Getenv("PLAN_ENV"), Environ: os.Environ()}
+	flag.StringVar(&configOpts.Env, "env", configOpts.Env, "Environment, selecting settings.<env>.json (default $PLAN_ENV)")
+	flag.Var((*stringList)(&configOpts.Overrides), "set", "Override a setting, as key=value (repeatable)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: plan [options] <command>\n")
 		fmt.Fprintf(os.Stderr, "\nCommands:\n")
+		fmt.Fprintf(os.Stderr, "  init     - Set up a new plan repository (or finish setting up an existing one)\n")
 		fmt.Fprintf(os.Stderr, "  preview  - Render locally and open in browser\n")
-		fmt.Fprintf(os.Stderr, "  build    - Generate static HTML in 'public' directory\n")
+		fmt.Fprintf(os.Stderr, "  build    - Generate static HTML in 'public' directory, listed in manifest.json\n")
+		fmt.Fprintf(os.Stderr, "             (--json prints the manifest, with what changed)\n")
+		fmt.Fprintf(os.Stderr, "             (--at builds as of another time, to check embargoed commits)\n")
+		fmt.Fprintf(os.Stderr, "  serve    - Serve the built site, with Webmention and ActivityPub endpoints\n")
+		fmt.Fprintf(os.Stderr, "  websub-hub - Run a WebSub hub that delivers the feed to subscribers\n")
+		fmt.Fprintf(os.Stderr, "  websub-notify - Tell the WebSub hub about the built feed, if it changed\n")
 		fmt.Fprintf(os.Stderr, "  save     - Commit changes locally\n")
 		fmt.Fprintf(os.Stderr, "  publish  - Commit and push to origin\n")
+		fmt.Fprintf(os.Stderr, "  deploy   - Build and upload the site to the targets in settings.json\n")
 		fmt.Fprintf(os.Stderr, "  revert   - Discard local changes\n")
 		fmt.Fprintf(os.Stderr, "  rollback - Revert to previous version and publish\n")
 		fmt.Fprintf(os.Stderr, "  edit     - Open plan file in default editor\n")
+		fmt.Fprintf(os.Stderr, "  show     - Print a version of the plan (date, commit or 'latest')\n")
+		fmt.Fprintf(os.Stderr, "  diff     - Compare two versions of the plan (default: latest to working)\n")
+		fmt.Fprintf(os.Stderr, "  mail     - Email the latest day, or changes since the last mailing\n")
+		fmt.Fprintf(os.Stderr, "  config   - Print the effective configuration and where each value came from\n")
+		fmt.Fprintf(os.Stderr, "             ('plan config validate' only checks it)\n")
+		fmt.Fprintf(os.Stderr, "  template - Check template.html for problems ('plan template check')\n")
+		fmt.Fprintf(os.Stderr, "  theme    - List the built-in themes, or copy one to template.html ('plan theme eject <name>')\n")
 		fmt.Fprintf(os.Stderr, "  debug    - Print debug information\n")
 		fmt.Fprintf(os.Stderr, "\nOptions:\n")
 		flag.PrintDefaults()
@@ -91,24 +120,51 @@ func main() {
 	}
 
 	cmd := flag.Arg(0)
+	var cmdArgs []string
+	var opts cmdOptions
+
+	if cmd == "-h" || cmd == "--help" {
+		flag.Usage()
+		return
+	}
 
 	// Re-parse flags if they were placed after the command (legacy support / user convenience)
 	// This is a bit tricky because flag.Parse() already consumed what it could.
 	// But since we want to support `plan preview -f ...` and `plan -f ... preview`,
-	// we can check if there are args after the command.
-	if len(flag.Args()) > 1 {
-		// Create a new flag set to parse the remaining arguments
-		subFs := flag.NewFlagSet("subcommand", flag.ContinueOnError)
-		subFs.StringVar(&inputPath, "f", inputPath, "Path to the plan file or directory")
-		subFs.StringVar(&inputPath, "file", inputPath, "Path to the plan file or directory")
-		subFs.Parse(flag.Args()[1:])
-	} else if cmd == "-h" || cmd == "--help" {
-		flag.Usage()
+	// we parse the args after the command with a flag set that also carries the
+	// command's own flags.
+	subFs := flag.NewFlagSet("plan "+cmd, flag.ExitOnError)
+	subFs.StringVar(&inputPath, "f", inputPath, "Path to the plan file or directory")
+	subFs.StringVar(&inputPath, "file", inputPath, "Path to the plan file or directory")
+	subFs.StringVar(&configOpts.Env, "env", configOpts.Env, "Environment, selecting settings.<env>.json")
+	subFs.Var((*stringList)(&configOpts.Overrides), "set", "Override a setting, as key=value (repeatable)")
+	opts.register(cmd, subFs)
+	cmdArgs = parseArgs(subFs, flag.Args()[1:])
+
+	// With --json, stdout only carries the build summary.
+	stdout := os.Stdout
+	if cmd == "build" && opts.Build.JSON {
+		os.Stdout = os.Stderr
+	}
+
+	// init creates the plan, so there is no context yet.
+	if cmd == "init" {
+		dir := inputPath
+		if len(cmdArgs) > 0 {
+			dir = cmdArgs[0]
+		}
+		initCmd(dir, opts.Init)
+		return
+	}
+
+	// Validation reports problems itself, so it must not need a context.
+	if cmd == "config" && len(cmdArgs) > 0 && cmdArgs[0] == "validate" {
+		validateConfig(inputPath, configOpts)
 		return
 	}
 
 	// Initialize Context
-	ctx, err := initContext(inputPath)
+	ctx, err := initContext(inputPath, configOpts)
 	if err != nil {
 		log.Fatalf("Initialization failed: %v", err)
 	}
@@ -117,26 +173,60 @@ func main() {
 	case "preview":
 		preview(ctx)
 	case "build":
-		build(ctx)
+		buildCmd(ctx, opts.Build, stdout)
+	case "serve":
+		serve(ctx, opts.Serve)
 	case "save":
 		save(ctx)
 	case "publish":
 		publish(ctx)
+	case "deploy":
+		deployCmd(ctx, cmdArgs, opts.Deploy)
+	case "websub-hub":
+		websubHub(ctx, opts.WebSub)
+	case "websub-notify":
+		websubNotify(ctx)
 	case "revert":
 		revert(ctx)
 	case "rollback":
-		args := flag.CommandLine.Args()
 		commit := ""
-		if len(args) > 0 {
-			commit = args[0]
+		if len(cmdArgs) > 0 {
+			commit = cmdArgs[0]
 		}
 		rollback(ctx, commit)
 	case "edit":
 		edit(ctx)
+	case "show":
+		spec := "latest"
+		if len(cmdArgs) > 0 {
+			spec = cmdArgs[0]
+		}
+		show(ctx, spec)
+	case "diff":
+		from, to := "latest", "working"
+		if len(cmdArgs) > 0 {
+			from = cmdArgs[0]
+		}
+		if len(cmdArgs) > 1 {
+			to = cmdArgs[1]
+		}
+		diffCmd(ctx, from, to, opts.Diff)
+	case "mail":
+		if err := mailCmd(ctx, opts.Mail); err != nil {
+			log.Fatalf("Mail failed: %v", err)
+		}
+	case "config":
+		if len(cmdArgs) > 0 {
+			fmt.Printf("Unknown config command: %s (did you mean 'plan config validate'?)\n", cmdArgs[0])
+			os.Exit(1)
+		}
+		configCmd(ctx)
+	case "template":
+		templateCmd(ctx, cmdArgs)
+	case "theme":
+		themeCmd(ctx, cmdArgs, opts.Theme)
 	case "debug":
 		debugCmd(ctx)
-	case "-h", "--help":
-		flag.Usage()
 	default:
 		if strings.HasPrefix(cmd, "-") {
 			fmt.Printf("Unknown command or invalid usage: %s\n", cmd)
@@ -149,6 +239,88 @@ func main() {
 	}
 }
 
+// cmdOptions holds flags that only apply to a single command.
+type cmdOptions struct {
+	Build  buildOptions
+	Diff   diffOptions
+	Serve  serveOptions
+	Mail   mailOptions
+	Deploy deployOptions
+	Theme  themeOptions
+	Init   initOptions
+	WebSub websubOptions
+}
+
+// register adds the flags for cmd to fs.
+func (o *cmdOptions) register(cmd string, fs *flag.FlagSet) {
+	switch cmd {
+	case "build":
+		fs.BoolVar(&o.Build.JSON, "json", false, "Print the build manifest as JSON instead of progress")
+		fs.BoolVar(&o.Build.Clean, "clean", false, "Also remove files in the output that no build wrote")
+		fs.BoolVar(&o.Build.RestorePrevious, "restore-previous", false, "Put back the output the last build replaced, instead of building")
+		fs.StringVar(&o.Build.At, "at", "", "Build as if it were this time (RFC 3339, or YYYY-MM-DD [HH:MM] in the plan's timezone), into public.at/ unless -o is given")
+		fs.StringVar(&o.Build.Output, "o", "", "Write the site to this directory instead of public/")
+	case "diff":
+		fs.BoolVar(&o.Diff.Rendered, "rendered", false, "Compare the rendered text instead of the markdown source")
+		fs.BoolVar(&o.Diff.Words, "word", false, "Show a word diff instead of a unified diff")
+		fs.IntVar(&o.Diff.Context, "U", 3, "Number of context lines")
+	case "serve":
+		fs.StringVar(&o.Serve.Addr, "addr", ":8080", "Address to listen on")
+		fs.DurationVar(&o.Serve.Poll, "poll", time.Minute, "How often to check the repo for new commits (0 to disable)")
+	case "mail":
+		fs.BoolVar(&o.Mail.DryRun, "dry-run", false, "Write the message to an .eml file instead of sending it")
+		fs.BoolVar(&o.Mail.Force, "force", false, "Send even if the latest version was already mailed")
+		fs.StringVar(&o.Mail.Out, "out", "", "Directory for --dry-run messages (default .plan/mail)")
+	case "websub-hub":
+		fs.StringVar(&o.WebSub.Addr, "addr", ":8082", "Address to listen on")
+	case "deploy":
+		fs.BoolVar(&o.Deploy.DryRun, "dry-run", false, "Show what would change without changing anything")
+		fs.BoolVar(&o.Deploy.NoBuild, "no-build", false, "Deploy the existing output without building first")
+	case "theme":
+		fs.BoolVar(&o.Theme.Force, "force", false, "Overwrite an existing template.html when ejecting")
+	case "init":
+		fs.StringVar(&o.Init.Username, "username", "", "Username (default $USER)")
+		fs.StringVar(&o.Init.Name, "name", "", "Your name")
+		fs.StringVar(&o.Init.Title, "title", "", "Title of the plan")
+		fs.StringVar(&o.Init.Timezone, "timezone", "", "IANA time zone, e.g. America/New_York")
+		fs.StringVar(&o.Init.BaseURL, "base-url", "", "URL the plan will be published at")
+		fs.StringVar(&o.Init.Theme, "theme", "", "Built-in theme to use (see 'plan theme list')")
+		fs.BoolVar(&o.Init.Eject, "eject", false, "Copy the theme to template.html to customize it")
+		fs.BoolVar(&o.Init.Yes, "yes", false, "Use the defaults instead of asking")
+	}
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// parseArgs parses fs from args, allowing flags and positional arguments to
+// be interleaved. Arguments that look like negative offsets (e.g. -7d) are
+// treated as positional.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for len(args) > 0 {
+		if offsetSpecRe.MatchString(args[0]) {
+			positional = append(positional, args[0])
+			args = args[1:]
+			continue
+		}
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) > 0 {
+			positional = append(positional, args[0])
+			args = args[1:]
+		}
+	}
+	return positional
+}
+
 func debugCmd(ctx *PlanContext) {
 	writeDebugInfo(os.Stdout, ctx)
 }
@@ -230,40 +402,22 @@ func writeDebugInfo(w io.Writer, ctx *PlanContext) {
 
 // initContext resolves the plan directory and file, loads configuration, and reads any custom template.
 // It handles both file paths (-f plan.md) and directory paths (-f ./my-plan).
-func initContext(path string) (*PlanContext, error) {
-	info, err := os.Stat(path)
-	if err != nil {
-		return nil, fmt.Errorf("stat input path: %w", err)
-	}
-
-	var planDir, planFile string
-	if info.IsDir() {
-		planDir = path
-		planFile = "plan.md"
-	} else {
-		planDir = filepath.Dir(path)
-		planFile = filepath.Base(path)
-	}
-
-	// Abs path for clarity
-	absDir, err := filepath.Abs(planDir)
+func initContext(path string, configOpts config.Options) (*PlanContext, error) {
+	planDir, planFile, err := resolvePlanPath(path)
 	if err != nil {
 		return nil, err
 	}
-	planDir = absDir
 
 	// Load Config
-	cfg, err := config.Load(filepath.Join(planDir, "settings.json"))
+	cfg, sources, err := config.LoadLayers(planDir, configOpts)
 	if err != nil {
-		log.Printf("Warning: Failed to load settings.json, using defaults: %v", err)
-		cfg = config.DefaultConfig()
+		return nil, fmt.Errorf("loading config: %w", err)
 	}
 
 	// Load Template
-	tmplContent := ""
-	tmplPath := filepath.Join(planDir, "template.html")
-	if tmplBytes, err := os.ReadFile(tmplPath); err == nil {
-		tmplContent = string(tmplBytes)
+	tmplContent, err := plan.LoadTemplate(planDir, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("theme (from %s): %w", sources["theme"], err)
 	}
 
 	// Ensure we have full git history for accurate building
@@ -293,9 +447,37 @@ func initContext(path string) (*PlanContext, error) {
 		Template:     tmplContent,
 		CreationTime: creationTime,
 		HasAssets:    hasAssets,
+
+		ConfigOptions: configOpts,
+		ConfigSources: sources,
 	}, nil
 }
 
+// resolvePlanPath returns the absolute plan directory and the plan file
+// within it for a -f argument, which may name either.
+func resolvePlanPath(path string) (string, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", fmt.Errorf("stat input path: %w", err)
+	}
+
+	var planDir, planFile string
+	if info.IsDir() {
+		planDir = path
+		planFile = "plan.md"
+	} else {
+		planDir = filepath.Dir(path)
+		planFile = filepath.Base(path)
+	}
+
+	// Abs path for clarity
+	absDir, err := filepath.Abs(planDir)
+	if err != nil {
+		return "", "", err
+	}
+	return absDir, planFile, nil
+}
+
 func ensureFullHistory(dir string) {
 	// Check for shallow clone
 	if _, err := os.Stat(filepath.Join(dir, ".git", "shallow")); err == nil {
@@ -336,104 +518,143 @@ func getCreationTime(dir, file string) time.Time {
 	return time.Time{}
 }
 
+// liveEvent is sent to preview pages over /events. A "morph" event carries
+// the rebuilt page at Path, whose content the page patches in place; a
+// "reload" event makes every page reload; a "status" event changes nothing
+// but the errors shown. Every event carries the errors of the latest build.
+type liveEvent struct {
+	Type   string       `json:"type"`
+	Path   string       `json:"path,omitempty"`
+	HTML   string       `json:"html,omitempty"`
+	Errors []buildError `json:"errors,omitempty"`
+}
+
 func preview(ctx *PlanContext) {
 	ctx.LiveReload = true
 	port := "8081"
+
+	if ctx.Template != "" {
+		reportTemplateProblems(os.Stdout, "template.html", render.CheckTemplate(ctx.Template))
+	}
 	
-	// Initial build
-	build(ctx)
+	// Initial build. Preview keeps running when it fails, showing the
+	// errors in the browser until the next build succeeds.
+	var status buildStatus
+	if err := buildPreview(ctx); err != nil {
+		log.Printf("Warning: Build failed: %v", err)
+		status.SetPage(buildErrors(ctx, err))
+		if err := writeErrorPage(ctx); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	mux := newSiteMux(ctx)
+	site := newPreviewSite(ctx, mux)
 
 	// Setup SSE
-	reloadCh := make(chan struct{})
+	reloadCh := make(chan []byte)
 	
 	// Setup File Watcher
-	watcher, err := fsnotify.NewWatcher()
+	watcher, err := newPlanWatcher(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer watcher.Close()
 
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
+	// rebuildPage builds the current page, recording whether it failed. The
+	// last good build stays in place when it does.
+	rebuildPage := func() bool {
+		err := buildIndex(ctx)
+		status.SetPage(buildErrors(ctx, err))
+		if err != nil {
+			log.Printf("Warning: Build failed: %v", err)
+		}
+		return err == nil
+	}
+
+	go watcher.Run(func(changes planChanges) {
+		start := time.Now()
+		failedBefore := status.PageFailed()
+		live := liveEvent{Type: "status"}
+		switch {
+		case changes.Config:
+			// Every page changes, so history pages are rendered again on
+			// their next request and open pages reload.
+			fmt.Println("Change detected in settings or template, rebuilding...")
+			site.Update(func() {
+				err := reloadConfig(ctx)
+				status.SetConfig(buildErrors(ctx, err))
+				if err != nil {
+					log.Printf("Warning: Keeping the previous settings and template: %v", err)
+				} else if _, err := os.Stat(filepath.Join(ctx.PlanDir, "template.html")); err == nil {
+					reportTemplateProblems(os.Stdout, "template.html", render.CheckTemplate(ctx.Template))
 				}
-				// Rebuild on write to plan file or template
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					filename := filepath.Base(event.Name)
-					if filename == ctx.PlanFile || filename == "template.html" || filename == "settings.json" {
-						fmt.Printf("Change detected in %s, rebuilding...\n", filename)
-						// Re-initialize context to catch settings/template changes
-						// But for simplicity, we just rebuild mostly. 
-						// To properly reload settings/template, we'd need to re-run init logic.
-						// For now, let's just re-read the template if it changed? 
-						// Simpler: Just call build. If template changed, we might need to reload it.
-						// Let's do a partial context refresh if needed, but for now just Build.
-						// Actually build() re-reads plan.md, but initContext loaded template. 
-						// So if template.html changes, we need to update ctx.Template.
-						
-						if filename == "template.html" {
-							if tmplBytes, err := os.ReadFile(filepath.Join(ctx.PlanDir, "template.html")); err == nil {
-								ctx.Template = string(tmplBytes)
-							}
-						}
-
-						build(ctx)
-						
-						// Notify clients
-						// Non-blocking send
-						go func() {
-							// Give time for file system to settle / browser to be ready?
-							// Send multiple signals?
-							reloadCh <- struct{}{} 
-						}()
-					}
+				if rebuildPage() {
+					live = liveEvent{Type: "reload"}
 				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
+				writeSitePages(ctx)
+			})
+		case changes.Plan:
+			// Edits to the plan only change its content, which pages patch
+			// in place, unless they are showing a failed build.
+			fmt.Printf("Change detected in %s, rebuilding...\n", ctx.PlanFile)
+			if !rebuildPage() {
+				break
+			}
+			page, err := os.ReadFile(filepath.Join(ctx.OutputDir, "index.html"))
+			if err != nil || failedBefore {
+				live = liveEvent{Type: "reload"}
+				break
+			}
+			live = liveEvent{Type: "morph", Path: ctx.BasePath + "/index.html", HTML: string(page)}
+		}
+		if len(changes.Assets) > 0 {
+			// Assets are copied one at a time as they change.
+			fmt.Println("Change detected in assets, copying...")
+			for _, path := range changes.Assets {
+				if err := syncAsset(ctx, path); err != nil {
+					log.Printf("Warning: Failed to copy %s: %v", path, err)
 				}
-				log.Println("error:", err)
 			}
+			live = liveEvent{Type: "reload"}
 		}
-	}()
+		live.Errors = status.Errors()
+		fmt.Printf("Rebuilt in %v\n", time.Since(start).Round(time.Millisecond))
 
-	err = watcher.Add(ctx.PlanDir)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	if ctx.HasAssets {
-		err = watcher.Add(filepath.Join(ctx.PlanDir, "assets"))
+		msg, err := json.Marshal(live)
 		if err != nil {
-			log.Printf("Warning: Failed to watch assets directory: %v", err)
+			log.Printf("Warning: %v", err)
+			return
 		}
-	}
 
-	mux := http.NewServeMux()
-	
+		// Notify clients
+		// Non-blocking send
+		go func() {
+			reloadCh <- msg
+		}()
+	})
+
 	// Re-implementing the handler logic properly
 	
-	broker := make(chan chan struct{})
-	remove := make(chan chan struct{})
-	broadcast := make(chan struct{})
+	broker := make(chan chan []byte)
+	remove := make(chan chan []byte)
+	broadcast := make(chan []byte)
 	
 	go func() {
-		active := make(map[chan struct{}]bool)
+		active := make(map[chan []byte]bool)
 		for {
 			select {
 			case c := <-broker:
 				active[c] = true
 			case c := <-remove:
 				delete(active, c)
-			case <-broadcast:
+			case msg := <-broadcast:
 				for c := range active {
+					// A client that is behind only needs the latest page.
 					select {
-					case c <- struct{}{}:
+					case <-c:
 					default:
 					}
+					c <- msg
 				}
 			}
 		}
@@ -441,8 +662,8 @@ func preview(ctx *PlanContext) {
 	
 	// Hook watcher to broadcast
 	go func() {
-		for range reloadCh {
-			broadcast <- struct{}{}
+		for msg := range reloadCh {
+			broadcast <- msg
 		}
 	}()
 
@@ -457,10 +678,18 @@ func preview(ctx *PlanContext) {
 			return
 		}
 
-		ch := make(chan struct{}, 1)
+		ch := make(chan []byte, 1)
 		broker <- ch
 		defer func() { remove <- ch }()
 
+		// Pages opened while a build is failing show its errors at once.
+		if errs := status.Errors(); len(errs) > 0 {
+			if msg, err := json.Marshal(liveEvent{Type: "status", Errors: errs}); err == nil {
+				fmt.Fprintf(w, "data: %s\n\n", msg)
+				flusher.Flush()
+			}
+		}
+
 		// Heartbeat to keep connection alive
 		ticker := time.NewTicker(15 * time.Second)
 		defer ticker.Stop()
@@ -469,8 +698,8 @@ func preview(ctx *PlanContext) {
 			select {
 			case <-r.Context().Done():
 				return
-			case <-ch:
-				fmt.Fprintf(w, "data: reload\n\n")
+			case msg := <-ch:
+				fmt.Fprintf(w, "data: %s\n\n", msg)
 				flusher.Flush()
 			case <-ticker.C:
 				fmt.Fprintf(w, ": heartbeat\n\n")
@@ -479,9 +708,6 @@ func preview(ctx *PlanContext) {
 		}
 	})
 
-	fs := http.FileServer(http.Dir(ctx.OutputDir))
-	mux.Handle("/", fs)
-
 	fmt.Printf("Starting preview server at http://localhost:%s/index.html\n", port)
 	fmt.Println("Watching for changes...")
 
@@ -490,276 +716,48 @@ func preview(ctx *PlanContext) {
 		openBrowser("http://localhost:" + port + "/index.html")
 	}()
 
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
+	if err := http.ListenAndServe(":"+port, site); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func build(ctx *PlanContext) {
-	fullPath := filepath.Join(ctx.PlanDir, ctx.PlanFile)
-	fmt.Printf("Building %s...\n", fullPath)
-
-	content, err := os.ReadFile(fullPath)
-	if err != nil {
-		log.Fatalf("Failed to read file: %v", err)
-	}
-	info, err := os.Stat(fullPath)
+// build generates the site in ctx.OutputDir.
+func build(ctx *PlanContext) (*plan.Result, error) {
+	b, err := ctx.builder()
 	if err != nil {
-		log.Fatalf("Failed to stat file: %v", err)
-	}
-
-	if err := renderAndWrite(ctx, content, info.ModTime(), filepath.Join(ctx.OutputDir, "index.html"), ""); err != nil {
-		log.Fatalf("Failed to build current page: %v", err)
-	}
-
-	// Copy Assets
-	if ctx.HasAssets {
-		src := filepath.Join(ctx.PlanDir, "assets")
-		dst := filepath.Join(ctx.OutputDir, "assets")
-		if err := os.MkdirAll(dst, 0755); err != nil {
-			log.Printf("Warning: Failed to create assets directory: %v", err)
-		} else {
-			if err := os.CopyFS(dst, os.DirFS(src)); err != nil {
-				log.Printf("Warning: Failed to copy assets: %v", err)
-			}
-		}
+		return nil, err
 	}
-
-	// Prepare RSS items
-	var rssItems []Item
-
-	// Build history items
-	// The history represents the authoritative list of published posts
-	historyItems, err := buildHistory(ctx)
+	fmt.Printf("Building %s...\n", filepath.Join(ctx.PlanDir, ctx.PlanFile))
+	res, err := b.Build()
 	if err != nil {
-		log.Printf("Warning: Failed to build history (is this a git repo?): %v", err)
+		return nil, err
 	}
-	rssItems = append(rssItems, historyItems...)
 
-	// Generate RSS Feed
-	rss := Rss{
-		Version:   "2.0",
-		ContentNs: "http://purl.org/rss/1.0/modules/content/",
-		Channel: Channel{
-			Title:       ctx.Config.Title,
-			Link:        ctx.Config.BaseURL,
-			Description: fmt.Sprintf("Updates for %s", ctx.Config.Title),
-			Items:       rssItems,
-		},
+	// A build at another time is only a simulation.
+	if ctx.Config.SendWebmentions && !ctx.LiveReload && ctx.At.IsZero() {
+		sendWebmentions(ctx)
 	}
-
-	rssFile := filepath.Join(ctx.OutputDir, "rss.xml")
-	f, err := os.Create(rssFile)
-	if err != nil {
-		log.Fatalf("Failed to create RSS file: %v", err)
+	if page, ok := writeDebugPage(ctx, b); ok {
+		res.Pages = append(res.Pages, page)
 	}
-	defer f.Close()
 
-	f.WriteString(xml.Header)
-	enc := xml.NewEncoder(f)
-	enc.Indent("", "  ")
-	if err := enc.Encode(rss); err != nil {
-		log.Fatalf("Failed to encode RSS: %v", err)
-	}
+	fmt.Println("Build complete.")
+	return res, nil
+}
 
-	// Generate Debug Page
+// writeDebugPage writes the output of `plan debug` to /debug/.
+func writeDebugPage(ctx *PlanContext, b *plan.Builder) (plan.Page, bool) {
 	var debugBuf bytes.Buffer
 	writeDebugInfo(&debugBuf, ctx)
-	
-	// Reuse renderer to wrap in template
+
 	// We wrap the raw text in a <pre> block for the content
 	debugContent := fmt.Sprintf("# Debug Info\n\n```text\n%s\n```", debugBuf.String())
-	// Debug page is 1 level deep: /debug/
-	if err := renderAndWrite(ctx, []byte(debugContent), time.Now(), filepath.Join(ctx.OutputDir, "debug", "index.html"), "../"); err != nil {
-		log.Printf("Warning: Failed to generate debug page: %v", err)
-	}
-
-	// Generate 404 Page
-	// 404.html is at the root
-	if err := renderAndWrite(ctx, []byte("Not found."), time.Now(), filepath.Join(ctx.OutputDir, "404.html"), ""); err != nil {
-		log.Printf("Warning: Failed to generate 404 page: %v", err)
-	}
-
-	fmt.Println("Build complete.")
-}
-
-// buildHistory reconstructs the past versions of the plan file using git history.
-// It iterates through unique dates in the git log, retrieves the file content for that date,
-// and generates static pages for each day, as well as year and month index pages.
-func buildHistory(ctx *PlanContext) ([]Item, error) {
-	fmt.Println("Building history...")
-
-	history, err := getGitHistory(ctx.PlanDir, ctx.PlanFile)
+	page, err := b.WritePage("debug/index.html", []byte(debugContent), time.Now())
 	if err != nil {
-		return nil, err
-	}
-
-	var dates []string
-	for d := range history {
-		dates = append(dates, d)
-	}
-	sort.Strings(dates)
-	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
-
-	type dayEntry struct {
-		DateStr string
-		Path    string
-	}
-	tree := make(map[string]map[string][]dayEntry)
-	var rssItems []Item
-
-	// Reuse renderer if config doesn't change per file
-	// We'll create a new one inside renderAndWrite for each page anyway, 
-	// but for RSS item content we need one here.
-	r := render.New(&ctx.Config, ctx.Template, false, "")
-
-	for _, dateStr := range dates {
-		info := history[dateStr]
-		year := info.Time.Format("2006")
-		month := info.Time.Format("01")
-		day := info.Time.Format("02")
-
-		content, err := getGitContent(ctx.PlanDir, info.Hash, ctx.PlanFile)
-		if err != nil {
-			log.Printf("Failed to get content for %s: %v", dateStr, err)
-			continue
-		}
-
-		outDir := filepath.Join(ctx.OutputDir, year, month, day)
-		outPath := filepath.Join(outDir, "index.html")
-
-		// History pages are 3 levels deep: /year/month/day/
-		if err := renderAndWrite(ctx, content, info.Time, outPath, "../../../"); err != nil {
-			return nil, err
-		}
-
-		// Add to RSS
-		relPath := fmt.Sprintf("/%s/%s/%s", year, month, day)
-		link := ctx.Config.BaseURL + relPath
-
-		// We need the body content. renderAndWrite does it but doesn't return it.
-		// We'll just re-render body here.
-		bodyBytes, err := r.RenderBody(content)
-		if err == nil {
-			item := Item{
-				Title:       dateStr,
-				Link:        link,
-				Description: string(bodyBytes),
-				Content:     string(bodyBytes),
-				PubDate:     info.Time.Format(time.RFC1123Z),
-				Guid:        link,
-			}
-			rssItems = append(rssItems, item)
-		}
-
-		if tree[year] == nil {
-			tree[year] = make(map[string][]dayEntry)
-		}
-		tree[year][month] = append(tree[year][month], dayEntry{
-			DateStr: dateStr,
-			Path:    relPath,
-		})
-	}
-
-	// Generate Indices
-	for year, months := range tree {
-		var yearLinks []dayEntry
-		for _, mDays := range months {
-			yearLinks = append(yearLinks, mDays...)
-		}
-		sort.Slice(yearLinks, func(i, j int) bool {
-			return yearLinks[i].DateStr > yearLinks[j].DateStr
-		})
-
-		yearContent := fmt.Sprintf("# History for %s\n\n", year)
-		for _, link := range yearLinks {
-			yearContent += fmt.Sprintf("- [%s](%s)\n", link.DateStr, link.Path)
-		}
-		// Year index is 1 level deep: /year/
-		if err := renderAndWrite(ctx, []byte(yearContent), time.Now(), filepath.Join(ctx.OutputDir, year, "index.html"), "../"); err != nil {
-			return nil, err
-		}
-
-		for month, days := range months {
-			sort.Slice(days, func(i, j int) bool {
-				return days[i].DateStr > days[j].DateStr
-			})
-			monthName := month
-			if t, _ := time.Parse("01", month); !t.IsZero() {
-				monthName = t.Format("January")
-			}
-			monthContent := fmt.Sprintf("# History for %s %s\n\n", monthName, year)
-			for _, link := range days {
-				monthContent += fmt.Sprintf("- [%s](%s)\n", link.DateStr, link.Path)
-			}
-			// Month index is 2 levels deep: /year/month/
-			if err := renderAndWrite(ctx, []byte(monthContent), time.Now(), filepath.Join(ctx.OutputDir, year, month, "index.html"), "../../"); err != nil {
-				return nil, err
-			}
-		}
-	}
-
-	// Generate Archives Page
-	var archiveContent bytes.Buffer
-	archiveContent.WriteString("# Archives\n\n")
-
-	// Get years and sort them descending
-	var years []string
-	for y := range tree {
-		years = append(years, y)
-	}
-	sort.Sort(sort.Reverse(sort.StringSlice(years)))
-
-	for _, year := range years {
-		archiveContent.WriteString(fmt.Sprintf("## %s\n\n", year))
-
-		// Collect all days for this year
-		var yearDays []dayEntry
-		for _, days := range tree[year] {
-			yearDays = append(yearDays, days...)
-		}
-
-		// Sort days descending
-		sort.Slice(yearDays, func(i, j int) bool {
-			return yearDays[i].DateStr > yearDays[j].DateStr
-		})
-
-		for _, d := range yearDays {
-			archiveContent.WriteString(fmt.Sprintf("- [%s](%s)\n", d.DateStr, d.Path))
-		}
-		archiveContent.WriteString("\n")
-	}
-
-	// Archives page is 1 level deep: /archives/
-	if err := renderAndWrite(ctx, archiveContent.Bytes(), time.Now(), filepath.Join(ctx.OutputDir, "archives", "index.html"), "../"); err != nil {
-		return nil, err
-	}
-
-	return rssItems, nil
-}
-
-func renderAndWrite(ctx *PlanContext, content []byte, modTime time.Time, outPath string, assetPrefix string) error {
-	r := render.New(&ctx.Config, ctx.Template, ctx.LiveReload, assetPrefix)
-
-	body, err := r.RenderBody(content)
-	if err != nil {
-		return fmt.Errorf("rendering body: %w", err)
-	}
-
-	html, err := r.Compose(body, ctx.CreationTime, modTime)
-	if err != nil {
-		return fmt.Errorf("composing html: %w", err)
-	}
-
-	dir := filepath.Dir(outPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("creating dir %s: %w", dir, err)
-	}
-
-	if err := os.WriteFile(outPath, html, 0644); err != nil {
-		return fmt.Errorf("writing file %s: %w", outPath, err)
+		log.Printf("Warning: Failed to generate debug page: %v", err)
+		return plan.Page{}, false
 	}
-	return nil
+	return page, true
 }
 
 type CommitInfo struct {
@@ -767,49 +765,24 @@ type CommitInfo struct {
 	Time time.Time
 }
 
+// getGitHistory returns the last commit of file on each day, by day.
 func getGitHistory(dir, file string) (map[string]CommitInfo, error) {
-	cmd := exec.Command("git", "log", "--date=iso-strict", "--format=%H %ad", file)
-	cmd.Dir = dir
-	out, err := cmd.Output()
+	versions, err := plan.GitHistory{Dir: dir, File: file}.Versions()
 	if err != nil {
-		return nil, fmt.Errorf("git log failed: %w", err)
+		return nil, err
 	}
-
 	history := make(map[string]CommitInfo)
-	lines := strings.Split(string(out), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		hash := parts[0]
-		dateStr := parts[1]
-		
-		t, err := time.Parse(time.RFC3339, dateStr)
-		if err != nil {
-			log.Printf("Failed to parse date %s: %v", dateStr, err)
-			continue
-		}
-		
-		dateKey := t.Format("2006-01-02")
-		if _, exists := history[dateKey]; !exists {
-			history[dateKey] = CommitInfo{
-				Hash: hash,
-				Time: t,
-			}
+	for _, v := range versions {
+		// The versions are newest first, so the first of a day is its last.
+		if _, ok := history[v.Date]; !ok {
+			history[v.Date] = CommitInfo{Hash: v.Hash, Time: v.Time}
 		}
 	}
 	return history, nil
 }
 
 func getGitContent(dir, hash, file string) ([]byte, error) {
-	cmd := exec.Command("git", "show", fmt.Sprintf("%s:%s", hash, file))
-	cmd.Dir = dir
-	return cmd.Output()
+	return plan.GitHistory{Dir: dir, File: file}.Content(plan.Version{Hash: hash})
 }
 
 func runCmd(dir, name string, args ...string) error {
@@ -826,6 +799,9 @@ func save(ctx *PlanContext) {
 	if ctx.HasAssets {
 		args = append(args, "assets")
 	}
+	if _, err := os.Stat(mentionStore(ctx).Path); err == nil {
+		args = append(args, "webmentions.json")
+	}
 	if err := runCmd(ctx.PlanDir, "git", args...); err != nil {
 		log.Fatalf("Failed to add files: %v", err)
 	}
@@ -839,10 +815,21 @@ func save(ctx *PlanContext) {
 func publish(ctx *PlanContext) {
 	save(ctx)
 	fmt.Println("Pushing to origin...")
+	upstream := gitRev(ctx.PlanDir, "@{upstream}")
 	if err := runCmd(ctx.PlanDir, "git", "push"); err != nil {
 		log.Fatalf("Failed to push: %v", err)
 	}
 	fmt.Println("Successfully pushed to origin.")
+	// A push that changed nothing is not news to the hub.
+	if ctx.Config.WebSubHub != "" && gitRev(ctx.PlanDir, "@{upstream}") != upstream {
+		pingHub(ctx)
+	}
+	// The push went through; a mail that fails can be sent later.
+	if ctx.Config.Mail.AfterPublish {
+		if err := mailCmd(ctx, mailOptions{}); err != nil {
+			log.Printf("Warning: Mail failed: %v", err)
+		}
+	}
 }
 
 func revert(ctx *PlanContext) {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/pkg/plan"
+)
+
+type buildOptions struct {
+	JSON            bool
+	Clean           bool
+	RestorePrevious bool
+	At              string
+	Output          string
+}
+
+// atOutputDir is where a build --at goes without -o, so that it never takes
+// the place of the real site.
+const atOutputDir = "public.at"
+
+// buildSummary is what `plan build --json` prints: the manifest, any
+// warnings from the build, and when to build again for an embargoed version.
+type buildSummary struct {
+	*plan.Manifest
+	Warnings    []string   `json:"warnings"`
+	NextPublish *time.Time `json:"next_publish"`
+}
+
+// buildCmd runs `plan build`. With --json, the summary is written to w and
+// progress goes to stderr.
+func buildCmd(ctx *PlanContext, opts buildOptions, w io.Writer) {
+	switch {
+	case opts.Output != "":
+		out, err := filepath.Abs(opts.Output)
+		if err != nil {
+			log.Fatalf("Invalid -o: %v", err)
+		}
+		ctx.OutputDir = out
+	case opts.At != "" && !opts.RestorePrevious:
+		ctx.OutputDir = filepath.Join(ctx.PlanDir, atOutputDir)
+	}
+
+	if opts.RestorePrevious {
+		if err := restorePrevious(ctx); err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		fmt.Printf("Restored the previous build of %s.\n", ctx.OutputDir)
+		return
+	}
+
+	if opts.At != "" {
+		at, err := parseAt(opts.At, ctx.Location())
+		if err != nil {
+			log.Fatalf("Invalid --at: %v", err)
+		}
+		ctx.At = at
+		fmt.Printf("Building as of %s into %s.\n", at.Format(time.RFC3339), ctx.OutputDir)
+	}
+
+	res, manifest, err := buildSite(ctx, opts.Clean)
+	if err != nil {
+		log.Fatalf("Build failed: %v", err)
+	}
+	if !opts.JSON {
+		c := manifest.Changes
+		fmt.Printf("Wrote %d files (%d added, %d changed, %d removed).\n", len(manifest.Files), len(c.Added), len(c.Changed), len(c.Removed))
+		if !res.NextPublish.IsZero() {
+			fmt.Printf("An embargoed version is due at %s; build again then.\n", res.NextPublish.In(ctx.Location()).Format(time.RFC3339))
+		}
+		return
+	}
+	summary := buildSummary{Manifest: manifest, Warnings: res.Warnings}
+	if summary.Warnings == nil {
+		summary.Warnings = []string{}
+	}
+	if !res.NextPublish.IsZero() {
+		next := res.NextPublish.UTC()
+		summary.NextPublish = &next
+	}
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+// parseAt parses the time given to --at: RFC 3339, or a date with an
+// optional time of day in loc.
+func parseAt(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time, want RFC 3339 or YYYY-MM-DD [HH:MM]", s)
+}
+
+// buildSite builds the site, or every plan on a host, and writes its
+// manifest, with the changes since the previous build. Files the previous
+// build wrote that this one did not are removed; with clean, so is every
+// other file in the output. The build is staged, replacing the output only
+// if it succeeds.
+func buildSite(ctx *PlanContext, clean bool) (*plan.Result, *plan.Manifest, error) {
+	var res *plan.Result
+	var manifest *plan.Manifest
+	err := staged(ctx, func(ctx *PlanContext) error {
+		var err error
+		if isHostDir(ctx) {
+			res, err = buildHost(ctx)
+		} else {
+			res, err = build(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(ctx.OutputDir, plan.ManifestFile)
+		prev := readManifest(path)
+		b, err := ctx.builder()
+		if err != nil {
+			return err
+		}
+		removed, err := b.Prune(prev, res, clean)
+		if err != nil {
+			return fmt.Errorf("removing stale output: %w", err)
+		}
+		if len(removed) > 0 {
+			fmt.Printf("Removed %d stale files.\n", len(removed))
+		}
+
+		manifest = plan.NewManifest(res.Pages, time.Now().UTC(), prev)
+		data, err := manifest.JSON()
+		if err != nil {
+			return err
+		}
+		if _, err := b.WriteFile(plan.Page{Path: plan.ManifestFile}, data); err != nil {
+			return fmt.Errorf("writing manifest: %w", err)
+		}
+		return nil
+	})
+	return res, manifest, err
+}
+
+// readManifest returns the manifest at path, or nil if there is none yet.
+func readManifest(path string) *plan.Manifest {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		var m *plan.Manifest
+		if m, err = plan.ParseManifest(data); err == nil {
+			return m
+		}
+	}
+	log.Printf("Warning: Ignoring the previous manifest: %v", err)
+	return nil
+}
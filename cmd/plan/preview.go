@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+	"github.com/dewitt/a-simple-plan/internal/render"
+	"github.com/dewitt/a-simple-plan/pkg/plan"
+)
+
+var (
+	yearPathRe  = regexp.MustCompile(`^/\d{4}/$`)
+	monthPathRe = regexp.MustCompile(`^/\d{4}/\d{2}/$`)
+)
+
+// previewSite serves the preview. Only the current page is built up front;
+// the pages of past days, the history indexes and the feed are rendered on
+// first request, before next serves them, and kept until HEAD moves or the
+// site is updated.
+type previewSite struct {
+	ctx  *PlanContext
+	next http.Handler
+
+	mu       sync.Mutex
+	head     string
+	builder  *plan.Builder   // reads the history as of head
+	rendered map[string]bool // site paths rendered from history
+	complete bool            // the whole history has been rendered
+}
+
+func newPreviewSite(ctx *PlanContext, next http.Handler) *previewSite {
+	return &previewSite{ctx: ctx, next: next}
+}
+
+// Update runs f, which may change the context or the output, without
+// requests rendering at the same time. Pages rendered before are rendered
+// again on their next request.
+func (s *previewSite) Update(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+	s.builder = nil
+}
+
+func (s *previewSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.render(r.URL.Path); err != nil {
+		log.Printf("Warning: Failed to render %s: %v", r.URL.Path, err)
+	}
+	s.next.ServeHTTP(w, r)
+}
+
+// render renders the history page at the site path p, if it is one and has
+// not been rendered since HEAD last moved.
+func (s *previewSite) render(p string) error {
+	p = strings.TrimSuffix(p, "index.html")
+	date, isDay := plan.ParseDayPath(p)
+	isIndex := yearPathRe.MatchString(p) || monthPathRe.MatchString(p) || p == "/archives/"
+	isFeed := p == "/rss.xml" || strings.HasPrefix(p, "/"+plan.FeedArchiveDir+"/")
+	if !isDay && !isIndex && !isFeed {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if head := gitHead(s.ctx.PlanDir); s.builder == nil || head != s.head {
+		b, err := s.ctx.builder()
+		if err != nil {
+			return err
+		}
+		s.head, s.builder, s.rendered, s.complete = head, b, make(map[string]bool), false
+	}
+	if s.complete {
+		return nil
+	}
+
+	start := time.Now()
+	switch {
+	case isFeed:
+		// The feed needs every day, so render them all.
+		_, items, err := s.builder.BuildHistory()
+		if err != nil {
+			return err
+		}
+		if _, err := s.builder.WriteFeed(items); err != nil {
+			return err
+		}
+		s.complete = true
+	case isIndex:
+		if s.rendered["indexes"] {
+			return nil
+		}
+		if _, err := s.builder.BuildIndexes(); err != nil {
+			return err
+		}
+		s.rendered["indexes"] = true
+	default:
+		if s.rendered[p] {
+			return nil
+		}
+		_, err := s.builder.BuildDay(date)
+		if errors.Is(err, plan.ErrNoVersion) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.rendered[p] = true
+	}
+	fmt.Printf("Rendered %s in %v\n", p, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// buildPreview builds what preview serves up front: the current page, the
+// assets and the site's own pages.
+func buildPreview(ctx *PlanContext) error {
+	b, err := ctx.builder()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Building %s...\n", filepath.Join(ctx.PlanDir, ctx.PlanFile))
+	_, err = b.BuildIndex()
+	if _, err := b.CopyAssets(); err != nil {
+		log.Printf("Warning: Failed to copy assets: %v", err)
+	}
+	writeSitePages(ctx)
+	return err
+}
+
+// buildIndex builds the current page.
+func buildIndex(ctx *PlanContext) error {
+	b, err := ctx.builder()
+	if err != nil {
+		return err
+	}
+	_, err = b.BuildIndex()
+	return err
+}
+
+// writeSitePages writes the debug and 404 pages.
+func writeSitePages(ctx *PlanContext) {
+	b, err := ctx.builder()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	writeDebugPage(ctx, b)
+	if _, err := b.BuildNotFound(); err != nil {
+		log.Printf("Warning: Failed to generate 404 page: %v", err)
+	}
+}
+
+// writeErrorPage writes a stand-in for the current page when there is no
+// earlier build of it, so there is a page to show build errors on.
+func writeErrorPage(ctx *PlanContext) error {
+	path := filepath.Join(ctx.OutputDir, "index.html")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	page, err := render.New(&ctx.Config, "", true, "").Compose([]byte("<p>The plan could not be built.</p>\n"), ctx.CreationTime, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ctx.OutputDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, page, 0644)
+}
+
+// buildStatus holds the errors of the latest builds in preview, which pages
+// show until a build succeeds.
+type buildStatus struct {
+	mu     sync.Mutex
+	config []buildError // loading the settings and template
+	page   []buildError // building the current page
+}
+
+func (s *buildStatus) SetConfig(errs []buildError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = errs
+}
+
+func (s *buildStatus) SetPage(errs []buildError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.page = errs
+}
+
+// PageFailed reports whether the current page failed to build last time.
+func (s *buildStatus) PageFailed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.page) > 0
+}
+
+func (s *buildStatus) Errors() []buildError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Concat(s.config, s.page)
+}
+
+// syncAsset copies the asset at path, which changed, to the output, or
+// removes it from the output if it no longer exists.
+func syncAsset(ctx *PlanContext, path string) error {
+	rel, err := filepath.Rel(filepath.Join(ctx.PlanDir, "assets"), path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("%s is not an asset", path)
+	}
+	b, err := ctx.builder()
+	if err != nil {
+		return err
+	}
+	_, err = b.SyncAsset(rel)
+	return err
+}
+
+// watchDebounce is how long the watcher waits for changes to settle, so an
+// editor's save (often a write to a temporary file and a rename) is handled
+// once.
+const watchDebounce = 20 * time.Millisecond
+
+// planChanges is a batch of changes to the files a plan is built from.
+type planChanges struct {
+	Plan   bool     // the plan file
+	Config bool     // settings or template.html
+	Assets []string // paths under assets/
+}
+
+// planWatcher watches the plan directory and, recursively, its assets.
+type planWatcher struct {
+	ctx       *PlanContext
+	w         *fsnotify.Watcher
+	assetsDir string
+}
+
+func newPlanWatcher(ctx *PlanContext) (*planWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	pw := &planWatcher{ctx: ctx, w: w, assetsDir: filepath.Join(ctx.PlanDir, "assets")}
+	if err := w.Add(ctx.PlanDir); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if ctx.HasAssets {
+		pw.watchTree(pw.assetsDir)
+	}
+	return pw, nil
+}
+
+func (pw *planWatcher) Close() error {
+	return pw.w.Close()
+}
+
+// Run calls onChange with each batch of changes until the watcher is closed.
+func (pw *planWatcher) Run(onChange func(planChanges)) {
+	var (
+		pending planChanges
+		timer   = time.NewTimer(0)
+	)
+	<-timer.C
+	for {
+		select {
+		case event, ok := <-pw.w.Events:
+			if !ok {
+				return
+			}
+			if pw.note(&pending, event) {
+				timer.Reset(watchDebounce)
+			}
+		case <-timer.C:
+			onChange(pending)
+			pending = planChanges{}
+		case err, ok := <-pw.w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Warning: watching files: %v", err)
+		}
+	}
+}
+
+// note adds event to changes, and reports whether it was relevant.
+func (pw *planWatcher) note(changes *planChanges, event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	if event.Name == pw.assetsDir || strings.HasPrefix(event.Name, pw.assetsDir+string(filepath.Separator)) {
+		// New directories, including assets/ itself, are watched too.
+		if event.Has(fsnotify.Create) {
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+				pw.watchTree(event.Name)
+				pw.ctx.HasAssets = true
+			}
+		}
+		if !slices.Contains(changes.Assets, event.Name) {
+			changes.Assets = append(changes.Assets, event.Name)
+		}
+		return true
+	}
+	if filepath.Dir(event.Name) != pw.ctx.PlanDir {
+		return false
+	}
+	switch name := filepath.Base(event.Name); {
+	case name == pw.ctx.PlanFile:
+		changes.Plan = true
+	case name == "template.html" || name == "settings.json" ||
+		(pw.ctx.ConfigOptions.Env != "" && name == "settings."+pw.ctx.ConfigOptions.Env+".json"):
+		changes.Config = true
+	default:
+		return false
+	}
+	return true
+}
+
+// watchTree watches dir and every directory below it.
+func (pw *planWatcher) watchTree(dir string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if err := pw.w.Add(path); err != nil {
+			log.Printf("Warning: Failed to watch %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// reloadConfig loads the plan's settings and template again. The context is
+// left as it was if they have problems.
+func reloadConfig(ctx *PlanContext) error {
+	cfg, sources, err := config.LoadLayers(ctx.PlanDir, ctx.ConfigOptions)
+	if err != nil {
+		return err
+	}
+	tmpl, err := plan.LoadTemplate(ctx.PlanDir, cfg)
+	if err != nil {
+		return fmt.Errorf("theme (from %s): %w", sources["theme"], err)
+	}
+	ctx.Config, ctx.ConfigSources, ctx.Template = cfg, sources, tmpl
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+)
+
+func newTestWatcher(t *testing.T) *planWatcher {
+	t.Helper()
+	dir := t.TempDir()
+	ctx := &PlanContext{PlanDir: dir, PlanFile: "plan.md", ConfigOptions: config.Options{Env: "staging"}}
+	pw, err := newPlanWatcher(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pw.Close() })
+	return pw
+}
+
+func TestPlanWatcher_Note(t *testing.T) {
+	pw := newTestWatcher(t)
+	dir := pw.ctx.PlanDir
+	event := func(name string, op fsnotify.Op) fsnotify.Event {
+		return fsnotify.Event{Name: filepath.Join(dir, filepath.FromSlash(name)), Op: op}
+	}
+
+	var changes planChanges
+	for _, e := range []fsnotify.Event{
+		event("plan.md", fsnotify.Chmod),
+		event(".plan.md.swp", fsnotify.Write),
+		event("plan.md~", fsnotify.Create),
+		event("notes/plan.md", fsnotify.Write),
+		event("public/index.html", fsnotify.Write),
+	} {
+		if pw.note(&changes, e) {
+			t.Errorf("note(%v) = true, want it ignored", e)
+		}
+	}
+
+	// An editor that saves by renaming a temporary file over the plan.
+	if !pw.note(&changes, event("plan.md", fsnotify.Create)) || !changes.Plan {
+		t.Error("plan.md replaced by a rename is not a change to the plan")
+	}
+	for _, name := range []string{"settings.json", "settings.staging.json", "template.html"} {
+		changes = planChanges{}
+		if !pw.note(&changes, event(name, fsnotify.Write)) || !changes.Config {
+			t.Errorf("%s is not a change to the settings", name)
+		}
+	}
+	changes = planChanges{}
+	if pw.note(&changes, event("settings.production.json", fsnotify.Write)) {
+		t.Error("settings of another environment are a change")
+	}
+
+	// New asset directories are watched, and their files are assets.
+	if err := os.MkdirAll(filepath.Join(dir, "assets", "img", "2025"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	changes = planChanges{}
+	pw.note(&changes, event("assets", fsnotify.Create))
+	pw.note(&changes, event("assets/img/2025/desk.jpg", fsnotify.Create))
+	pw.note(&changes, event("assets/img/2025/desk.jpg", fsnotify.Write))
+	if !pw.ctx.HasAssets {
+		t.Error("HasAssets is false after assets/ was created")
+	}
+	watched := pw.w.WatchList()
+	for _, d := range []string{"assets", "assets/img", "assets/img/2025"} {
+		if !slices.Contains(watched, filepath.Join(dir, filepath.FromSlash(d))) {
+			t.Errorf("%s is not watched: %v", d, watched)
+		}
+	}
+	want := []string{filepath.Join(dir, "assets"), filepath.Join(dir, "assets", "img", "2025", "desk.jpg")}
+	if !slices.Equal(changes.Assets, want) {
+		t.Errorf("Assets = %v, want %v", changes.Assets, want)
+	}
+}
+
+func TestPlanWatcher_Run(t *testing.T) {
+	pw := newTestWatcher(t)
+	dir := pw.ctx.PlanDir
+	batches := make(chan planChanges, 10)
+	go pw.Run(func(c planChanges) { batches <- c })
+	// wait returns the batches until one satisfies done.
+	wait := func(what string, done func(planChanges) bool) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case c := <-batches:
+				if done(c) {
+					return
+				}
+			case <-deadline:
+				t.Fatalf("no batch with %s", what)
+			}
+		}
+	}
+
+	// A save by rename is one change to the plan.
+	tmp := filepath.Join(dir, ".plan.md.tmp")
+	if err := os.WriteFile(tmp, []byte("# Today\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "plan.md")); err != nil {
+		t.Fatal(err)
+	}
+	wait("the plan", func(c planChanges) bool { return c.Plan })
+
+	// A file in an asset directory made after the watcher started.
+	if err := os.MkdirAll(filepath.Join(dir, "assets", "img"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wait("assets/", func(c planChanges) bool { return len(c.Assets) > 0 })
+	photo := filepath.Join(dir, "assets", "img", "desk.jpg")
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Contains(pw.w.WatchList(), filepath.Dir(photo)) {
+		if time.Now().After(deadline) {
+			t.Fatal("assets/img is not watched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := os.WriteFile(photo, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wait("the photo", func(c planChanges) bool { return slices.Contains(c.Assets, photo) })
+
+	if err := os.WriteFile(filepath.Join(dir, "settings.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wait("the settings", func(c planChanges) bool { return c.Config })
+}
+
+// commitPlan commits content as the plan in the repository at dir, at date.
+func commitPlan(t *testing.T, dir, content, date string) {
+	t.Helper()
+	writeFiles(t, dir, map[string]string{"plan.md": content})
+	env := []string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date}
+	git(t, dir, env, "-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "--quiet", "-am", "Update plan")
+}
+
+func TestPreviewSite(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	writePlan(t, dir, `{"base_url": "https://plan.example"}`, "# Friday\n\nFirst day.\n", "2024-03-01T10:00:00Z")
+	commitPlan(t, dir, "# Saturday\n\nSecond day.\n", "2024-03-02T10:00:00Z")
+	ctx := hostContext(t, dir)
+	served := 0
+	site := newPreviewSite(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served++ }))
+	get := func(p string) {
+		t.Helper()
+		site.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+	}
+	out := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(ctx.OutputDir, filepath.FromSlash(name)))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	// Only the day asked for is rendered, and only once.
+	get("/2024/03/01/")
+	if !strings.Contains(out("2024/03/01/index.html"), "First day.") {
+		t.Error("day page not rendered on request")
+	}
+	if out("2024/03/02/index.html") != "" || out("rss.xml") != "" {
+		t.Error("pages rendered before they were asked for")
+	}
+	os.Remove(filepath.Join(ctx.OutputDir, "2024", "03", "01", "index.html"))
+	get("/2024/03/01/index.html")
+	if out("2024/03/01/index.html") != "" {
+		t.Error("day page rendered again without a new commit")
+	}
+	get("/about.html")
+	if served != 3 {
+		t.Errorf("served %d requests, want 3", served)
+	}
+
+	// A new commit makes pages render again.
+	commitPlan(t, dir, "# Saturday\n\nSecond day, edited.\n", "2024-03-02T12:00:00Z")
+	get("/2024/03/01/")
+	if !strings.Contains(out("2024/03/01/index.html"), "First day.") {
+		t.Error("day page not rendered again after HEAD moved")
+	}
+
+	// The feed renders every day.
+	get("/rss.xml")
+	if feed := out("rss.xml"); !strings.Contains(feed, "2024-03-01") || !strings.Contains(feed, "2024-03-02") {
+		t.Errorf("feed does not list every day:\n%s", feed)
+	}
+	if !strings.Contains(out("2024/03/02/index.html"), "Second day, edited.") {
+		t.Error("rendering the feed did not render every day")
+	}
+	get("/archives/")
+	if out("archives/index.html") == "" {
+		t.Error("archives not rendered")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/activitypub"
+	"github.com/dewitt/a-simple-plan/internal/webmention"
+	"github.com/dewitt/a-simple-plan/pkg/plan"
+)
+
+type serveOptions struct {
+	Addr string
+	Poll time.Duration
+}
+
+// newSiteMux returns a mux serving the built site from ctx.OutputDir. Preview
+// and serve register their own endpoints on top of it.
+func newSiteMux(ctx *PlanContext) *http.ServeMux {
+	mux := http.NewServeMux()
+	var files http.Handler = http.FileServer(http.Dir(ctx.OutputDir))
+	if ctx.LiveReload {
+		files = withThemeParam(ctx, files)
+	}
+	mux.Handle("/", files)
+	return mux
+}
+
+// serve builds the site and serves it as a long-running server. It accepts
+// Webmentions at /webmention, storing them in the plan repo and rebuilding so
+// they appear under the relevant day, and optionally acts as an ActivityPub
+// actor. The repo is polled for new commits, which trigger a rebuild, and
+// the site is rebuilt when an embargoed version is due.
+func serve(ctx *PlanContext, opts serveOptions) {
+	var (
+		buildMu sync.Mutex
+		itemsMu sync.RWMutex
+		items   []plan.Item
+		actor   *activitypub.Server
+		due     *time.Timer // rebuilds when an embargoed version is due
+		rebuild func()
+		// deliveries holds the notes of the newest build until the actor
+		// delivers them, so slow followers never hold up a build.
+		deliveries = make(chan []activitypub.Note, 1)
+	)
+
+	rebuild = func() {
+		buildMu.Lock()
+		defer buildMu.Unlock()
+		res, _, err := buildSite(ctx, false)
+		if err != nil {
+			// Keep serving the last good build.
+			log.Printf("Warning: Build failed: %v", err)
+			return
+		}
+		itemsMu.Lock()
+		items = res.Items
+		itemsMu.Unlock()
+
+		if due != nil {
+			due.Stop()
+		}
+		if next := res.NextPublish; !next.IsZero() {
+			fmt.Printf("An embargoed version is due at %s; rebuilding then.\n", next.In(ctx.Location()).Format(time.RFC3339))
+			due = time.AfterFunc(time.Until(next), func() {
+				fmt.Println("Embargo over, rebuilding...")
+				rebuild()
+			})
+		}
+
+		if actor != nil {
+			// Notes not delivered yet are superseded by these.
+			select {
+			case <-deliveries:
+			default:
+			}
+			deliveries <- notesFromItems(res.Items)
+		}
+	}
+
+	mux := newSiteMux(ctx)
+	mux.Handle("/webmention", &webmention.Receiver{
+		BaseURL: ctx.Config.BaseURL + ctx.BasePath,
+		Store:   mentionStore(ctx),
+		Client:  webmention.NewClient(),
+		OnChange: func(m webmention.Mention) {
+			fmt.Printf("Webmention from %s for %s\n", m.Source, m.Target)
+			rebuild()
+		},
+	})
+
+	var handler http.Handler = mux
+	if ctx.Config.ActivityPub {
+		var err error
+		actor, err = newActor(ctx, func() []activitypub.Note {
+			itemsMu.RLock()
+			defer itemsMu.RUnlock()
+			return notesFromItems(items)
+		})
+		if err != nil {
+			log.Fatalf("Failed to set up ActivityPub: %v", err)
+		}
+		actor.Register(mux)
+		handler = actor.Negotiate(handler)
+		fmt.Printf("ActivityPub actor: %s\n", actor.ActorID())
+		go func() {
+			for notes := range deliveries {
+				pubCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+				n, err := actor.Publish(pubCtx, notes)
+				cancel()
+				if err != nil {
+					log.Printf("Warning: ActivityPub delivery failed: %v", err)
+				}
+				if n > 0 {
+					fmt.Printf("Delivered %d activities to followers.\n", n)
+				}
+			}
+		}()
+	}
+	if ctx.Config.Webmention != "" {
+		handler = withLinkHeader(handler, ctx.Config.Webmention)
+	}
+
+	rebuild()
+	if opts.Poll > 0 {
+		go pollHead(ctx, opts.Poll, rebuild)
+	}
+
+	fmt.Printf("Serving %s on %s\n", ctx.OutputDir, opts.Addr)
+	if err := http.ListenAndServe(opts.Addr, handler); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newActor sets up the ActivityPub actor for the plan. Its key and follower
+// state are kept in the plan's .plan directory.
+func newActor(ctx *PlanContext, notes func() []activitypub.Note) (*activitypub.Server, error) {
+	stateDir := filepath.Join(ctx.PlanDir, ".plan")
+	key, err := activitypub.LoadOrCreateKey(filepath.Join(stateDir, "activitypub-key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("loading key: %w", err)
+	}
+	state, err := activitypub.LoadState(filepath.Join(stateDir, "activitypub.json"))
+	if err != nil {
+		return nil, fmt.Errorf("loading state: %w", err)
+	}
+	return &activitypub.Server{
+		BaseURL:  strings.TrimSuffix(ctx.Config.BaseURL+ctx.BasePath, "/"),
+		Username: ctx.Config.Username,
+		Name:     ctx.Config.FullName,
+		Summary:  ctx.Config.Title,
+		Key:      key,
+		State:    state,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		Notes:    notes,
+	}, nil
+}
+
+// notesFromItems converts feed items, newest first, to ActivityPub notes.
+func notesFromItems(items []plan.Item) []activitypub.Note {
+	notes := make([]activitypub.Note, 0, len(items))
+	for _, item := range items {
+		published, _ := time.Parse(time.RFC1123Z, item.PubDate)
+		notes = append(notes, activitypub.Note{
+			ID:        item.Link,
+			Content:   fmt.Sprintf("<p><a href=\"%s\">%s</a></p>\n%s", html.EscapeString(item.Link), html.EscapeString(item.Title), item.Content),
+			Published: published,
+		})
+	}
+	return notes
+}
+
+// pollHead calls rebuild whenever HEAD of the plan repo moves.
+func pollHead(ctx *PlanContext, interval time.Duration, rebuild func()) {
+	head := gitHead(ctx.PlanDir)
+	for range time.Tick(interval) {
+		if h := gitHead(ctx.PlanDir); h != head {
+			head = h
+			fmt.Printf("New commit %s, rebuilding...\n", shortHash(h))
+			rebuild()
+		}
+	}
+}
+
+func gitHead(dir string) string {
+	return gitRev(dir, "HEAD")
+}
+
+// gitRev returns the commit rev names in the repository at dir, or "" if
+// there is none.
+func gitRev(dir, rev string) string {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", rev)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// withLinkHeader advertises the Webmention endpoint on every response.
+func withLinkHeader(next http.Handler, endpoint string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="webmention"`, endpoint))
+		next.ServeHTTP(w, r)
+	})
+}
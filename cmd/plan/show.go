@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+var (
+	dateSpecRe   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	offsetSpecRe = regexp.MustCompile(`^-(\d+)([dw])$`)
+)
+
+// show prints a version of the plan to stdout, rendered for the terminal.
+// The spec may be anything accepted by resolveVersion.
+func show(ctx *PlanContext, spec string) {
+	info, err := resolveVersion(ctx, spec)
+	if err != nil {
+		log.Fatalf("Failed to resolve version %q: %v", spec, err)
+	}
+
+	content, err := getGitContent(ctx.PlanDir, info.Hash, ctx.PlanFile)
+	if err != nil {
+		log.Fatalf("Failed to read %s at %s: %v", ctx.PlanFile, shortHash(info.Hash), err)
+	}
+
+	color := useColor(os.Stdout)
+	r := render.NewTerminal(terminalWidth(), color)
+	out, err := r.Render(content)
+	if err != nil {
+		log.Fatalf("Failed to render %s: %v", ctx.PlanFile, err)
+	}
+
+	header := fmt.Sprintf("%s @ %s (%s)", ctx.PlanFile, info.Time.Format("2006-01-02 15:04"), shortHash(info.Hash))
+	if color {
+		header = "\x1b[2m" + header + "\x1b[22m"
+	}
+	fmt.Println(header)
+	fmt.Println()
+	os.Stdout.Write(out)
+}
+
+// resolveVersion maps a version spec to a commit of the plan file. An empty
+// spec or "latest" is the most recent commit. A date (YYYY-MM-DD), "today",
+// "yesterday" or an offset such as -7d or -2w is the version that was current
+// at the end of that day. Anything else is handed to git as a revision.
+func resolveVersion(ctx *PlanContext, spec string) (CommitInfo, error) {
+	if spec == "" || spec == "latest" {
+		return getCommitInfo(ctx.PlanDir, "HEAD", ctx.PlanFile)
+	}
+
+	date, ok := parseDateSpec(spec, time.Now().In(ctx.Location()))
+	if !ok {
+		return getCommitInfo(ctx.PlanDir, spec, "")
+	}
+
+	history, err := getGitHistory(ctx.PlanDir, ctx.PlanFile)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	best := ""
+	for d := range history {
+		if d <= date && d > best {
+			best = d
+		}
+	}
+	if best == "" {
+		return CommitInfo{}, fmt.Errorf("no version of %s on or before %s", ctx.PlanFile, date)
+	}
+	return history[best], nil
+}
+
+// parseDateSpec converts a date-like spec to a YYYY-MM-DD string relative to now.
+func parseDateSpec(spec string, now time.Time) (string, bool) {
+	switch {
+	case dateSpecRe.MatchString(spec):
+		return spec, true
+	case spec == "today":
+		return now.Format("2006-01-02"), true
+	case spec == "yesterday":
+		return now.AddDate(0, 0, -1).Format("2006-01-02"), true
+	}
+	if m := offsetSpecRe.FindStringSubmatch(spec); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if m[2] == "w" {
+			n *= 7
+		}
+		return now.AddDate(0, 0, -n).Format("2006-01-02"), true
+	}
+	return "", false
+}
+
+// getCommitInfo returns the most recent commit reachable from rev, limited to
+// commits touching file when file is non-empty.
+func getCommitInfo(dir, rev, file string) (CommitInfo, error) {
+	args := []string{"log", "-1", "--date=iso-strict", "--format=%H %ad", "--end-of-options", rev}
+	if file != "" {
+		args = append(args, "--", file)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("unknown revision %q", rev)
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(out)), " ", 2)
+	if len(parts) != 2 {
+		return CommitInfo{}, fmt.Errorf("no commits found for %q", rev)
+	}
+	t, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("parsing commit date %q: %w", parts[1], err)
+	}
+	return CommitInfo{Hash: parts[0], Time: t}, nil
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// useColor reports whether ANSI styling should be written to f.
+func useColor(f *os.File) bool {
+	return isTerminal(f) && os.Getenv("NO_COLOR") == ""
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the width to wrap terminal output at, taken from
+// $COLUMNS when set.
+func terminalWidth() int {
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return min(cols, 100)
+	}
+	return 80
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Builds are made in a staging copy of the output, next to it, and swapped
+// into place when they succeed, so a failed build never leaves a mix of old
+// and new pages to be served. The output they replace is kept, to be
+// restored with `plan build --restore-previous`, unless it was replaced by a
+// build --at.
+func stagingDir(out string) string  { return out + ".staging" }
+func previousDir(out string) string { return out + ".previous" }
+
+// staged runs f on a copy of ctx whose output is a staging copy of the
+// current output, and swaps the staging copy into place if f succeeds. The
+// staging copy is made of hard links, which builds replace rather than write
+// through, so it is cheap and leaves the current output untouched.
+func staged(ctx *PlanContext, f func(*PlanContext) error) error {
+	out := ctx.OutputDir
+	stage := stagingDir(out)
+	// A staging directory left behind was from a build that never finished.
+	if err := os.RemoveAll(stage); err != nil {
+		return err
+	}
+	if err := linkTree(out, stage); err != nil {
+		os.RemoveAll(stage)
+		return fmt.Errorf("staging the output: %w", err)
+	}
+
+	stageCtx := *ctx
+	stageCtx.OutputDir = stage
+	if err := f(&stageCtx); err != nil {
+		os.RemoveAll(stage)
+		return err
+	}
+
+	// The output is exchanged with the staging copy in one step, so it is
+	// never missing while it is served. The old output, now in the staging
+	// directory, becomes the previous one.
+	if _, err := os.Stat(out); os.IsNotExist(err) {
+		return os.Rename(stage, out)
+	}
+	if err := exchange(stage, out); err != nil {
+		return err
+	}
+	// A build as of another time only shows what the site will be, and is
+	// not one to roll back to.
+	if !ctx.At.IsZero() {
+		return os.RemoveAll(stage)
+	}
+	prev := previousDir(out)
+	if err := os.RemoveAll(prev); err != nil {
+		return err
+	}
+	return os.Rename(stage, prev)
+}
+
+// restorePrevious swaps the output with the one the last build replaced, so
+// running it again undoes it.
+func restorePrevious(ctx *PlanContext) error {
+	out, prev := ctx.OutputDir, previousDir(ctx.OutputDir)
+	if _, err := os.Stat(prev); err != nil {
+		return fmt.Errorf("there is no previous build to restore")
+	}
+	if _, err := os.Stat(out); os.IsNotExist(err) {
+		return os.Rename(prev, out)
+	}
+	return exchange(prev, out)
+}
+
+// swapByRenames swaps the directories a and b with three renames, for
+// systems that cannot exchange them atomically. b is briefly missing.
+func swapByRenames(a, b string) error {
+	tmp := a + ".swap"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(a, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(b, a); err != nil {
+		os.Rename(tmp, a)
+		return err
+	}
+	return os.Rename(tmp, b)
+}
+
+// linkTree makes dst a copy of the directory src, with hard links to its
+// files where possible. A missing src leaves dst empty.
+func linkTree(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == src {
+			return fs.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case !d.Type().IsRegular():
+			return nil
+		}
+		if os.Link(p, target) == nil {
+			return nil
+		}
+		return copyFile(p, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// exchange swaps the directories a and b, which must both exist. Only Linux
+// can do it atomically; elsewhere it takes renames.
+func exchange(a, b string) error {
+	return swapByRenames(a, b)
+}
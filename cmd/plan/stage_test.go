@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// writeIndex replaces index.html the way the builder does, by writing a new
+// file over the hard link rather than through it.
+func writeIndex(content string) func(*PlanContext) error {
+	return func(ctx *PlanContext) error {
+		p := filepath.Join(ctx.OutputDir, "index.html")
+		if err := os.WriteFile(p+".tmp", []byte(content), 0644); err != nil {
+			return err
+		}
+		return os.Rename(p+".tmp", p)
+	}
+}
+
+func TestLinkTree(t *testing.T) {
+	src, dst := filepath.Join(t.TempDir(), "public"), filepath.Join(t.TempDir(), "staging")
+	writeFiles(t, src, map[string]string{"index.html": "one", "2025/01/01/index.html": "day"})
+	if err := linkTree(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"index.html", "2025/01/01/index.html"} {
+		a, err := os.Stat(filepath.Join(src, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.Stat(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatalf("%s not copied: %v", name, err)
+		}
+		if !os.SameFile(a, b) {
+			t.Errorf("%s is not a hard link", name)
+		}
+	}
+
+	// A missing source gives an empty copy.
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := linkTree(filepath.Join(t.TempDir(), "missing"), empty); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(empty); err != nil || len(entries) != 0 {
+		t.Errorf("copy of a missing directory = %v, %v", entries, err)
+	}
+}
+
+func TestStaged(t *testing.T) {
+	ctx := &PlanContext{OutputDir: filepath.Join(t.TempDir(), "public")}
+	out, prev := ctx.OutputDir, previousDir(ctx.OutputDir)
+
+	// The first build has no output to replace.
+	if err := staged(ctx, writeIndex("one")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(out, "index.html")); got != "one" {
+		t.Errorf("index.html = %q, want one", got)
+	}
+	if _, err := os.Stat(prev); !os.IsNotExist(err) {
+		t.Errorf("previous output after the first build: %v", err)
+	}
+
+	// The next one keeps it as the previous output.
+	if err := staged(ctx, writeIndex("two")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(out, "index.html")); got != "two" {
+		t.Errorf("index.html = %q, want two", got)
+	}
+	if got := readFile(t, filepath.Join(prev, "index.html")); got != "one" {
+		t.Errorf("previous index.html = %q, want one", got)
+	}
+
+	// A failed build leaves everything as it was.
+	failed := errors.New("failed")
+	err := staged(ctx, func(ctx *PlanContext) error {
+		writeIndex("broken")(ctx)
+		return failed
+	})
+	if err != failed {
+		t.Errorf("staged = %v, want the build's error", err)
+	}
+	if got := readFile(t, filepath.Join(out, "index.html")); got != "two" {
+		t.Errorf("index.html after a failed build = %q, want two", got)
+	}
+	if got := readFile(t, filepath.Join(prev, "index.html")); got != "one" {
+		t.Errorf("previous index.html after a failed build = %q, want one", got)
+	}
+	for _, dir := range []string{stagingDir(out), stagingDir(out) + ".swap"} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", dir, err)
+		}
+	}
+
+	// A build as of another time does not replace the previous output.
+	atCtx := *ctx
+	atCtx.At = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := staged(&atCtx, writeIndex("three")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(out, "index.html")); got != "three" {
+		t.Errorf("index.html = %q, want three", got)
+	}
+	if got := readFile(t, filepath.Join(prev, "index.html")); got != "one" {
+		t.Errorf("previous index.html after a build --at = %q, want one", got)
+	}
+	if _, err := os.Stat(stagingDir(out)); !os.IsNotExist(err) {
+		t.Errorf("staging directory left behind: %v", err)
+	}
+}
+
+func TestRestorePrevious(t *testing.T) {
+	ctx := &PlanContext{OutputDir: filepath.Join(t.TempDir(), "public")}
+	out, prev := ctx.OutputDir, previousDir(ctx.OutputDir)
+	if err := restorePrevious(ctx); err == nil {
+		t.Error("restorePrevious succeeded without a previous build")
+	}
+
+	writeFiles(t, out, map[string]string{"index.html": "two"})
+	writeFiles(t, prev, map[string]string{"index.html": "one"})
+	if err := restorePrevious(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(out, "index.html")); got != "one" {
+		t.Errorf("index.html = %q, want one", got)
+	}
+	if got := readFile(t, filepath.Join(prev, "index.html")); got != "two" {
+		t.Errorf("previous index.html = %q, want two", got)
+	}
+
+	// Restoring again undoes it.
+	if err := restorePrevious(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(out, "index.html")); got != "two" {
+		t.Errorf("index.html = %q, want two", got)
+	}
+
+	// Without an output, the previous one is moved into place.
+	os.RemoveAll(out)
+	if err := restorePrevious(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(out, "index.html")); got != "one" {
+		t.Errorf("index.html = %q, want one", got)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeFiles(t, a, map[string]string{"name": "a"})
+	writeFiles(t, b, map[string]string{"name": "b"})
+	if err := exchange(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, filepath.Join(a, "name")) != "b" || readFile(t, filepath.Join(b, "name")) != "a" {
+		t.Error("exchange did not swap the directories")
+	}
+	if err := swapByRenames(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, filepath.Join(a, "name")) != "a" || readFile(t, filepath.Join(b, "name")) != "b" {
+		t.Error("swapByRenames did not swap the directories")
+	}
+}
+
+func TestBuildSite_HistoryError(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	writePlan(t, dir, `{"base_url": "https://plan.example"}`, "# Monday\n\nAll good.\n", "2024-03-01T10:00:00Z")
+	ctx := hostContext(t, dir)
+	if _, _, err := buildSite(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	before := readFile(t, filepath.Join(ctx.OutputDir, "rss.xml"))
+
+	// A version that cannot be rendered fails the build, rather than
+	// publishing a site without it and every day after it.
+	writeFiles(t, dir, map[string]string{"plan.md": "# Tuesday\n\n<!-- private -->\nNever closed.\n"})
+	env := []string{"GIT_AUTHOR_DATE=2024-03-02T10:00:00Z", "GIT_COMMITTER_DATE=2024-03-02T10:00:00Z"}
+	git(t, dir, env, "-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "--quiet", "-am", "Update plan")
+	writeFiles(t, dir, map[string]string{"plan.md": "# Tuesday\n\nFixed, but not committed.\n"})
+	if _, _, err := buildSite(ctx, false); err == nil {
+		t.Fatal("buildSite succeeded with a version that cannot be rendered")
+	}
+	if got := readFile(t, filepath.Join(ctx.OutputDir, "rss.xml")); got != before {
+		t.Errorf("rss.xml changed by a failed build:\n%s", got)
+	}
+	if _, err := os.Stat(filepath.Join(ctx.OutputDir, "2024", "03", "01", "index.html")); err != nil {
+		t.Errorf("day page removed by a failed build: %v", err)
+	}
+	if index := readFile(t, filepath.Join(ctx.OutputDir, "index.html")); !strings.Contains(index, "All good.") {
+		t.Errorf("index.html changed by a failed build:\n%s", index)
+	}
+}
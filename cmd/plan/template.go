@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+// templateCmd runs `plan template check [file]`, which checks the plan's
+// template.html, or the given file, for problems.
+func templateCmd(ctx *PlanContext, args []string) {
+	if len(args) == 0 || args[0] != "check" || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: plan template check [file]")
+		os.Exit(1)
+	}
+
+	path := filepath.Join(ctx.PlanDir, "template.html")
+	if len(args) == 2 {
+		path = args[1]
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && len(args) == 1 {
+		fmt.Println("No template.html; the built-in template is used.")
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to read template: %v", err)
+	}
+
+	problems := render.CheckTemplate(string(data))
+	if reportTemplateProblems(os.Stdout, filepath.Base(path), problems) > 0 {
+		os.Exit(1)
+	}
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK\n", filepath.Base(path))
+	}
+}
+
+// reportTemplateProblems writes problems to w, one per line and prefixed with
+// the template's name, and returns how many of them are errors.
+func reportTemplateProblems(w io.Writer, name string, problems []render.TemplateProblem) int {
+	errors := 0
+	for _, p := range problems {
+		if p.Line > 0 {
+			fmt.Fprintf(w, "%s:%d: %s: %s\n", name, p.Line, p.Severity, p.Message)
+		} else {
+			fmt.Fprintf(w, "%s: %s: %s\n", name, p.Severity, p.Message)
+		}
+		if p.Severity == render.SeverityError {
+			errors++
+		}
+	}
+	return errors
+}
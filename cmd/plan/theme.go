@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+type themeOptions struct {
+	Force bool
+}
+
+// themeCmd runs `plan theme list` and `plan theme eject <name>`.
+func themeCmd(ctx *PlanContext, args []string, opts themeOptions) {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		current := ctx.Config.Theme
+		if current == "" {
+			current = render.DefaultTheme
+		}
+		for _, t := range render.Themes() {
+			marker := " "
+			if t.Name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %-8s %s\n", marker, t.Name, t.Description)
+		}
+		if _, err := os.Stat(filepath.Join(ctx.PlanDir, "template.html")); err == nil {
+			fmt.Println("\nNote: template.html is used instead of the theme.")
+		}
+	case len(args) == 2 && args[0] == "eject":
+		tmpl, err := render.ThemeTemplate(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		dst := filepath.Join(ctx.PlanDir, "template.html")
+		if _, err := os.Stat(dst); err == nil && !opts.Force {
+			log.Fatalf("template.html already exists (use --force to overwrite it)")
+		}
+		if err := os.WriteFile(dst, []byte(tmpl), 0644); err != nil {
+			log.Fatalf("Failed to write template.html: %v", err)
+		}
+		fmt.Printf("Wrote the %s theme to template.html, which now takes precedence over the theme setting.\n", args[1])
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: plan theme list")
+		fmt.Fprintln(os.Stderr, "       plan theme eject <name>")
+		os.Exit(1)
+	}
+}
+
+// withThemeParam lets preview pages be viewed in another theme with a
+// ?theme=name query parameter, so themes can be compared side by side.
+func withThemeParam(ctx *PlanContext, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("theme")
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		css, err := render.ThemeCSS(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		p := path.Clean("/" + r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") {
+			p = path.Join(p, "index.html")
+		}
+		if !strings.HasSuffix(p, ".html") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		page, err := os.ReadFile(filepath.Join(ctx.OutputDir, filepath.FromSlash(p)))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprint(w, render.ApplyTheme(string(page), css))
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/render"
+	"github.com/dewitt/a-simple-plan/internal/webmention"
+	"github.com/dewitt/a-simple-plan/pkg/plan"
+)
+
+// mentionStore returns the store of received mentions, kept in the plan repo
+// so they are versioned alongside the plan.
+func mentionStore(ctx *PlanContext) *webmention.Store {
+	return &webmention.Store{Path: filepath.Join(ctx.PlanDir, "webmentions.json")}
+}
+
+// sendWebmentions sends mentions for the outbound links of days that have not
+// been processed yet. The first run only processes the most recent day, so
+// enabling mentions on an old plan does not notify its whole back catalogue.
+func sendWebmentions(ctx *PlanContext) {
+	statePath := filepath.Join(ctx.PlanDir, ".plan", "webmention-sent.json")
+	state, err := webmention.LoadSentState(statePath)
+	if err != nil {
+		log.Printf("Warning: Failed to load webmention state: %v", err)
+		return
+	}
+
+	// Only published days are mentioned; private and retracted ones have
+	// no page to be the source.
+	b, err := ctx.builder()
+	if err != nil {
+		log.Printf("Warning: Failed to read history for webmentions: %v", err)
+		return
+	}
+	published, err := b.Days()
+	if err != nil {
+		log.Printf("Warning: Failed to read history for webmentions: %v", err)
+		return
+	}
+	days := slices.Clone(published)
+	slices.Reverse(days)
+
+	fmt.Println("Sending webmentions...")
+	first := state.IsNew()
+	client := webmention.NewClient()
+	sent := 0
+	for i, info := range days {
+		source := ctx.Config.BaseURL + dayPath(ctx, info.Time)
+		if state.Sources[source] == info.Hash {
+			continue
+		}
+		if first && i < len(days)-1 {
+			state.Sources[source] = info.Hash
+			continue
+		}
+
+		content, err := getGitContent(ctx.PlanDir, info.Hash, ctx.PlanFile)
+		if err != nil {
+			log.Printf("Warning: Failed to get content for %s: %v", info.Date, err)
+			continue
+		}
+		// Links in private blocks are not published, so not mentioned.
+		if content, err = render.StripPrivate(content); err != nil {
+			log.Printf("Warning: Skipping webmentions for %s: %v", info.Date, err)
+			continue
+		}
+
+		failed := false
+		for _, target := range render.Links(content) {
+			if !isOutbound(ctx, target) || state.WasSent(source, target) {
+				continue
+			}
+			reqCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := client.Mention(reqCtx, source, target)
+			cancel()
+			switch {
+			case errors.Is(err, webmention.ErrNoEndpoint):
+				continue
+			case err != nil:
+				// Leave the day unprocessed so the mention is retried next build.
+				log.Printf("Warning: Failed to send webmention to %s: %v", target, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Sent webmention: %s -> %s\n", source, target)
+			state.MarkSent(source, target, time.Now().UTC())
+			sent++
+		}
+		if !failed {
+			state.Sources[source] = info.Hash
+		}
+	}
+
+	if err := state.Save(); err != nil {
+		log.Printf("Warning: Failed to save webmention state: %v", err)
+	}
+	fmt.Printf("Sent %d webmentions.\n", sent)
+}
+
+// dayPath returns the URL path of the history page for the day of t.
+func dayPath(ctx *PlanContext, t time.Time) string {
+	return ctx.BasePath + plan.DayPath(t)
+}
+
+// isOutbound reports whether link points to another site.
+func isOutbound(ctx *PlanContext, link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	base, err := url.Parse(ctx.Config.BaseURL)
+	return err != nil || !strings.EqualFold(u.Host, base.Host)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/websub"
+)
+
+type websubOptions struct {
+	Addr string
+}
+
+// websubTopics returns the URLs the site's WebSub hub is told about: the
+// feed, and the front page for subscribers that found the hub there.
+func websubTopics(ctx *PlanContext) []string {
+	base := strings.TrimSuffix(ctx.Config.BaseURL+ctx.BasePath, "/")
+	return []string{base + "/rss.xml", base + "/"}
+}
+
+// websubState records the feed the hub was last told about.
+type websubState struct {
+	FeedHash string    `json:"feed_hash"`
+	Notified time.Time `json:"notified"`
+}
+
+func websubStatePath(ctx *PlanContext) string {
+	return filepath.Join(ctx.PlanDir, ".plan", "websub-notified.json")
+}
+
+// notifyHub tells the configured WebSub hub that the site has changed, if
+// the feed in the output is not the one it was last told about.
+func notifyHub(ctx *PlanContext) {
+	if ctx.Config.WebSubHub == "" {
+		return
+	}
+	feed, err := os.ReadFile(filepath.Join(ctx.OutputDir, "rss.xml"))
+	if err != nil {
+		log.Printf("Warning: Not notifying the WebSub hub: %v", err)
+		return
+	}
+	sum := sha256.Sum256(feed)
+	hash := hex.EncodeToString(sum[:])
+	statePath := websubStatePath(ctx)
+	var state websubState
+	if data, err := os.ReadFile(statePath); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	if state.FeedHash == hash {
+		return
+	}
+	if !pingHub(ctx) {
+		return
+	}
+
+	state = websubState{FeedHash: hash, Notified: time.Now().UTC()}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(statePath), 0755); err == nil {
+			err = os.WriteFile(statePath, append(data, '\n'), 0644)
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to save WebSub state: %v", err)
+	}
+}
+
+// pingHub tells the configured WebSub hub that the feed and the front page
+// have changed, and reports whether it was told of both.
+func pingHub(ctx *PlanContext) bool {
+	client := &http.Client{Timeout: 30 * time.Second}
+	notified := true
+	for _, topic := range websubTopics(ctx) {
+		reqCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := websub.Publish(reqCtx, client, ctx.Config.WebSubHub, topic)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: Failed to notify the WebSub hub of %s: %v", topic, err)
+			notified = false
+			continue
+		}
+		fmt.Printf("Notified WebSub hub of %s\n", topic)
+	}
+	return notified
+}
+
+// websubNotify tells the hub about the built site, for CI jobs that deploy
+// it without `plan deploy`.
+func websubNotify(ctx *PlanContext) {
+	if ctx.Config.WebSubHub == "" {
+		log.Fatal("No websub_hub is set in settings.json.")
+	}
+	notifyHub(ctx)
+}
+
+// websubHub runs a WebSub hub for the site: feed readers subscribe to its
+// pages and feed here, and `plan publish`, `plan deploy` and `plan
+// websub-notify` have the hub fetch and deliver them. Subscriptions are kept in the plan's .plan directory.
+func websubHub(ctx *PlanContext, opts websubOptions) {
+	hubURL := ctx.Config.WebSubHub
+	if hubURL == "" {
+		hubURL = "http://localhost" + opts.Addr + "/"
+		if !strings.HasPrefix(opts.Addr, ":") {
+			hubURL = "http://" + opts.Addr + "/"
+		}
+		log.Printf("Warning: websub_hub is not set, so the site does not advertise this hub")
+	}
+	hub := &websub.Hub{
+		BaseURL: ctx.Config.BaseURL + ctx.BasePath,
+		URL:     hubURL,
+		Store:   &websub.Store{Path: filepath.Join(ctx.PlanDir, ".plan", "websub.json")},
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	fmt.Printf("WebSub hub %s for %s on %s\n", hubURL, hub.BaseURL, opts.Addr)
+	if err := http.ListenAndServe(opts.Addr, hub); err != nil {
+		log.Fatal(err)
+	}
+}
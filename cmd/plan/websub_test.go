@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+)
+
+func TestNotifyHub(t *testing.T) {
+	var mu sync.Mutex
+	var topics []string
+	fail := false
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		topics = append(topics, r.FormValue("hub.url"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hub.Close()
+
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = "https://plan.example"
+	cfg.WebSubHub = hub.URL
+	ctx := &PlanContext{PlanDir: dir, OutputDir: filepath.Join(dir, "public"), Config: cfg}
+	notified := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := len(topics)
+		topics = nil
+		return n
+	}
+
+	writeFiles(t, ctx.OutputDir, map[string]string{"rss.xml": "<rss>one</rss>"})
+	notifyHub(ctx)
+	if n := notified(); n != 2 {
+		t.Fatalf("hub told of %d topics, want the feed and the front page", n)
+	}
+
+	// A deploy that leaves the feed alone is not news.
+	notifyHub(ctx)
+	if n := notified(); n != 0 {
+		t.Errorf("hub told of %d topics for an unchanged feed", n)
+	}
+
+	// A hub that is down is tried again on the next deploy.
+	writeFiles(t, ctx.OutputDir, map[string]string{"rss.xml": "<rss>two</rss>"})
+	setFail := func(v bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		fail = v
+	}
+	setFail(true)
+	notifyHub(ctx)
+	setFail(false)
+	notifyHub(ctx)
+	if n := notified(); n != 2 {
+		t.Errorf("hub told of %d topics after failing, want 2", n)
+	}
+	if _, err := os.Stat(websubStatePath(ctx)); err != nil {
+		t.Errorf("state not saved: %v", err)
+	}
+}
@@ -7,9 +7,7 @@ require (
 	github.com/fsnotify/fsnotify v1.9.0
 	github.com/yuin/goldmark v1.7.13
 	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
+	golang.org/x/sys v0.13.0
 )
 
-require (
-	github.com/dlclark/regexp2 v1.7.0 // indirect
-	golang.org/x/sys v0.13.0 // indirect
-)
+require github.com/dlclark/regexp2 v1.7.0 // indirect
@@ -0,0 +1,620 @@
+// Package activitypub publishes the plan as an ActivityPub actor that can be
+// followed from Mastodon and other fediverse servers.
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/publicnet"
+)
+
+const (
+	// ContentType is the media type of ActivityPub documents.
+	ContentType = "application/activity+json"
+
+	publicCollection = "https://www.w3.org/ns/activitystreams#Public"
+	maxBody          = 1 << 20
+)
+
+var activityContext = []string{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+}
+
+// Note is a published version of the plan.
+type Note struct {
+	// ID is the URL of the day's page, which doubles as the note's ID.
+	ID        string
+	Content   string // HTML
+	Published time.Time
+}
+
+// Server serves the actor, its collections and inbox, and delivers
+// activities to followers.
+type Server struct {
+	// BaseURL is the public URL of the site, including any base path.
+	BaseURL  string
+	Username string
+	Name     string
+	Summary  string
+
+	Key    *rsa.PrivateKey
+	State  *State
+	Client *http.Client
+	// AllowPrivate lets the server fetch from and deliver to private and
+	// loopback addresses, which are otherwise refused so that an incoming
+	// activity cannot make it reach into its own network.
+	AllowPrivate bool
+
+	// Notes returns the published notes, newest first.
+	Notes func() []Note
+
+	clientOnce sync.Once
+	httpClient *http.Client
+}
+
+// ActorID returns the URL of the actor document.
+func (s *Server) ActorID() string { return s.BaseURL + "/actor" }
+
+func (s *Server) keyID() string { return s.ActorID() + "#main-key" }
+
+// Register adds the WebFinger, actor, outbox, followers and inbox endpoints
+// to mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	base := ""
+	if u, err := url.Parse(s.BaseURL); err == nil {
+		base = strings.TrimSuffix(u.Path, "/")
+	}
+	mux.HandleFunc("/.well-known/webfinger", s.webfinger)
+	mux.HandleFunc(base+"/actor", s.actor)
+	mux.HandleFunc(base+"/actor/outbox", s.outbox)
+	mux.HandleFunc(base+"/actor/followers", s.followersCollection)
+	mux.HandleFunc(base+"/actor/inbox", s.inbox)
+}
+
+// Negotiate serves the JSON form of a note to clients that ask for
+// ActivityPub at a note's URL, and passes every other request to next.
+func (s *Server) Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wantsActivity(r) {
+			id := s.BaseURL + strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, s.basePath()), "/")
+			for _, n := range s.notes() {
+				if n.ID == id || n.ID == id+"/" {
+					writeJSON(w, ContentType, withContext(s.note(n)))
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) notes() []Note {
+	if s.Notes == nil {
+		return nil
+	}
+	return s.Notes()
+}
+
+func (s *Server) basePath() string {
+	if u, err := url.Parse(s.BaseURL); err == nil {
+		return strings.TrimSuffix(u.Path, "/")
+	}
+	return ""
+}
+
+func wantsActivity(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/activity+json") || strings.Contains(accept, "application/ld+json")
+}
+
+func (s *Server) webfinger(w http.ResponseWriter, r *http.Request) {
+	host := ""
+	if u, err := url.Parse(s.BaseURL); err == nil {
+		host = u.Host
+	}
+	resource := r.URL.Query().Get("resource")
+	acct := "acct:" + s.Username + "@" + host
+	if !strings.EqualFold(resource, acct) && resource != s.ActorID() {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, "application/jrd+json", map[string]any{
+		"subject": acct,
+		"aliases": []string{s.ActorID(), s.BaseURL + "/"},
+		"links": []map[string]string{
+			{"rel": "self", "type": ContentType, "href": s.ActorID()},
+			{"rel": "http://webfinger.net/rel/profile-page", "type": "text/html", "href": s.BaseURL + "/"},
+		},
+	})
+}
+
+func (s *Server) actor(w http.ResponseWriter, r *http.Request) {
+	pub, err := PublicKeyPEM(s.Key)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	id := s.ActorID()
+	writeJSON(w, ContentType, map[string]any{
+		"@context":          activityContext,
+		"id":                id,
+		"type":              "Person",
+		"preferredUsername": s.Username,
+		"name":              s.Name,
+		"summary":           s.Summary,
+		"url":               s.BaseURL + "/",
+		"inbox":             id + "/inbox",
+		"outbox":            id + "/outbox",
+		"followers":         id + "/followers",
+		"publicKey": map[string]string{
+			"id":           s.keyID(),
+			"owner":        id,
+			"publicKeyPem": pub,
+		},
+	})
+}
+
+func (s *Server) outbox(w http.ResponseWriter, r *http.Request) {
+	var items []any
+	for _, n := range s.notes() {
+		items = append(items, s.activity("Create", n))
+	}
+	writeJSON(w, ContentType, map[string]any{
+		"@context":     activityContext,
+		"id":           s.ActorID() + "/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	})
+}
+
+func (s *Server) followersCollection(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, ContentType, map[string]any{
+		"@context":   activityContext,
+		"id":         s.ActorID() + "/followers",
+		"type":       "OrderedCollection",
+		"totalItems": len(s.State.followers()),
+	})
+}
+
+// incoming is the part of a received activity the inbox cares about.
+type incoming struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+func (s *Server) inbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	owner, err := Verify(r, body, s.fetchKey)
+	if err != nil {
+		http.Error(w, "Signature verification failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var act incoming
+	if err := json.Unmarshal(body, &act); err != nil {
+		http.Error(w, "Invalid activity", http.StatusBadRequest)
+		return
+	}
+	if act.Actor != owner {
+		http.Error(w, "Actor does not match signature", http.StatusForbidden)
+		return
+	}
+
+	switch act.Type {
+	case "Follow":
+		if objectID(act.Object) != s.ActorID() {
+			http.Error(w, "Unknown object", http.StatusBadRequest)
+			return
+		}
+		remote, err := s.fetchActor(act.Actor)
+		if err != nil {
+			http.Error(w, "Fetching actor failed", http.StatusBadRequest)
+			return
+		}
+		if err := s.State.AddFollower(Follower{
+			Actor:       remote.ID,
+			Inbox:       remote.Inbox,
+			SharedInbox: remote.Endpoints.SharedInbox,
+			Since:       time.Now().UTC(),
+		}); err != nil {
+			log.Printf("activitypub: saving follower %s: %v", act.Actor, err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("activitypub: %s followed", act.Actor)
+
+		accept := map[string]any{
+			"@context": activityContext,
+			"id":       s.ActorID() + "#accept-" + shortHash(body),
+			"type":     "Accept",
+			"actor":    s.ActorID(),
+			"object":   json.RawMessage(body),
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if err := s.post(ctx, remote.Inbox, accept); err != nil {
+				log.Printf("activitypub: accepting follow from %s: %v", act.Actor, err)
+			}
+		}()
+
+	case "Undo":
+		var inner incoming
+		if err := json.Unmarshal(act.Object, &inner); err == nil && inner.Type == "Follow" {
+			if err := s.State.RemoveFollower(act.Actor); err != nil {
+				log.Printf("activitypub: removing follower %s: %v", act.Actor, err)
+			}
+			log.Printf("activitypub: %s unfollowed", act.Actor)
+		}
+
+	case "Delete":
+		// An account deletion names the actor itself as the object.
+		if objectID(act.Object) == act.Actor {
+			if err := s.State.RemoveFollower(act.Actor); err != nil {
+				log.Printf("activitypub: removing follower %s: %v", act.Actor, err)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Publish delivers Create activities for new notes and Update activities for
+// notes whose content changed since they were last delivered. The first call
+// only records the existing notes, so followers are not flooded with history.
+// Deliveries are tracked per inbox: one that fails is tried again on later
+// calls, to that inbox alone, for up to a few days, and followers whose inbox
+// is gone are removed. It returns how many notes reached every inbox.
+func (s *Server) Publish(ctx context.Context, notes []Note) (int, error) {
+	type send struct {
+		note  Note
+		inbox string
+		typ   string
+		since time.Time
+	}
+	now := time.Now().UTC()
+	s.State.mu.Lock()
+	first := len(s.State.Published) == 0
+	inboxes := s.State.inboxes()
+	pending := make(map[string]map[string]Pending)
+	for _, p := range s.State.Pending {
+		if !slices.Contains(inboxes, p.Inbox) {
+			continue
+		}
+		if pending[p.Note] == nil {
+			pending[p.Note] = make(map[string]Pending)
+		}
+		pending[p.Note][p.Inbox] = p
+	}
+	var sends []send
+	for i := len(notes) - 1; i >= 0; i-- {
+		n := notes[i]
+		hash := shortHash([]byte(n.Content))
+		prev, known := s.State.Published[n.ID]
+		switch {
+		case first:
+			s.State.Published[n.ID] = hash
+		case prev == hash:
+			// Only the inboxes it did not reach yet get it again.
+			for _, p := range pending[n.ID] {
+				sends = append(sends, send{n, p.Inbox, p.Type, p.Since})
+			}
+		default:
+			for _, inbox := range inboxes {
+				typ := "Update"
+				if !known || pending[n.ID][inbox].Type == "Create" {
+					typ = "Create"
+				}
+				sends = append(sends, send{n, inbox, typ, now})
+			}
+			s.State.Published[n.ID] = hash
+		}
+	}
+	// Notes no longer published are not tried again.
+	s.State.Pending = nil
+	err := s.State.save()
+	s.State.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	var retry []Pending
+	gone := make(map[string]bool)
+	failed := make(map[string]bool)
+	sent := make(map[string]bool)
+	for _, d := range sends {
+		sent[d.note.ID] = true
+		if gone[d.inbox] {
+			continue
+		}
+		err := s.post(ctx, d.inbox, withContext(s.activity(d.typ, d.note)))
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, errGone):
+			gone[d.inbox] = true
+			log.Printf("activitypub: %s is gone, removing its followers", d.inbox)
+		case now.Sub(d.since) > maxRetry:
+			errs = append(errs, fmt.Errorf("giving up on %s: %w", d.note.ID, err))
+		default:
+			errs = append(errs, fmt.Errorf("delivering %s: %w", d.note.ID, err))
+			retry = append(retry, Pending{Note: d.note.ID, Inbox: d.inbox, Type: d.typ, Since: d.since})
+		}
+		failed[d.note.ID] = true
+	}
+	delivered := 0
+	for id := range sent {
+		if !failed[id] {
+			delivered++
+		}
+	}
+
+	s.State.mu.Lock()
+	s.State.Pending = retry
+	if len(gone) > 0 {
+		s.State.Followers = slices.DeleteFunc(s.State.Followers, func(f Follower) bool { return gone[f.inbox()] })
+	}
+	if err := s.State.save(); err != nil {
+		errs = append(errs, err)
+	}
+	s.State.mu.Unlock()
+	return delivered, errors.Join(errs...)
+}
+
+// maxRetry is how long a failed delivery is tried again for.
+const maxRetry = 3 * 24 * time.Hour
+
+// errGone is returned by post for an inbox that no longer exists.
+var errGone = errors.New("inbox is gone")
+
+// Deliver posts a signed activity to the inbox of every follower, using
+// shared inboxes where available.
+func (s *Server) Deliver(ctx context.Context, activity any) error {
+	var errs []error
+	for _, inbox := range s.State.followerInboxes() {
+		if err := s.post(ctx, inbox, activity); err != nil {
+			errs = append(errs, fmt.Errorf("delivering to %s: %w", inbox, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) activity(typ string, n Note) map[string]any {
+	suffix := "#create"
+	if typ == "Update" {
+		suffix = "#update-" + shortHash([]byte(n.Content))
+	}
+	return map[string]any{
+		"id":        n.ID + suffix,
+		"type":      typ,
+		"actor":     s.ActorID(),
+		"published": n.Published.UTC().Format(time.RFC3339),
+		"to":        []string{publicCollection},
+		"cc":        []string{s.ActorID() + "/followers"},
+		"object":    s.note(n),
+	}
+}
+
+func (s *Server) note(n Note) map[string]any {
+	return map[string]any{
+		"id":           n.ID,
+		"type":         "Note",
+		"attributedTo": s.ActorID(),
+		"content":      n.Content,
+		"url":          n.ID,
+		"published":    n.Published.UTC().Format(time.RFC3339),
+		"to":           []string{publicCollection},
+		"cc":           []string{s.ActorID() + "/followers"},
+	}
+}
+
+func withContext(m map[string]any) map[string]any {
+	m["@context"] = activityContext
+	return m
+}
+
+func (s *Server) post(ctx context.Context, inbox string, activity any) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if err := Sign(req, s.keyID(), s.Key, body); err != nil {
+		return err
+	}
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+	if resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("POST %s: %w", inbox, errGone)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: %s", inbox, resp.Status)
+	}
+	return nil
+}
+
+// remoteActor is the part of a remote actor document the server uses.
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+	PublicKey remoteKey `json:"publicKey"`
+}
+
+type remoteKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// fetch retrieves an ActivityPub document, signing the request so that
+// servers requiring authorized fetch will answer.
+func (s *Server) fetch(id string, v any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, id, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", ContentType)
+	if err := Sign(req, s.keyID(), s.Key, nil); err != nil {
+		return err
+	}
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", id, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxBody)).Decode(v)
+}
+
+func (s *Server) fetchActor(id string) (*remoteActor, error) {
+	var a remoteActor
+	if err := s.fetch(id, &a); err != nil {
+		return nil, err
+	}
+	if a.ID != id || !isHTTP(a.Inbox) || (a.Endpoints.SharedInbox != "" && !isHTTP(a.Endpoints.SharedInbox)) {
+		return nil, fmt.Errorf("invalid actor document for %s", id)
+	}
+	return &a, nil
+}
+
+// fetchKey resolves a keyId to its public key and the actor that owns it.
+// The document at the keyId is either the owning actor, with the key
+// embedded, or the key itself. The key only vouches for its owner when the
+// owner is on the same host and its actor document names the key as its
+// own; otherwise anyone could publish a key claiming any owner.
+func (s *Server) fetchKey(keyID string) (*rsa.PublicKey, string, error) {
+	docURL, _, _ := strings.Cut(keyID, "#")
+	var raw json.RawMessage
+	if err := s.fetch(docURL, &raw); err != nil {
+		return nil, "", err
+	}
+	var actor remoteActor
+	json.Unmarshal(raw, &actor)
+	key := actor.PublicKey
+	if key.PublicKeyPem == "" {
+		json.Unmarshal(raw, &key)
+	}
+	if key.ID != keyID || key.Owner == "" {
+		return nil, "", fmt.Errorf("key %s not found", keyID)
+	}
+	if !sameHost(keyID, key.Owner) {
+		return nil, "", fmt.Errorf("key %s is not on the host of its owner %s", keyID, key.Owner)
+	}
+	if actor.ID != key.Owner {
+		actor = remoteActor{}
+		if err := s.fetch(key.Owner, &actor); err != nil {
+			return nil, "", fmt.Errorf("fetching owner of key %s: %w", keyID, err)
+		}
+	}
+	if actor.ID != key.Owner || actor.PublicKey.ID != keyID {
+		return nil, "", fmt.Errorf("%s does not claim key %s", key.Owner, keyID)
+	}
+	pub, err := ParsePublicKeyPEM(key.PublicKeyPem)
+	if err != nil {
+		return nil, "", err
+	}
+	return pub, key.Owner, nil
+}
+
+// isHTTP reports whether s is an http or https URL.
+func isHTTP(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// sameHost reports whether two URLs are on the same host.
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// client returns the client every outbound request is made with. The keys,
+// actors and inboxes it reaches are all named by others.
+func (s *Server) client() *http.Client {
+	s.clientOnce.Do(func() {
+		s.httpClient = s.Client
+		if s.httpClient == nil {
+			s.httpClient = http.DefaultClient
+		}
+		if !s.AllowPrivate {
+			s.httpClient = publicnet.Client(s.httpClient)
+		}
+	})
+	return s.httpClient
+}
+
+// objectID returns the ID of an activity's object, given either inline or
+// by reference.
+func objectID(raw json.RawMessage) string {
+	var id string
+	if json.Unmarshal(raw, &id) == nil {
+		return id
+	}
+	var obj struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(raw, &obj)
+	return obj.ID
+}
+
+func shortHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,442 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// remote is a minimal fediverse server with a single actor whose inbox
+// records the activities delivered to it.
+type remote struct {
+	srv       *httptest.Server
+	key       *rsa.PrivateKey
+	delivered chan map[string]any
+}
+
+func newRemote(t *testing.T, verifyWith func() *Server) *remote {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm := &remote{key: key, delivered: make(chan map[string]any, 10)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/bob", func(w http.ResponseWriter, r *http.Request) {
+		pub, _ := PublicKeyPEM(key)
+		writeJSON(w, ContentType, map[string]any{
+			"id":    rm.actor(),
+			"type":  "Person",
+			"inbox": rm.actor() + "/inbox",
+			"publicKey": map[string]string{
+				"id":           rm.actor() + "#main-key",
+				"owner":        rm.actor(),
+				"publicKeyPem": pub,
+			},
+		})
+	})
+	mux.HandleFunc("/users/bob/inbox", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if _, err := Verify(r, body, verifyWith().fetchKey); err != nil {
+			t.Errorf("Delivery has invalid signature: %v", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var act map[string]any
+		json.Unmarshal(body, &act)
+		rm.delivered <- act
+		w.WriteHeader(http.StatusAccepted)
+	})
+	rm.srv = httptest.NewServer(mux)
+	t.Cleanup(rm.srv.Close)
+	return rm
+}
+
+func (rm *remote) actor() string { return rm.srv.URL + "/users/bob" }
+
+func (rm *remote) send(t *testing.T, inbox string, activity map[string]any) int {
+	t.Helper()
+	body, _ := json.Marshal(activity)
+	req, _ := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	req.Header.Set("Content-Type", ContentType)
+	if err := Sign(req, rm.actor()+"#main-key", rm.key, body); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func (rm *remote) next(t *testing.T) map[string]any {
+	t.Helper()
+	select {
+	case act := <-rm.delivered:
+		return act
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for delivery")
+		return nil
+	}
+}
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	dir := t.TempDir()
+	key, err := LoadOrCreateKey(filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Username: "alice", Name: "Alice", Key: key, State: state, AllowPrivate: true}
+	mux := http.NewServeMux()
+	s.Register(mux)
+	srv := httptest.NewServer(s.Negotiate(mux))
+	t.Cleanup(srv.Close)
+	s.BaseURL = srv.URL
+	return s, srv
+}
+
+func TestWebFingerAndActor(t *testing.T) {
+	s, srv := newTestServer(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	resp, err := http.Get(srv.URL + "/.well-known/webfinger?resource=acct:alice@" + host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jrd struct {
+		Subject string
+		Links   []struct{ Rel, Type, Href string }
+	}
+	json.NewDecoder(resp.Body).Decode(&jrd)
+	resp.Body.Close()
+	if jrd.Subject != "acct:alice@"+host || len(jrd.Links) == 0 || jrd.Links[0].Href != s.ActorID() {
+		t.Errorf("Unexpected WebFinger response: %+v", jrd)
+	}
+
+	resp, err = http.Get(srv.URL + "/.well-known/webfinger?resource=acct:bob@" + host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Unknown account status = %d, want 404", resp.StatusCode)
+	}
+
+	key, owner, err := s.fetchKey(s.ActorID() + "#main-key")
+	if err != nil {
+		t.Fatalf("fetchKey failed: %v", err)
+	}
+	if owner != s.ActorID() || !key.Equal(&s.Key.PublicKey) {
+		t.Errorf("Actor document has wrong key or owner %q", owner)
+	}
+}
+
+func TestFollowAndPublish(t *testing.T) {
+	s, _ := newTestServer(t)
+	bob := newRemote(t, func() *Server { return s })
+
+	notes := []Note{{ID: s.BaseURL + "/2025/01/01", Content: "<p>first</p>", Published: time.Now()}}
+	s.Notes = func() []Note { return notes }
+
+	// Existing notes are recorded without being delivered.
+	if n, err := s.Publish(context.Background(), notes); err != nil || n != 0 {
+		t.Fatalf("Initial Publish = %d, %v; want 0, nil", n, err)
+	}
+
+	follow := map[string]any{
+		"id":     bob.actor() + "#follow-1",
+		"type":   "Follow",
+		"actor":  bob.actor(),
+		"object": s.ActorID(),
+	}
+	if code := bob.send(t, s.ActorID()+"/inbox", follow); code != http.StatusAccepted {
+		t.Fatalf("Follow status = %d, want 202", code)
+	}
+	if accept := bob.next(t); accept["type"] != "Accept" {
+		t.Errorf("Expected Accept, got %v", accept["type"])
+	}
+	if len(s.State.Followers) != 1 || s.State.Followers[0].Inbox != bob.actor()+"/inbox" {
+		t.Fatalf("Follower not recorded: %+v", s.State.Followers)
+	}
+
+	// A new day is delivered as a Create, a changed day as an Update.
+	notes = append([]Note{{ID: s.BaseURL + "/2025/01/02", Content: "<p>second</p>", Published: time.Now()}}, notes...)
+	if n, err := s.Publish(context.Background(), notes); err != nil || n != 1 {
+		t.Fatalf("Publish = %d, %v; want 1, nil", n, err)
+	}
+	create := bob.next(t)
+	if create["type"] != "Create" {
+		t.Errorf("Expected Create, got %v", create["type"])
+	}
+	if obj, _ := create["object"].(map[string]any); obj["content"] != "<p>second</p>" {
+		t.Errorf("Unexpected object: %v", create["object"])
+	}
+
+	notes[0].Content = "<p>second, edited</p>"
+	if n, err := s.Publish(context.Background(), notes); err != nil || n != 1 {
+		t.Fatalf("Publish = %d, %v; want 1, nil", n, err)
+	}
+	if update := bob.next(t); update["type"] != "Update" {
+		t.Errorf("Expected Update, got %v", update["type"])
+	}
+
+	// Unsigned requests are rejected.
+	resp, err := http.Post(s.ActorID()+"/inbox", ContentType, strings.NewReader(`{"type":"Follow"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unsigned request status = %d, want 401", resp.StatusCode)
+	}
+
+	undo := map[string]any{
+		"id":     bob.actor() + "#undo-1",
+		"type":   "Undo",
+		"actor":  bob.actor(),
+		"object": follow,
+	}
+	if code := bob.send(t, s.ActorID()+"/inbox", undo); code != http.StatusAccepted {
+		t.Fatalf("Undo status = %d, want 202", code)
+	}
+	if len(s.State.Followers) != 0 {
+		t.Errorf("Follower not removed: %+v", s.State.Followers)
+	}
+
+	// State survives a restart.
+	reloaded, err := LoadState(s.State.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Published) != 2 {
+		t.Errorf("Published state not persisted: %+v", reloaded.Published)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	s, srv := newTestServer(t)
+	s.Notes = func() []Note {
+		return []Note{{ID: s.BaseURL + "/2025/01/01", Content: "<p>hi</p>", Published: time.Now()}}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/2025/01/01/", nil)
+	req.Header.Set("Accept", ContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var note map[string]any
+	json.NewDecoder(resp.Body).Decode(&note)
+	resp.Body.Close()
+	if note["type"] != "Note" || note["content"] != "<p>hi</p>" {
+		t.Errorf("Unexpected note: %v", note)
+	}
+}
+
+// TestInbox_ForgedOwner checks that a key cannot sign for an actor that does
+// not claim it, whether it is published on another host or next to the
+// actor.
+func TestInbox_ForgedOwner(t *testing.T) {
+	s, _ := newTestServer(t)
+	bob := newRemote(t, func() *Server { return s })
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := PublicKeyPEM(key)
+	var evil *httptest.Server
+	evil = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, ContentType, map[string]string{
+			"id":           evil.URL + r.URL.Path + "#key",
+			"owner":        bob.actor(),
+			"publicKeyPem": pub,
+		})
+	}))
+	defer evil.Close()
+	// A key served from bob's host, but not bob's.
+	bob.srv.Config.Handler.(*http.ServeMux).HandleFunc("/users/mallory", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, ContentType, map[string]string{
+			"id":           bob.srv.URL + "/users/mallory#key",
+			"owner":        bob.actor(),
+			"publicKeyPem": pub,
+		})
+	})
+
+	follow := map[string]any{
+		"id":     bob.actor() + "#follow-forged",
+		"type":   "Follow",
+		"actor":  bob.actor(),
+		"object": s.ActorID(),
+	}
+	body, _ := json.Marshal(follow)
+	for _, keyID := range []string{evil.URL + "/key#key", bob.srv.URL + "/users/mallory#key"} {
+		req, _ := http.NewRequest(http.MethodPost, s.ActorID()+"/inbox", bytes.NewReader(body))
+		req.Header.Set("Content-Type", ContentType)
+		if err := Sign(req, keyID, key, body); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", keyID, resp.StatusCode)
+		}
+	}
+	if len(s.State.Followers) != 0 {
+		t.Errorf("Forged follow recorded: %+v", s.State.Followers)
+	}
+}
+
+// inboxRecorder is a follower's inbox that answers with status and records
+// the activities it accepts.
+type inboxRecorder struct {
+	mu       sync.Mutex
+	status   int
+	received []string
+}
+
+func (ir *inboxRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	if ir.status != http.StatusAccepted {
+		http.Error(w, http.StatusText(ir.status), ir.status)
+		return
+	}
+	var act map[string]any
+	json.NewDecoder(r.Body).Decode(&act)
+	ir.received = append(ir.received, act["type"].(string))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (ir *inboxRecorder) set(status int) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	ir.status = status
+}
+
+// take returns the activities received since it was last called.
+func (ir *inboxRecorder) take() string {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	got := strings.Join(ir.received, ",")
+	ir.received = nil
+	return got
+}
+
+// TestPublish_RetriesFailedDelivery checks that a delivery that failed is
+// tried again to that inbox alone, and that followers whose inbox is gone
+// are removed.
+func TestPublish_RetriesFailedDelivery(t *testing.T) {
+	s, _ := newTestServer(t)
+	healthy := &inboxRecorder{status: http.StatusAccepted}
+	failing := &inboxRecorder{status: http.StatusServiceUnavailable}
+	healthySrv, failingSrv := httptest.NewServer(healthy), httptest.NewServer(failing)
+	defer healthySrv.Close()
+	defer failingSrv.Close()
+
+	notes := []Note{{ID: s.BaseURL + "/2025/01/01", Content: "<p>first</p>", Published: time.Now()}}
+	if _, err := s.Publish(context.Background(), notes); err != nil {
+		t.Fatal(err)
+	}
+	for _, srv := range []*httptest.Server{healthySrv, failingSrv} {
+		if err := s.State.AddFollower(Follower{Actor: srv.URL + "/actor", Inbox: srv.URL + "/inbox"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notes = append([]Note{{ID: s.BaseURL + "/2025/01/02", Content: "<p>second</p>", Published: time.Now()}}, notes...)
+	if n, err := s.Publish(context.Background(), notes); err == nil || n != 0 {
+		t.Fatalf("Publish to a failing inbox = %d, %v; want 0 and an error", n, err)
+	}
+	if got := healthy.take(); got != "Create" {
+		t.Errorf("healthy inbox received %q, want Create", got)
+	}
+	if len(s.State.Pending) != 1 || s.State.Pending[0].Inbox != failingSrv.URL+"/inbox" {
+		t.Errorf("Pending = %+v, want the failing inbox", s.State.Pending)
+	}
+
+	// Trying again only reaches the inbox that failed, and an edit made
+	// meanwhile still arrives there as a Create.
+	failing.set(http.StatusAccepted)
+	notes[0].Content = "<p>second, edited</p>"
+	if n, err := s.Publish(context.Background(), notes); err != nil || n != 1 {
+		t.Fatalf("Publish = %d, %v; want 1, nil", n, err)
+	}
+	if got := healthy.take(); got != "Update" {
+		t.Errorf("healthy inbox received %q, want Update", got)
+	}
+	if got := failing.take(); got != "Create" {
+		t.Errorf("recovered inbox received %q, want Create", got)
+	}
+	if n, err := s.Publish(context.Background(), notes); err != nil || n != 0 {
+		t.Errorf("Publish again = %d, %v; want 0, nil", n, err)
+	}
+	if got := healthy.take() + failing.take(); got != "" {
+		t.Errorf("Publish again delivered %q", got)
+	}
+
+	// A follower whose inbox is gone is removed.
+	failing.set(http.StatusGone)
+	notes[0].Content = "<p>second, edited again</p>"
+	if _, err := s.Publish(context.Background(), notes); err != nil {
+		t.Errorf("Publish to a gone inbox: %v", err)
+	}
+	if len(s.State.Followers) != 1 || s.State.Followers[0].Inbox != healthySrv.URL+"/inbox" {
+		t.Errorf("Followers = %+v, want only the healthy one", s.State.Followers)
+	}
+	if len(s.State.Pending) != 0 {
+		t.Errorf("Pending = %+v, want none", s.State.Pending)
+	}
+}
+
+// TestInbox_PrivateAddresses checks that an unauthenticated activity cannot
+// make the server reach into its own network.
+func TestInbox_PrivateAddresses(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.AllowPrivate = false
+	bob := newRemote(t, func() *Server { return s })
+	var mu sync.Mutex
+	fetched := 0
+	bob.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched++
+		mu.Unlock()
+	})
+
+	follow := map[string]any{
+		"id":     bob.actor() + "#follow-1",
+		"type":   "Follow",
+		"actor":  bob.actor(),
+		"object": s.ActorID(),
+	}
+	if code := bob.send(t, s.ActorID()+"/inbox", follow); code != http.StatusUnauthorized {
+		t.Errorf("Follow signed with a key on a private address: status = %d, want 401", code)
+	}
+	if err := s.post(context.Background(), bob.actor()+"/inbox", follow); err == nil {
+		t.Error("Delivered to an inbox on a private address")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fetched != 0 {
+		t.Errorf("Server made %d requests to a private address", fetched)
+	}
+}
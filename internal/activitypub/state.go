@@ -0,0 +1,142 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Follower is a remote actor following the plan.
+type Follower struct {
+	Actor       string    `json:"actor"`
+	Inbox       string    `json:"inbox"`
+	SharedInbox string    `json:"shared_inbox,omitempty"`
+	Since       time.Time `json:"since"`
+}
+
+// inbox returns where activities for f are delivered: its server's shared
+// inbox if it has one.
+func (f Follower) inbox() string {
+	if f.SharedInbox != "" {
+		return f.SharedInbox
+	}
+	return f.Inbox
+}
+
+// Pending is a delivery of a note that failed, to be tried again.
+type Pending struct {
+	Note  string    `json:"note"`
+	Inbox string    `json:"inbox"`
+	Type  string    `json:"type"` // Create or Update
+	Since time.Time `json:"since"`
+}
+
+// State is the locally persisted ActivityPub state: followers and the notes
+// that have been announced to them.
+type State struct {
+	path string
+	mu   sync.Mutex
+
+	Followers []Follower `json:"followers"`
+	// Published maps each note ID to a hash of the content last delivered.
+	Published map[string]string `json:"published"`
+	// Pending are the deliveries of the published notes that failed.
+	Pending []Pending `json:"pending,omitempty"`
+}
+
+// LoadState reads the state file at path. A missing file yields empty state.
+func LoadState(path string) (*State, error) {
+	s := &State{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	if s.Published == nil {
+		s.Published = make(map[string]string)
+	}
+	return s, nil
+}
+
+// AddFollower records f, replacing any earlier entry for the same actor.
+func (s *State) AddFollower(f Follower) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, old := range s.Followers {
+		if old.Actor == f.Actor {
+			f.Since = old.Since
+			s.Followers[i] = f
+			return s.save()
+		}
+	}
+	s.Followers = append(s.Followers, f)
+	return s.save()
+}
+
+// RemoveFollower forgets actor.
+func (s *State) RemoveFollower(actor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.Followers[:0]
+	for _, f := range s.Followers {
+		if f.Actor != actor {
+			out = append(out, f)
+		}
+	}
+	s.Followers = out
+	return s.save()
+}
+
+// followers returns a copy of the follower list.
+func (s *State) followers() []Follower {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Follower(nil), s.Followers...)
+}
+
+// followerInboxes returns the inbox of every follower, each once.
+func (s *State) followerInboxes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inboxes()
+}
+
+func (s *State) inboxes() []string {
+	var inboxes []string
+	for _, f := range s.Followers {
+		if !slices.Contains(inboxes, f.inbox()) {
+			inboxes = append(inboxes, f.inbox())
+		}
+	}
+	return inboxes
+}
+
+// Save writes the state back to its file.
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+func (s *State) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
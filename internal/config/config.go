@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 type Config struct {
@@ -14,6 +15,85 @@ type Config struct {
 	Timezone  string `json:"timezone"`
 	Title     string `json:"title"`
 	BaseURL   string `json:"base_url"`
+	// Theme selects a built-in look (see `plan theme list`). A template.html
+	// in the plan directory takes precedence.
+	Theme string `json:"theme"`
+
+	// Webmention is the endpoint advertised to other sites, e.g. the
+	// /webmention endpoint of `plan serve`.
+	Webmention string `json:"webmention"`
+	// WebSubHub is the WebSub hub advertised by the feed and pages, and
+	// notified after `plan deploy`, e.g. the one `plan websub-hub` runs.
+	WebSubHub string `json:"websub_hub"`
+	// SendWebmentions enables sending Webmentions for outbound links when
+	// new days are published.
+	SendWebmentions bool `json:"send_webmentions"`
+	// ActivityPub makes `plan serve` publish the plan as an ActivityPub
+	// actor, username@host, that can be followed from the fediverse.
+	ActivityPub bool `json:"activitypub"`
+	// Feed configures the RSS feed.
+	Feed FeedConfig `json:"feed"`
+	// Mail configures `plan mail` email digests.
+	Mail MailConfig `json:"mail"`
+	// Deploy lists the places `plan deploy` publishes the built site to.
+	Deploy []DeployTarget `json:"deploy"`
+}
+
+// DeployTarget is a single destination for `plan deploy`. Which fields apply
+// depends on Type.
+type DeployTarget struct {
+	Name string `json:"name"` // defaults to Type
+	Type string `json:"type"` // "s3", "rsync" or "git"
+
+	// s3: credentials fall back to AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+	// and AWS_SESSION_TOKEN.
+	Bucket            string `json:"bucket"`
+	Region            string `json:"region"`
+	Endpoint          string `json:"endpoint"` // e.g. http://localhost:9000 for MinIO
+	Prefix            string `json:"prefix"`
+	AccessKeyID       string `json:"access_key_id"`
+	SecretAccessKey   string `json:"secret_access_key"`
+	Gzip              bool   `json:"gzip"`
+	PageCacheControl  string `json:"page_cache_control"`
+	AssetCacheControl string `json:"asset_cache_control"`
+
+	// rsync
+	Dest string   `json:"dest"`
+	Args []string `json:"args"`
+
+	// git
+	Remote string `json:"remote"`
+	Branch string `json:"branch"`
+}
+
+// FeedConfig controls what the RSS feed carries.
+type FeedConfig struct {
+	// Mode is "full" for the whole plan in every item, "diff" for only the
+	// sections added or changed since the previous day, or "summary" for
+	// the first paragraph that changed.
+	Mode string `json:"mode"`
+	// MaxItems caps the items in rss.xml, 0 for no cap. Older items are
+	// kept in RFC 5005 archive documents under feed/.
+	MaxItems int `json:"max_items"`
+}
+
+// MailConfig holds the SMTP settings and recipients for email digests.
+type MailConfig struct {
+	SMTPHost string `json:"smtp_host"`
+	SMTPPort int    `json:"smtp_port"` // 587 by default; 465 uses implicit TLS
+	Username string `json:"username"`
+	// Password is better left out of settings.json, and set with
+	// PLAN_MAIL_PASSWORD instead.
+	Password string `json:"password"`
+	// StartTLS refuses to send unless the server supports STARTTLS.
+	StartTLS bool     `json:"starttls"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// Mode is "latest" to send the latest day in full, or "diff" to send
+	// only what changed since the last mailing.
+	Mode string `json:"mode"`
+	// AfterPublish mails subscribers after every `plan publish`.
+	AfterPublish bool `json:"after_publish"`
 }
 
 // DefaultConfig returns the default configuration based on environment variables
@@ -33,6 +113,14 @@ func DefaultConfig() Config {
 		Timezone:  "America/Los_Angeles", // Default fallback
 		Title:     "Plan",
 		BaseURL:   "http://localhost:8081", // Default base URL for local preview
+		Feed: FeedConfig{
+			Mode: "full",
+		},
+		Mail: MailConfig{
+			SMTPPort: 587,
+			StartTLS: true,
+			Mode:     "latest",
+		},
 	}
 }
 
@@ -48,6 +136,11 @@ func Load(path string) (Config, error) {
 		return cfg, fmt.Errorf("reading settings file: %w", err)
 	}
 
+	name := filepath.Base(path)
+	if problems, _ := checkFile(name, data); len(problems) > 0 {
+		return cfg, &ValidationError{Problems: problems}
+	}
+
 	// Unmarshal into a temporary map or directly into struct?
 	// To preserve defaults for missing fields, we decode into the struct.
 	// JSON unmarshal doesn't reset fields that are missing in JSON.
@@ -55,5 +148,11 @@ func Load(path string) (Config, error) {
 		return cfg, fmt.Errorf("parsing settings file: %w", err)
 	}
 
+	if problems := cfg.Validate(); len(problems) > 0 {
+		for i := range problems {
+			problems[i].Source = name
+		}
+		return cfg, &ValidationError{Problems: problems}
+	}
 	return cfg, nil
 }
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "settings.json", `{"username": "alice", "title": "Plan", "mail": {"smtp_host": "localhost"}}`)
+	writeFile(t, dir, "settings.production.json", `{"base_url": "https://plan.example.com", "mail": {"smtp_host": "smtp.example.com"}}`)
+
+	cfg, sources, err := LoadLayers(dir, Options{
+		Env:       "production",
+		Environ:   []string{"PLAN_TITLE=From Env", "PLAN_MAIL_TO=a@example.com, b@example.com", "PLAN_MAIL_SMTP_PORT=465", "HOME=/root"},
+		Overrides: []string{"username=bob", "activitypub=true"},
+	})
+	if err != nil {
+		t.Fatalf("LoadLayers failed: %v", err)
+	}
+
+	if cfg.Username != "bob" || sources["username"] != "-set" {
+		t.Errorf("Override not applied: %q from %q", cfg.Username, sources["username"])
+	}
+	if !cfg.ActivityPub {
+		t.Errorf("Boolean override not applied")
+	}
+	if cfg.Title != "From Env" || sources["title"] != "$PLAN_TITLE" {
+		t.Errorf("Environment not applied: %q from %q", cfg.Title, sources["title"])
+	}
+	if cfg.BaseURL != "https://plan.example.com" || sources["base_url"] != "settings.production.json" {
+		t.Errorf("Environment file not applied: %q from %q", cfg.BaseURL, sources["base_url"])
+	}
+	if cfg.Mail.SMTPHost != "smtp.example.com" || sources["mail.smtp_host"] != "settings.production.json" {
+		t.Errorf("Nested setting not overlaid: %q from %q", cfg.Mail.SMTPHost, sources["mail.smtp_host"])
+	}
+	if cfg.Mail.SMTPPort != 465 || !reflect.DeepEqual(cfg.Mail.To, []string{"a@example.com", "b@example.com"}) {
+		t.Errorf("Typed environment values not parsed: %d %q", cfg.Mail.SMTPPort, cfg.Mail.To)
+	}
+	if cfg.Mail.Mode != "latest" || sources["mail.mode"] != SourceDefault {
+		t.Errorf("Default lost: %q from %q", cfg.Mail.Mode, sources["mail.mode"])
+	}
+}
+
+func TestLoadLayers_Errors(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := LoadLayers(dir, Options{}); err != nil {
+		t.Errorf("Missing settings.json should not be an error: %v", err)
+	}
+	if _, _, err := LoadLayers(dir, Options{Env: "staging"}); err == nil {
+		t.Error("Expected an error for a missing environment file")
+	}
+	if _, _, err := LoadLayers(dir, Options{Overrides: []string{"nope=1"}}); err == nil {
+		t.Error("Expected an error for an unknown setting")
+	}
+	if _, _, err := LoadLayers(dir, Options{Environ: []string{"PLAN_MAIL_SMTP_PORT=abc"}}); err == nil {
+		t.Error("Expected an error for an invalid number")
+	}
+}
+
+func TestLoadLayers_Validation(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "settings.json", `{
+  "username": "alice",
+  "timezon": "UTC",
+  "timezone": "Mars/Olympus_Mons",
+  "base_url": "plan.example.com",
+  "mail": {"smtp_port": "587", "form": "a@example.com"}
+}`)
+
+	_, _, err := LoadLayers(dir, Options{})
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	want := []string{
+		`settings.json:3:3: timezon: unknown setting (did you mean "timezone"?)`,
+		`settings.json:6:12: mail.smtp_port: expected a whole number, got the string "587"`,
+		`settings.json:6:32: mail.form: unknown setting (did you mean "from"?)`,
+		`settings.json:4:3: timezone: unknown time zone "Mars/Olympus_Mons" (use an IANA name such as "America/New_York" or "UTC")`,
+		`settings.json:5:3: base_url: "plan.example.com" must be an absolute http or https URL, such as "https://plan.example.com"`,
+	}
+	var got []string
+	for _, p := range verr.Problems {
+		got = append(got, p.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected problems:\n%s", strings.Join(got, "\n"))
+	}
+
+	// Values set by the environment are reported against it.
+	_, _, err = LoadLayers(t.TempDir(), Options{Environ: []string{"PLAN_TIMEZONE=Nowhere"}})
+	if err == nil || !strings.Contains(err.Error(), `$PLAN_TIMEZONE: timezone: unknown time zone "Nowhere"`) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	writeFile(t, dir, "settings.json", "{\n  \"title\": \"Plan\",\n}")
+	_, _, err = LoadLayers(dir, Options{})
+	if err == nil || !strings.Contains(err.Error(), "settings.json:2:19: syntax error") {
+		t.Errorf("Expected a syntax error with its position, got %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// SourceDefault is the source of settings that were not set by any layer.
+const SourceDefault = "default"
+
+// Sources maps each setting, by its dotted key (e.g. "mail.smtp_host"), to
+// the layer its effective value came from.
+type Sources map[string]string
+
+// Options select the layers applied on top of the defaults.
+type Options struct {
+	// Env selects settings.<Env>.json, e.g. "production".
+	Env string
+	// Environ holds the environment to read PLAN_* variables from, in the
+	// form returned by os.Environ.
+	Environ []string
+	// Overrides are key=value settings from the command line.
+	Overrides []string
+}
+
+// LoadLayers builds the configuration of the plan in dir from these layers,
+// each overriding the ones before it:
+//
+//	defaults → settings.json → settings.<env>.json → PLAN_* variables → overrides
+//
+// Environment variables are named after the setting's key, so mail.smtp_host
+// is set by PLAN_MAIL_SMTP_HOST. Settings files must only contain known
+// settings of the right type, and the final values must be valid; all the
+// problems found are returned together as a *ValidationError.
+func LoadLayers(dir string, opts Options) (Config, Sources, error) {
+	cfg := DefaultConfig()
+	sources := make(Sources)
+	for _, s := range settingFields() {
+		sources[s.key] = SourceDefault
+	}
+
+	var problems []Problem
+	positions := make(map[string]map[string]position)
+
+	files := []string{"settings.json"}
+	if opts.Env != "" {
+		files = append(files, "settings."+opts.Env+".json")
+	}
+	for i, name := range files {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			if i == 0 {
+				continue
+			}
+			return cfg, sources, fmt.Errorf("environment %q: %s not found", opts.Env, name)
+		}
+		if err != nil {
+			return cfg, sources, fmt.Errorf("reading %s: %w", name, err)
+		}
+		fileProblems, pos := checkFile(name, data)
+		problems = append(problems, fileProblems...)
+		positions[name] = pos
+		// Apply what can be applied even when there are problems, so that
+		// the values are checked too.
+		if err := json.Unmarshal(data, &cfg); err != nil && len(fileProblems) == 0 {
+			return cfg, sources, fmt.Errorf("parsing %s: %w", name, err)
+		}
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(data, &raw); err == nil {
+			markSources(sources, raw, "", name)
+		}
+	}
+
+	env := make(map[string]string)
+	for _, kv := range opts.Environ {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	for _, s := range settingFields() {
+		name := EnvName(s.key)
+		if v, ok := env[name]; ok {
+			if err := s.set(&cfg, v); err != nil {
+				return cfg, sources, fmt.Errorf("%s: %w", name, err)
+			}
+			sources[s.key] = "$" + name
+		}
+	}
+
+	for _, o := range opts.Overrides {
+		key, v, ok := strings.Cut(o, "=")
+		if !ok {
+			return cfg, sources, fmt.Errorf("-set %q: expected key=value", o)
+		}
+		s, ok := lookupSetting(key)
+		if !ok {
+			return cfg, sources, fmt.Errorf("-set %q: unknown setting %q", o, key)
+		}
+		if err := s.set(&cfg, v); err != nil {
+			return cfg, sources, fmt.Errorf("-set %s: %w", key, err)
+		}
+		sources[s.key] = "-set"
+	}
+
+	// Values are checked once all layers are applied, and reported against
+	// the layer that set them.
+	for _, p := range cfg.Validate() {
+		root, _, _ := strings.Cut(p.Key, "[")
+		p.Source = sources[root]
+		if pos, ok := positions[p.Source][p.Key]; ok {
+			p.Line, p.Column = pos.line, pos.column
+		}
+		problems = append(problems, p)
+	}
+	if len(problems) > 0 {
+		return cfg, sources, &ValidationError{Problems: problems}
+	}
+	return cfg, sources, nil
+}
+
+// EnvName returns the environment variable that sets key.
+func EnvName(key string) string {
+	return "PLAN_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Setting is a single setting and its value.
+type Setting struct {
+	Key   string
+	Value any
+}
+
+// Settings returns every setting of c in the order they are declared.
+func (c *Config) Settings() []Setting {
+	v := reflect.ValueOf(c).Elem()
+	var settings []Setting
+	for _, s := range settingFields() {
+		settings = append(settings, Setting{Key: s.key, Value: v.FieldByIndex(s.index).Interface()})
+	}
+	return settings
+}
+
+// settingField locates a setting in the Config struct. Nested structs such as
+// MailConfig contribute one setting per field, under a dotted key.
+type settingField struct {
+	key   string
+	index []int
+}
+
+func settingFields() []settingField {
+	return appendFields(nil, reflect.TypeOf(Config{}), "", nil)
+}
+
+func appendFields(fields []settingField, t reflect.Type, prefix string, index []int) []settingField {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		idx := append(append([]int(nil), index...), i)
+		if f.Type.Kind() == reflect.Struct {
+			fields = appendFields(fields, f.Type, prefix+name+".", idx)
+			continue
+		}
+		fields = append(fields, settingField{key: prefix + name, index: idx})
+	}
+	return fields
+}
+
+func lookupSetting(key string) (settingField, bool) {
+	for _, s := range settingFields() {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return settingField{}, false
+}
+
+// set parses value into the setting. Strings are taken as is, lists of
+// strings may be comma-separated, and anything else is parsed as JSON.
+func (s settingField) set(cfg *Config, value string) error {
+	v := reflect.ValueOf(cfg).Elem().FieldByIndex(s.index)
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "["):
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value), v.Addr().Interface()); err != nil {
+		return fmt.Errorf("invalid value for %s: %v", s.key, err)
+	}
+	return nil
+}
+
+// markSources records source for every setting present in raw, descending
+// into nested objects.
+func markSources(sources Sources, raw map[string]json.RawMessage, prefix, source string) {
+	for k, v := range raw {
+		key := prefix + k
+		if _, ok := sources[key]; ok {
+			sources[key] = source
+			continue
+		}
+		var nested map[string]json.RawMessage
+		if json.Unmarshal(v, &nested) == nil {
+			markSources(sources, nested, key+".", source)
+		}
+	}
+}
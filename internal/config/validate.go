@@ -0,0 +1,287 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/suggest"
+)
+
+// Problem is a single mistake in the configuration.
+type Problem struct {
+	Source       string // e.g. "settings.json" or "$PLAN_TIMEZONE"
+	Line, Column int    // position in Source, when it is a file
+	Key          string
+	Message      string
+}
+
+func (p Problem) String() string {
+	var sb strings.Builder
+	sb.WriteString(p.Source)
+	if p.Line > 0 {
+		fmt.Fprintf(&sb, ":%d:%d", p.Line, p.Column)
+	}
+	if p.Key != "" {
+		sb.WriteString(": " + p.Key)
+	}
+	return sb.String() + ": " + p.Message
+}
+
+// ValidationError reports every problem found in the configuration.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("invalid configuration:")
+	for _, p := range e.Problems {
+		sb.WriteString("\n  " + p.String())
+	}
+	return sb.String()
+}
+
+// position is the line and column of a key in a settings file.
+type position struct{ line, column int }
+
+func positionAt(data []byte, offset int64) position {
+	before := data[:min(int(offset), len(data))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return position{line, column}
+}
+
+// checkFile reports syntax errors, unknown keys and values of the wrong type
+// in a settings file. It also returns the position of every key it found.
+func checkFile(name string, data []byte) ([]Problem, map[string]position) {
+	c := &fileChecker{name: name, data: data, positions: make(map[string]position)}
+	c.dec = json.NewDecoder(bytes.NewReader(data))
+	c.dec.UseNumber()
+	if err := c.value(reflect.TypeOf(Config{}), "", position{1, 1}); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			pos := positionAt(data, syntax.Offset)
+			c.problems = append(c.problems, Problem{Source: name, Line: pos.line, Column: pos.column, Message: "syntax error: " + syntax.Error()})
+		} else {
+			c.problems = append(c.problems, Problem{Source: name, Message: err.Error()})
+		}
+	}
+	return c.problems, c.positions
+}
+
+type fileChecker struct {
+	name      string
+	data      []byte
+	dec       *json.Decoder
+	problems  []Problem
+	positions map[string]position
+}
+
+func (c *fileChecker) problem(pos position, key, format string, args ...any) {
+	c.problems = append(c.problems, Problem{Source: c.name, Line: pos.line, Column: pos.column, Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// value checks the next JSON value against t, which is nil when the value
+// is not checked. key names the value and pos is where its key is.
+func (c *fileChecker) value(t reflect.Type, key string, pos position) error {
+	tok, err := c.dec.Token()
+	if err != nil {
+		if errors.Is(err, io.EOF) && key == "" {
+			return nil // an empty file
+		}
+		return err
+	}
+	if tok == nil {
+		return nil // null leaves the setting unchanged
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		if t != nil && t.Kind() != reflect.Struct {
+			c.problem(pos, key, "expected %s, got an object", describe(t))
+			t = nil
+		}
+		for c.dec.More() {
+			keyTok, err := c.dec.Token()
+			if err != nil {
+				return err
+			}
+			name := keyTok.(string)
+			namePos := positionAt(c.data, c.dec.InputOffset()-int64(len(name))-2)
+			childKey := name
+			if key != "" {
+				childKey = key + "." + name
+			}
+
+			var ft reflect.Type
+			if t != nil {
+				if f, ok := jsonField(t, name); ok {
+					ft = f.Type
+					c.positions[childKey] = namePos
+				} else {
+					msg := "unknown setting"
+					if s := suggest.Closest(name, jsonNames(t)); s != "" {
+						msg += fmt.Sprintf(" (did you mean %q?)", s)
+					}
+					c.problem(namePos, childKey, "%s", msg)
+				}
+			}
+			if err := c.value(ft, childKey, namePos); err != nil {
+				return err
+			}
+		}
+		_, err := c.dec.Token() // '}'
+		return err
+
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil {
+			if t.Kind() == reflect.Slice {
+				elem = t.Elem()
+			} else {
+				c.problem(pos, key, "expected %s, got a list", describe(t))
+			}
+		}
+		for i := 0; c.dec.More(); i++ {
+			if err := c.value(elem, fmt.Sprintf("%s[%d]", key, i), pos); err != nil {
+				return err
+			}
+		}
+		_, err := c.dec.Token() // ']'
+		return err
+	}
+
+	if t == nil {
+		return nil
+	}
+	var ok bool
+	switch v := tok.(type) {
+	case string:
+		ok = t.Kind() == reflect.String
+	case bool:
+		ok = t.Kind() == reflect.Bool
+	case json.Number:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int64:
+			_, err := v.Int64()
+			ok = err == nil
+		case reflect.Float64:
+			ok = true
+		}
+	}
+	if !ok {
+		c.problem(pos, key, "expected %s, got %s", describe(t), describeToken(tok))
+	}
+	return nil
+}
+
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func jsonNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); tag != "" && tag != "-" {
+			names = append(names, tag)
+		}
+	}
+	return names
+}
+
+func describe(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int64:
+		return "a whole number"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
+
+func describeToken(tok json.Token) string {
+	switch v := tok.(type) {
+	case string:
+		return fmt.Sprintf("the string %q", v)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	case json.Number:
+		return "the number " + v.String()
+	}
+	return fmt.Sprint(tok)
+}
+
+// Validate checks the values of the settings, returning a problem for each
+// one that is invalid. Problems name the setting but not where it was set.
+func (c *Config) Validate() []Problem {
+	var problems []Problem
+	add := func(key, format string, args ...any) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			add("timezone", "unknown time zone %q (use an IANA name such as \"America/New_York\" or \"UTC\")", c.Timezone)
+		}
+	}
+	if msg := checkAbsoluteURL(c.BaseURL); msg != "" {
+		add("base_url", "%s", msg)
+	}
+	if c.Webmention != "" {
+		if msg := checkAbsoluteURL(c.Webmention); msg != "" {
+			add("webmention", "%s", msg)
+		}
+	}
+	if c.WebSubHub != "" {
+		if msg := checkAbsoluteURL(c.WebSubHub); msg != "" {
+			add("websub_hub", "%s", msg)
+		}
+	}
+	if c.Feed.Mode != "full" && c.Feed.Mode != "diff" && c.Feed.Mode != "summary" {
+		add("feed.mode", "must be \"full\", \"diff\" or \"summary\", not %q", c.Feed.Mode)
+	}
+	if c.Feed.MaxItems < 0 {
+		add("feed.max_items", "must be 0 (no cap) or more, not %d", c.Feed.MaxItems)
+	}
+	if c.Mail.Mode != "latest" && c.Mail.Mode != "diff" {
+		add("mail.mode", "must be \"latest\" or \"diff\", not %q", c.Mail.Mode)
+	}
+	for i, t := range c.Deploy {
+		if t.Type != "s3" && t.Type != "rsync" && t.Type != "git" {
+			add(fmt.Sprintf("deploy[%d].type", i), "must be \"s3\", \"rsync\" or \"git\", not %q", t.Type)
+		}
+	}
+	return problems
+}
+
+func checkAbsoluteURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Sprintf("%q is not a valid URL: %v", s, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Sprintf("%q must be an absolute http or https URL, such as \"https://plan.example.com\"", s)
+	}
+	return ""
+}
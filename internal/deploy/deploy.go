@@ -0,0 +1,55 @@
+// Package deploy publishes a built site to hosting targets: S3-compatible
+// buckets, rsync destinations and git branches.
+package deploy
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// Target is a place a built site can be deployed to.
+type Target interface {
+	// Deploy makes the target match the contents of dir: new and changed
+	// files are uploaded and files that are no longer in dir are removed.
+	Deploy(ctx context.Context, dir string, opts Options) error
+}
+
+// Options control a deployment.
+type Options struct {
+	// DryRun reports what would change without changing anything.
+	DryRun bool
+	// Message describes the deployment, e.g. as a commit message.
+	Message string
+	// Log receives progress output. It may be nil.
+	Log io.Writer
+}
+
+func (o Options) log() io.Writer {
+	if o.Log == nil {
+		return io.Discard
+	}
+	return o.Log
+}
+
+// files returns the paths of the regular files under dir, relative to dir
+// and slash-separated.
+func files(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	return paths, err
+}
@@ -0,0 +1,103 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// GitBranch deploys by committing the site to a branch of a git remote, as
+// used by GitHub Pages and similar hosts. Each deployment adds a commit on
+// top of the branch, so earlier deployments stay in its history.
+type GitBranch struct {
+	// Remote is a URL or path that git can push to.
+	Remote string
+	Branch string // defaults to gh-pages
+}
+
+// Deploy commits the contents of dir to the branch and pushes it. The
+// commit is made in a temporary repository, leaving dir untouched.
+func (g *GitBranch) Deploy(ctx context.Context, dir string, opts Options) error {
+	if g.Remote == "" {
+		return fmt.Errorf("git: no remote")
+	}
+	branch := g.Branch
+	if branch == "" {
+		branch = "gh-pages"
+	}
+	ref := "refs/heads/" + branch
+	w := opts.log()
+
+	gitDir, err := os.MkdirTemp("", "plan-deploy-*.git")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(gitDir)
+
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Env = append(os.Environ(), "GIT_DIR="+gitDir, "GIT_WORK_TREE="+dir)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return string(out), nil
+	}
+
+	if _, err := git("init", "--quiet"); err != nil {
+		return err
+	}
+	if _, err := git("config", "user.email"); err != nil {
+		// Without an identity of its own, commit as the tool.
+		git("config", "user.name", "plan")
+		git("config", "user.email", "plan@localhost")
+	}
+
+	// Start from the branch as it is on the remote, if it exists yet.
+	heads, err := git("ls-remote", "--heads", g.Remote, ref)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(heads) != "" {
+		if _, err := git("fetch", "--quiet", "--depth=1", g.Remote, ref); err != nil {
+			return err
+		}
+		if _, err := git("reset", "--quiet", "FETCH_HEAD"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := git("add", "--all", "."); err != nil {
+		return err
+	}
+	changes, err := git("diff", "--cached", "--name-status")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(changes) == "" {
+		fmt.Fprintf(w, "%s %s is up to date\n", g.Remote, branch)
+		return nil
+	}
+	fmt.Fprint(w, changes)
+	if opts.DryRun {
+		return nil
+	}
+
+	message := opts.Message
+	if message == "" {
+		message = "Deploy plan"
+	}
+	if _, err := git("commit", "--quiet", "-m", message); err != nil {
+		return err
+	}
+	if _, err := git("push", "--quiet", g.Remote, "HEAD:"+ref); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Pushed to %s %s\n", g.Remote, branch)
+	return nil
+}
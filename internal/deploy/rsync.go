@@ -0,0 +1,38 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Rsync deploys to a local directory or an rsync/ssh destination such as
+// user@host:/var/www/plan.
+type Rsync struct {
+	Dest string
+	// Args are extra arguments for rsync, e.g. ["-e", "ssh -p 2222"].
+	Args []string
+}
+
+// Deploy runs rsync to mirror dir to the destination. Files are compared by
+// checksum, since every build rewrites them with a new modification time.
+func (r *Rsync) Deploy(ctx context.Context, dir string, opts Options) error {
+	if r.Dest == "" {
+		return fmt.Errorf("rsync: no destination")
+	}
+	args := []string{"-rlz", "--checksum", "--delete", "--itemize-changes"}
+	if opts.DryRun {
+		args = append(args, "--dry-run")
+	}
+	args = append(args, r.Args...)
+	args = append(args, strings.TrimSuffix(dir, "/")+"/", r.Dest)
+
+	cmd := exec.CommandContext(ctx, "rsync", args...)
+	cmd.Stdout = opts.log()
+	cmd.Stderr = opts.log()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rsync: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,444 @@
+package deploy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 deploys to a bucket on Amazon S3 or a compatible service such as MinIO
+// or Cloudflare R2. Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	Bucket string
+	Region string // defaults to us-east-1
+	// Endpoint is the URL of an S3-compatible service, e.g.
+	// http://localhost:9000. Buckets on it are addressed path-style. When
+	// empty, Amazon S3 itself is used.
+	Endpoint string
+	// Prefix is prepended to every key, e.g. "plan/".
+	Prefix string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Gzip compresses text files and serves them with Content-Encoding: gzip.
+	Gzip bool
+	// PageCacheControl and AssetCacheControl override the default
+	// Cache-Control of pages and feeds, and of everything else.
+	PageCacheControl  string
+	AssetCacheControl string
+
+	Client *http.Client
+	now    func() time.Time
+}
+
+const (
+	defaultPageCacheControl  = "public, max-age=60, must-revalidate"
+	defaultAssetCacheControl = "public, max-age=86400"
+)
+
+// Deploy uploads the files in dir whose content or headers differ from the
+// bucket and deletes objects under the prefix that are no longer in dir.
+func (s *S3) Deploy(ctx context.Context, dir string, opts Options) error {
+	w := opts.log()
+	remote, err := s.list(ctx)
+	if err != nil {
+		return fmt.Errorf("listing bucket %s: %w", s.Bucket, err)
+	}
+	paths, err := files(dir)
+	if err != nil {
+		return err
+	}
+
+	uploaded, deleted := 0, 0
+	local := make(map[string]bool)
+	for _, p := range paths {
+		key := s.key(p)
+		local[key] = true
+
+		obj, err := s.object(filepath.Join(dir, filepath.FromSlash(p)))
+		if err != nil {
+			return err
+		}
+		if remote[key] == obj.etag {
+			// The same bytes may still be served with other headers, e.g.
+			// after the cache settings changed.
+			same, err := s.sameHeaders(ctx, key, obj)
+			if err != nil {
+				return fmt.Errorf("checking %s: %w", key, err)
+			}
+			if same {
+				continue
+			}
+		}
+		fmt.Fprintf(w, "upload %s\n", key)
+		uploaded++
+		if opts.DryRun {
+			continue
+		}
+		if err := s.put(ctx, key, obj); err != nil {
+			return fmt.Errorf("uploading %s: %w", key, err)
+		}
+	}
+
+	var stale []string
+	for key := range remote {
+		if !local[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+	for _, key := range stale {
+		fmt.Fprintf(w, "delete %s\n", key)
+		deleted++
+		if opts.DryRun {
+			continue
+		}
+		if err := s.delete(ctx, key); err != nil {
+			return fmt.Errorf("deleting %s: %w", key, err)
+		}
+	}
+
+	fmt.Fprintf(w, "%d uploaded, %d deleted, %d unchanged\n", uploaded, deleted, len(paths)-uploaded)
+	return nil
+}
+
+func (s *S3) key(p string) string {
+	prefix := strings.Trim(s.Prefix, "/")
+	if prefix == "" {
+		return p
+	}
+	return prefix + "/" + p
+}
+
+// s3Object is a file prepared for upload.
+type s3Object struct {
+	body            []byte
+	etag            string // hex MD5 of body, as S3 reports it for simple uploads
+	contentType     string
+	contentEncoding string
+	cacheControl    string
+}
+
+func (s *S3) object(path string) (s3Object, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s3Object{}, err
+	}
+	obj := s3Object{body: data, contentType: contentType(path), cacheControl: s.AssetCacheControl}
+	if obj.cacheControl == "" {
+		obj.cacheControl = defaultAssetCacheControl
+	}
+	if isPage(path) {
+		obj.cacheControl = s.PageCacheControl
+		if obj.cacheControl == "" {
+			obj.cacheControl = defaultPageCacheControl
+		}
+	}
+	if s.Gzip && compressible(obj.contentType) {
+		// The gzip header carries no timestamp, so unchanged files compress
+		// to the same bytes and keep their ETag.
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			return s3Object{}, err
+		}
+		obj.body = buf.Bytes()
+		obj.contentEncoding = "gzip"
+	}
+	sum := md5.Sum(obj.body)
+	obj.etag = hex.EncodeToString(sum[:])
+	return obj, nil
+}
+
+func contentType(path string) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// isPage reports whether path is a page or feed, which changes whenever the
+// plan does, as opposed to an asset.
+func isPage(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".xml", ".json", ".txt":
+		return true
+	}
+	return false
+}
+
+func compressible(contentType string) bool {
+	t, _, _ := strings.Cut(contentType, ";")
+	return strings.HasPrefix(t, "text/") || strings.HasSuffix(t, "+xml") ||
+		t == "application/json" || t == "application/xml" || t == "application/javascript" ||
+		t == "image/svg+xml"
+}
+
+func (s *S3) put(ctx context.Context, key string, obj s3Object) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, nil, obj.body)
+	if err != nil {
+		return err
+	}
+	sum, _ := hex.DecodeString(obj.etag)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+	req.Header.Set("Content-Type", obj.contentType)
+	req.Header.Set("Cache-Control", obj.cacheControl)
+	if obj.contentEncoding != "" {
+		req.Header.Set("Content-Encoding", obj.contentEncoding)
+	}
+	_, err = s.do(req, obj.body)
+	return err
+}
+
+// sameHeaders reports whether the object at key is stored with the headers
+// obj would be uploaded with.
+func (s *S3) sameHeaders(ctx context.Context, key string, obj s3Object) (bool, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	header, err := s.head(req)
+	if err != nil {
+		return false, err
+	}
+	return header.Get("Content-Type") == obj.contentType &&
+		header.Get("Cache-Control") == obj.cacheControl &&
+		header.Get("Content-Encoding") == obj.contentEncoding, nil
+}
+
+func (s *S3) delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(req, nil)
+	return err
+}
+
+// list returns the ETag of every object under the prefix, by key.
+func (s *S3) list(ctx context.Context) (map[string]string, error) {
+	objects := make(map[string]string)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix := strings.Trim(s.Prefix, "/"); prefix != "" {
+			query.Set("prefix", prefix+"/")
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := s.do(req, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key  string
+				ETag string
+			}
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("parsing listing: %w", err)
+		}
+		for _, c := range result.Contents {
+			objects[c.Key] = strings.Trim(c.ETag, `"`)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+	return s.Region
+}
+
+// newRequest returns a request for key in the bucket, or for the bucket
+// itself when key is empty.
+func (s *S3) newRequest(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Request, error) {
+	var base, p string
+	if s.Endpoint != "" {
+		base = strings.TrimSuffix(s.Endpoint, "/")
+		p = "/" + s.Bucket + "/" + key
+	} else {
+		base = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.Bucket, s.region())
+		p = "/" + key
+	}
+	u := base + uriEncode(p, false)
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	return http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+}
+
+func (s *S3) do(req *http.Request, body []byte) ([]byte, error) {
+	data, _, err := s.send(req, body)
+	return data, err
+}
+
+// head sends a HEAD request and returns the response headers.
+func (s *S3) head(req *http.Request) (http.Header, error) {
+	_, header, err := s.send(req, nil)
+	return header, err
+}
+
+// send signs and sends req, returning the response body and headers.
+func (s *S3) send(req *http.Request, body []byte) ([]byte, http.Header, error) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	s.sign(req, body, now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct{ Code, Message string }
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return nil, nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, e.Code, e.Message)
+		}
+		return nil, nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return data, resp.Header, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req, covering the host and
+// every header already set on it.
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		var trimmed []string
+		for _, v := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := amzDate[:8] + "/" + s.region() + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], s.region(), "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes s as SigV4 requires: everything but unreserved
+// characters, and slashes too when encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
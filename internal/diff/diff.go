@@ -0,0 +1,349 @@
+// Package diff computes and formats differences between versions of a plan.
+package diff
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Op is the kind of an edit.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Edit is a single token of an edit script.
+type Edit struct {
+	Op   Op
+	Text string
+}
+
+// Diff returns the shortest edit script turning a into b, using Myers'
+// O(ND) algorithm in its linear space form: the middle of an optimal path is
+// found by searching from both ends at once, and each half is diffed in turn.
+func Diff(a, b []string) []Edit {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+	d := &differ{a: a, b: b}
+	d.diff(0, len(a), 0, len(b))
+	return d.edits
+}
+
+type differ struct {
+	a, b   []string
+	edits  []Edit
+	vf, vb []int // the furthest reaching paths, reused between calls
+}
+
+// diff appends the edits turning a[a0:a1] into b[b0:b1].
+func (d *differ) diff(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.edits = append(d.edits, Edit{Equal, d.a[a0]})
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a1-suffix > a0 && b1-suffix > b0 && d.a[a1-suffix-1] == d.b[b1-suffix-1] {
+		suffix++
+	}
+	a1, b1 = a1-suffix, b1-suffix
+
+	switch {
+	case a0 == a1:
+		for _, s := range d.b[b0:b1] {
+			d.edits = append(d.edits, Edit{Insert, s})
+		}
+	case b0 == b1:
+		for _, s := range d.a[a0:a1] {
+			d.edits = append(d.edits, Edit{Delete, s})
+		}
+	default:
+		x, y := d.middle(a0, a1, b0, b1)
+		d.diff(a0, x, b0, y)
+		d.diff(x, a1, y, b1)
+	}
+
+	for _, s := range d.a[a1 : a1+suffix] {
+		d.edits = append(d.edits, Edit{Equal, s})
+	}
+}
+
+// middle returns a point on an optimal path from (a0, b0) to (a1, b1), other
+// than either end. The two sequences differ at both ends, so the path has at
+// least two edits, and there is one. It is found where a path searched
+// forward from the start meets one searched back from the end.
+func (d *differ) middle(a0, a1, b0, b1 int) (x, y int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	limit := (n + m + 1) / 2
+	// Paths are indexed by their diagonal k = x - y, from -limit-1 on. The
+	// paths back from the end count x and y from the end.
+	offset := limit + 1
+	size := 2*limit + 3
+	if cap(d.vf) < size {
+		d.vf, d.vb = make([]int, size), make([]int, size)
+	}
+	vf, vb := d.vf[:size], d.vb[:size]
+	vf[offset+1], vb[offset+1] = 0, 0
+
+	for e := 0; e <= limit; e++ {
+		for k := -e; k <= e; k += 2 {
+			if k == -e || (k != e && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y = x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[a0+x] == d.b[b0+y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+			// The path back on this diagonal took e-1 edits; if they
+			// overlap, the start of this snake is on an optimal path.
+			if delta%2 != 0 && k >= delta-(e-1) && k <= delta+(e-1) && x+vb[offset+delta-k] >= n {
+				return a0 + sx, b0 + sy
+			}
+		}
+		for k := -e; k <= e; k += 2 {
+			if k == -e || (k != e && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+			y = x - k
+			for x < n && y < m && d.a[a1-1-x] == d.b[b1-1-y] {
+				x++
+				y++
+			}
+			vb[offset+k] = x
+			// Both paths took e edits; if they overlap, where this one
+			// ends is on an optimal path.
+			if delta%2 == 0 && delta-k >= -e && delta-k <= e && x+vf[offset+delta-k] >= n {
+				return a1 - x, b1 - y
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
+
+// Lines splits s into lines without their trailing newlines.
+func Lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+var wordRe = regexp.MustCompile(`\s+|[^\s]+`)
+
+// Words splits s into alternating runs of whitespace and non-whitespace, so
+// that joining the result reproduces s exactly.
+func Words(s string) []string {
+	return wordRe.FindAllString(s, -1)
+}
+
+// Hunk is a group of nearby edits together with their surrounding context.
+// Line numbers are 1-based, as in unified diff headers.
+type Hunk struct {
+	FromLine, FromCount int
+	ToLine, ToCount     int
+	Edits               []Edit
+}
+
+// Hunks groups a line edit script into hunks with up to context lines of
+// unchanged text around each change.
+func Hunks(edits []Edit, context int) []Hunk {
+	// Line numbers of both sides before each edit is applied.
+	from := make([]int, len(edits))
+	to := make([]int, len(edits))
+	fromLine, toLine := 1, 1
+	var changes []int
+	for i, e := range edits {
+		from[i], to[i] = fromLine, toLine
+		if e.Op != Insert {
+			fromLine++
+		}
+		if e.Op != Delete {
+			toLine++
+		}
+		if e.Op != Equal {
+			changes = append(changes, i)
+		}
+	}
+
+	var hunks []Hunk
+	for i := 0; i < len(changes); {
+		start := max(changes[i]-context, 0)
+		last := changes[i]
+		for i++; i < len(changes) && changes[i]-last-1 <= 2*context; i++ {
+			last = changes[i]
+		}
+		end := min(last+1+context, len(edits))
+
+		h := Hunk{FromLine: from[start], ToLine: to[start], Edits: edits[start:end]}
+		for _, e := range h.Edits {
+			if e.Op != Insert {
+				h.FromCount++
+			}
+			if e.Op != Delete {
+				h.ToCount++
+			}
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+// Options control diff output.
+type Options struct {
+	FromName string
+	ToName   string
+	Context  int
+	Color    bool
+}
+
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+func paint(on bool, color, s string) string {
+	if !on || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// WriteUnified writes a unified diff of a and b to w. Nothing is written when
+// the inputs are identical.
+func WriteUnified(w io.Writer, a, b string, opts Options) error {
+	hunks := Hunks(Diff(Lines(a), Lines(b)), opts.Context)
+	if len(hunks) == 0 {
+		return nil
+	}
+	writeHeader(w, opts)
+	for _, h := range hunks {
+		writeHunkHeader(w, h, opts)
+		for _, e := range h.Edits {
+			var line string
+			switch e.Op {
+			case Equal:
+				line = " " + e.Text
+			case Delete:
+				line = paint(opts.Color, colorRed, "-"+e.Text)
+			case Insert:
+				line = paint(opts.Color, colorGreen, "+"+e.Text)
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteWords writes a word diff of a and b to w. Hunks are found line by line
+// as for a unified diff, then runs of changed lines are compared word by word
+// and shown inline as [-removed-]{+added+}, or in color.
+func WriteWords(w io.Writer, a, b string, opts Options) error {
+	hunks := Hunks(Diff(Lines(a), Lines(b)), opts.Context)
+	if len(hunks) == 0 {
+		return nil
+	}
+	writeHeader(w, opts)
+	for _, h := range hunks {
+		writeHunkHeader(w, h, opts)
+		edits := h.Edits
+		for i := 0; i < len(edits); {
+			if edits[i].Op == Equal {
+				fmt.Fprintln(w, edits[i].Text)
+				i++
+				continue
+			}
+			var del, ins []string
+			for ; i < len(edits) && edits[i].Op != Equal; i++ {
+				if edits[i].Op == Delete {
+					del = append(del, edits[i].Text)
+				} else {
+					ins = append(ins, edits[i].Text)
+				}
+			}
+			var sb strings.Builder
+			for _, e := range coalesce(Diff(Words(strings.Join(del, "\n")), Words(strings.Join(ins, "\n")))) {
+				switch e.Op {
+				case Equal:
+					sb.WriteString(e.Text)
+				case Delete:
+					if opts.Color {
+						sb.WriteString(paint(true, colorRed, e.Text))
+					} else {
+						sb.WriteString("[-" + e.Text + "-]")
+					}
+				case Insert:
+					if opts.Color {
+						sb.WriteString(paint(true, colorGreen, e.Text))
+					} else {
+						sb.WriteString("{+" + e.Text + "+}")
+					}
+				}
+			}
+			if _, err := fmt.Fprintln(w, sb.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// coalesce merges adjacent edits of the same kind. Whitespace between two
+// changes of the same kind is folded into them so that runs of changed words
+// are shown as a single change.
+func coalesce(edits []Edit) []Edit {
+	var out []Edit
+	for i := 0; i < len(edits); i++ {
+		e := edits[i]
+		if n := len(out); n > 0 && e.Op == Equal && strings.TrimSpace(e.Text) == "" &&
+			out[n-1].Op != Equal && i+1 < len(edits) && edits[i+1].Op == out[n-1].Op {
+			out[n-1].Text += e.Text
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].Op == e.Op {
+			out[n-1].Text += e.Text
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func writeHeader(w io.Writer, opts Options) {
+	fmt.Fprintln(w, paint(opts.Color, colorBold, "--- "+opts.FromName))
+	fmt.Fprintln(w, paint(opts.Color, colorBold, "+++ "+opts.ToName))
+}
+
+func writeHunkHeader(w io.Writer, h Hunk, opts Options) {
+	header := fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.FromLine, h.FromCount), hunkRange(h.ToLine, h.ToCount))
+	fmt.Fprintln(w, paint(opts.Color, colorCyan, header))
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		// An empty range names the line before it, as diff(1) does.
+		return fmt.Sprintf("%d,0", line-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
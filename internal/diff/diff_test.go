@@ -0,0 +1,138 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := []string{"a", "b", "c", "d"}
+	b := []string{"a", "c", "d", "e"}
+	edits := Diff(a, b)
+
+	var got []string
+	for _, e := range edits {
+		got = append(got, []string{" ", "-", "+"}[e.Op]+e.Text)
+	}
+	want := " a -b  c  d +e"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Diff = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(cur[j], prev[j+1])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestDiff_Shortest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := func() []string {
+		s := make([]string, r.Intn(12))
+		for i := range s {
+			s[i] = string(rune('a' + r.Intn(4)))
+		}
+		return s
+	}
+	for range 2000 {
+		a, b := words(), words()
+		var from, to []string
+		changes := 0
+		for _, e := range Diff(a, b) {
+			if e.Op != Insert {
+				from = append(from, e.Text)
+			}
+			if e.Op != Delete {
+				to = append(to, e.Text)
+			}
+			if e.Op != Equal {
+				changes++
+			}
+		}
+		if strings.Join(from, "") != strings.Join(a, "") || strings.Join(to, "") != strings.Join(b, "") {
+			t.Fatalf("Diff(%q, %q) does not turn one into the other", a, b)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+			t.Fatalf("Diff(%q, %q) has %d changes, want %d", a, b, changes, want)
+		}
+	}
+}
+
+func TestDiff_Unrelated(t *testing.T) {
+	// Two unrelated versions of a long plan are as far apart as can be.
+	var a, b []string
+	for i := range 5000 {
+		a = append(a, fmt.Sprintf("old line %d", i))
+		b = append(b, fmt.Sprintf("new line %d", i))
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	edits := Diff(a, b)
+	runtime.ReadMemStats(&after)
+	if len(edits) != len(a)+len(b) {
+		t.Errorf("got %d edits, want %d", len(edits), len(a)+len(b))
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Errorf("Diff allocated %d bytes, want memory linear in the input", alloc)
+	}
+}
+
+func TestWriteUnified(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	b := "one\n2\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\n"
+
+	var buf bytes.Buffer
+	if err := WriteUnified(&buf, a, b, Options{FromName: "a", ToName: "b", Context: 1}); err != nil {
+		t.Fatalf("WriteUnified failed: %v", err)
+	}
+	want := `--- a
++++ b
+@@ -1,3 +1,3 @@
+ one
+-two
++2
+ three
+@@ -10 +10,2 @@
+ ten
++eleven
+`
+	if buf.String() != want {
+		t.Errorf("Unexpected diff:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteUnified_Identical(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteUnified(&buf, "same\n", "same\n", Options{Context: 3}); err != nil {
+		t.Fatalf("WriteUnified failed: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output for identical inputs, got %q", buf.String())
+	}
+}
+
+func TestWriteWords(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteWords(&buf, "the quick brown fox\n", "the slow brown fox\n", Options{FromName: "a", ToName: "b", Context: 3})
+	if err != nil {
+		t.Fatalf("WriteWords failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "the [-quick-]{+slow+} brown fox\n") {
+		t.Errorf("Unexpected word diff:\n%s", buf.String())
+	}
+}
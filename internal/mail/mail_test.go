@@ -0,0 +1,181 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	m := &Message{
+		From:    "Alice <alice@example.com>",
+		To:      []string{"bob@example.com"},
+		Subject: "Plan: 2025-01-02 ✓",
+		Date:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Text:    "Hello, plain world.\n",
+		HTML:    "<p style=\"margin:0;\">Hello, <b>HTML</b> world.</p>\n",
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != m.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, m.Subject)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v)", mediaType, err)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var parts []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p) // quoted-printable is decoded by NextPart
+		body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+		parts = append(parts, p.Header.Get("Content-Type")+"\n"+string(body))
+	}
+	if len(parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %d", len(parts))
+	}
+	if !strings.HasPrefix(parts[0], "text/plain") || !strings.Contains(parts[0], m.Text) {
+		t.Errorf("Unexpected text part: %q", parts[0])
+	}
+	if !strings.HasPrefix(parts[1], "text/html") || !strings.Contains(parts[1], m.HTML) {
+		t.Errorf("Unexpected HTML part: %q", parts[1])
+	}
+}
+
+// fakeSMTP accepts a single session and records the envelope and data.
+type fakeSMTP struct {
+	ln   net.Listener
+	auth string
+	from string
+	to   []string
+	data string
+	done chan struct{}
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSMTP{ln: ln, done: make(chan struct{})}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeSMTP) serve() {
+	defer close(f.done)
+	conn, err := f.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			raw, _ := base64.StdEncoding.DecodeString(strings.Fields(line)[2])
+			f.auth = string(raw)
+			reply("235 Authenticated")
+		case "MAIL":
+			f.from = line
+			reply("250 OK")
+		case "RCPT":
+			f.to = append(f.to, line)
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var sb strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				sb.WriteString(l)
+			}
+			f.data = sb.String()
+			reply("250 Queued")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Unknown command")
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	f := newFakeSMTP(t)
+	port := f.ln.Addr().(*net.TCPAddr).Port
+
+	m := &Message{From: "Alice <alice@example.com>", To: []string{"bob@example.com", "Carol <carol@example.com>"}, Subject: "Hi", Text: "t", HTML: "h"}
+	data, err := m.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Host: "127.0.0.1", Port: port, Username: "alice", Password: "secret"}
+	if err := s.Send(m.From, m.To, data); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	<-f.done
+
+	if f.auth != "\x00alice\x00secret" {
+		t.Errorf("Unexpected AUTH PLAIN credentials: %q", f.auth)
+	}
+	if f.from != "MAIL FROM:<alice@example.com>" {
+		t.Errorf("Unexpected MAIL FROM: %q", f.from)
+	}
+	if len(f.to) != 2 || f.to[1] != "RCPT TO:<carol@example.com>" {
+		t.Errorf("Unexpected recipients: %q", f.to)
+	}
+	if !strings.Contains(f.data, "Subject: Hi") {
+		t.Errorf("Message not delivered: %q", f.data)
+	}
+}
+
+func TestSend_RequiresStartTLS(t *testing.T) {
+	f := newFakeSMTP(t)
+	port := f.ln.Addr().(*net.TCPAddr).Port
+
+	s := &Server{Host: "127.0.0.1", Port: port, StartTLS: true}
+	if err := s.Send("a@example.com", []string{"b@example.com"}, []byte("x")); err == nil {
+		t.Error("Expected an error when STARTTLS is required but unsupported")
+	}
+}
@@ -0,0 +1,69 @@
+// Package publicnet keeps the requests a server makes on behalf of others,
+// such as fetching a URL named in an incoming request, off its own network.
+package publicnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNotPublic is returned for a host that is not on the public internet.
+var ErrNotPublic = errors.New("address is not public")
+
+// Client returns a copy of c, or of http.DefaultClient if c is nil, that
+// refuses to connect to addresses that are not public, checked after the
+// name is resolved and on every redirect.
+func Client(c *http.Client) *http.Client {
+	hc := http.Client{}
+	if c != nil {
+		hc = *c
+	}
+	var transport *http.Transport
+	if t, ok := hc.Transport.(*http.Transport); ok {
+		transport = t.Clone()
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+				return fmt.Errorf("%w: %s", ErrNotPublic, host)
+			}
+			return nil
+		},
+	}
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	hc.Transport = transport
+	return &hc
+}
+
+// CheckURL returns ErrNotPublic if u plainly names a host that is not
+// public: localhost, or an address that is not. Other names are only known
+// once resolved, and are checked by Client.
+func CheckURL(u *url.URL) error {
+	if host := strings.TrimSuffix(strings.ToLower(u.Hostname()), "."); host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrNotPublic
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !IsPublic(ip) {
+		return ErrNotPublic
+	}
+	return nil
+}
+
+// IsPublic reports whether ip is a globally routable address.
+func IsPublic(ip net.IP) bool {
+	return !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsMulticast()
+}
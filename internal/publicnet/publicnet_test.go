@@ -0,0 +1,58 @@
+package publicnet
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34": true,
+		"2606:4700::1":  true,
+		"127.0.0.1":     false,
+		"10.1.2.3":      false,
+		"192.168.0.1":   false,
+		"169.254.1.1":   false,
+		"0.0.0.0":       false,
+		"::1":           false,
+		"fe80::1":       false,
+		"fd00::1":       false,
+	} {
+		if got := IsPublic(net.ParseIP(addr)); got != want {
+			t.Errorf("IsPublic(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for raw, want := range map[string]bool{
+		"https://example.com/":      true,
+		"http://localhost:8080/":    false,
+		"http://app.localhost./":    false,
+		"http://127.0.0.1/":         false,
+		"http://[::1]:80/":          false,
+		"http://169.254.169.254/x":  false,
+		"https://93.184.216.34/":    true,
+		"https://internal.example/": true, // only known once resolved
+	} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckURL(u); (err == nil) != want {
+			t.Errorf("CheckURL(%s) = %v, want public %v", raw, err, want)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	if _, err := Client(nil).Get(srv.URL); !errors.Is(err, ErrNotPublic) {
+		t.Errorf("Get(%s) error = %v, want ErrNotPublic", srv.URL, err)
+	}
+}
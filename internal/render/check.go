@@ -0,0 +1,230 @@
+package render
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dewitt/a-simple-plan/internal/suggest"
+)
+
+// Severity is how serious a template problem is. Errors break the output;
+// warnings are worth fixing.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// TemplateProblem is a problem found in a template by CheckTemplate.
+type TemplateProblem struct {
+	Line     int // 0 when the problem is not at a particular line
+	Severity Severity
+	Message  string
+}
+
+func (p TemplateProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%d: %s: %s", p.Line, p.Severity, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Severity, p.Message)
+}
+
+// Placeholders lists the {{name}} markers Compose replaces.
+var Placeholders = []string{"content", "username", "fullname", "directory", "shell", "title", "onSince", "modTimeUnix"}
+
+var (
+	placeholderRe = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+	commentRe     = regexp.MustCompile(`(?s)<!--.*?-->`)
+	rawTextRe     = regexp.MustCompile(`(?is)<(script|style)\b[^>]*>(.*?)</(script|style)\s*>`)
+	htmlTagRe     = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)((?:[^>"']|"[^"]*"|'[^']*')*?)(/?)>`)
+	attrValueRe   = regexp.MustCompile(`(?i)\b(src|href|rel|async|defer)\b(?:\s*=\s*("[^"]*"|'[^']*'|[^\s>]+))?`)
+	cssURLRe      = regexp.MustCompile(`(?i)(?:@import\s+|url\()\s*["']?((?:https?:)?//[^"')\s]+)`)
+)
+
+// Elements that never have a closing tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// Elements whose closing tag may be left out.
+var optionalClose = map[string]bool{
+	"p": true, "li": true, "dt": true, "dd": true, "tr": true, "td": true, "th": true, "option": true,
+	"thead": true, "tbody": true, "tfoot": true, "colgroup": true,
+}
+
+// CheckTemplate reports problems in a page template: a missing or repeated
+// {{content}} marker, unknown placeholders, malformed HTML, and external
+// resources that slow down every page.
+func CheckTemplate(tmpl string) []TemplateProblem {
+	var problems []TemplateProblem
+	add := func(offset int, sev Severity, format string, args ...any) {
+		line := 0
+		if offset >= 0 {
+			line = strings.Count(tmpl[:offset], "\n") + 1
+		}
+		problems = append(problems, TemplateProblem{Line: line, Severity: sev, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Placeholders
+	contentCount := 0
+	for _, m := range placeholderRe.FindAllStringSubmatchIndex(tmpl, -1) {
+		raw := tmpl[m[2]:m[3]]
+		name := strings.TrimSpace(raw)
+		known := false
+		for _, p := range Placeholders {
+			if p == name {
+				known = true
+			}
+		}
+		switch {
+		case known && raw != name:
+			add(m[0], SeverityError, "placeholder {{%s}} must be written without spaces, as {{%s}}", raw, name)
+		case !known:
+			msg := fmt.Sprintf("unknown placeholder {{%s}} will appear literally", raw)
+			if s := suggest.Closest(name, Placeholders); s != "" {
+				msg += fmt.Sprintf(" (did you mean {{%s}}?)", s)
+			}
+			add(m[0], SeverityError, "%s", msg)
+		}
+		if name == "content" {
+			contentCount++
+		}
+	}
+	switch {
+	case contentCount == 0:
+		add(-1, SeverityError, "missing the {{content}} marker where the plan is inserted")
+	case contentCount > 1:
+		add(-1, SeverityError, "{{content}} appears %d times; it must appear exactly once", contentCount)
+	}
+
+	// Blank out comments and the bodies of scripts and styles, keeping line
+	// numbers, so they are not mistaken for markup.
+	markup := commentRe.ReplaceAllStringFunc(tmpl, blankOut)
+	markup = rawTextRe.ReplaceAllStringFunc(markup, func(s string) string {
+		m := rawTextRe.FindStringSubmatchIndex(s)
+		return s[:m[4]] + blankOut(s[m[4]:m[5]]) + s[m[5]:]
+	})
+
+	// Tag nesting
+	var stack []openTag
+	seen := make(map[string]bool)
+	for _, m := range htmlTagRe.FindAllStringSubmatchIndex(markup, -1) {
+		closing := m[3] > m[2]
+		name := strings.ToLower(markup[m[4]:m[5]])
+		selfClosing := m[9] > m[8]
+		seen[name] = true
+		if voidElements[name] || selfClosing {
+			if closing && voidElements[name] {
+				add(m[0], SeverityWarning, "<%s> is a void element and has no closing tag", name)
+			}
+			continue
+		}
+		if !closing {
+			stack = append(stack, openTag{name, m[0]})
+			continue
+		}
+
+		i := len(stack) - 1
+		for i >= 0 && stack[i].name != name && optionalClose[stack[i].name] {
+			i--
+		}
+		switch {
+		case i >= 0 && stack[i].name == name:
+			stack = stack[:i]
+		case isOpen(stack, name):
+			for ; stack[len(stack)-1].name != name; stack = stack[:len(stack)-1] {
+				if top := stack[len(stack)-1]; !optionalClose[top.name] {
+					add(m[0], SeverityError, "<%s> opened on line %d is not closed before </%s>", top.name, strings.Count(tmpl[:top.offset], "\n")+1, name)
+				}
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			add(m[0], SeverityError, "</%s> has no matching opening tag", name)
+		}
+	}
+	for _, t := range stack {
+		if !optionalClose[t.name] {
+			add(t.offset, SeverityError, "<%s> is never closed", t.name)
+		}
+	}
+	if !strings.Contains(tmpl, "</body>") {
+		add(-1, SeverityError, "missing </body>; the live reload script cannot be injected in preview")
+	}
+	if !strings.Contains(tmpl, "</head>") {
+		add(-1, SeverityWarning, "missing </head>; the Webmention endpoint cannot be advertised")
+	}
+	if !seen["title"] {
+		add(-1, SeverityWarning, "missing <title>")
+	}
+
+	// External resources
+	headEnd := strings.Index(markup, "</head>")
+	for _, m := range htmlTagRe.FindAllStringSubmatchIndex(markup, -1) {
+		if m[3] > m[2] {
+			continue
+		}
+		name := strings.ToLower(markup[m[4]:m[5]])
+		attrs := make(map[string]string)
+		for _, a := range attrValueRe.FindAllStringSubmatch(markup[m[6]:m[7]], -1) {
+			attrs[strings.ToLower(a[1])] = strings.Trim(a[2], `"'`)
+		}
+		var url string
+		switch name {
+		case "script", "img", "iframe", "video", "audio", "embed":
+			url = attrs["src"]
+		case "link":
+			if rel := strings.ToLower(attrs["rel"]); rel == "stylesheet" || rel == "preload" || strings.Contains(rel, "icon") {
+				url = attrs["href"]
+			}
+		}
+		if !isExternal(url) {
+			continue
+		}
+		_, async := attrs["async"]
+		_, deferred := attrs["defer"]
+		if name == "script" && !async && !deferred && (headEnd < 0 || m[0] < headEnd) {
+			add(m[0], SeverityWarning, "external script %s blocks rendering; add async or defer, or serve it from assets/", url)
+			continue
+		}
+		add(m[0], SeverityWarning, "external resource %s adds a request to another host on every page", url)
+	}
+	for _, m := range cssURLRe.FindAllStringSubmatchIndex(tmpl, -1) {
+		add(m[0], SeverityWarning, "external resource %s in CSS adds a request to another host on every page", tmpl[m[2]:m[3]])
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems
+}
+
+// openTag is an element whose closing tag has not been seen yet.
+type openTag struct {
+	name   string
+	offset int
+}
+
+func isOpen(stack []openTag, name string) bool {
+	for _, t := range stack {
+		if t.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// blankOut replaces everything but newlines in s with spaces.
+func blankOut(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' {
+			return r
+		}
+		return ' '
+	}, s)
+}
+
+func isExternal(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "//")
+}
@@ -0,0 +1,109 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	stdhtml "html"
+	"regexp"
+	"time"
+)
+
+// Email clients ignore <style> blocks, so every element carries its own
+// styles. The palette follows the light scheme of the default template.
+var emailStyles = map[string]string{
+	"h1":         "font-size:14px;font-weight:bold;text-transform:uppercase;margin:18px 0 6px 0;",
+	"h2":         "font-size:14px;font-weight:bold;text-transform:uppercase;margin:18px 0 6px 0;",
+	"h3":         "font-size:14px;font-weight:bold;text-transform:uppercase;margin:18px 0 6px 0;",
+	"h4":         "font-size:14px;font-weight:bold;text-transform:uppercase;margin:18px 0 6px 0;",
+	"h5":         "font-size:14px;font-weight:bold;text-transform:uppercase;margin:18px 0 6px 0;",
+	"h6":         "font-size:14px;font-weight:bold;text-transform:uppercase;margin:18px 0 6px 0;",
+	"p":          "margin:0 0 12px 0;",
+	"a":          "color:#0000ee;text-decoration:underline;",
+	"pre":        "font-family:'Courier New',Courier,monospace;font-size:14px;margin:12px 0;padding:0 0 0 2ch;white-space:pre-wrap;",
+	"code":       "font-family:'Courier New',Courier,monospace;",
+	"blockquote": "margin:12px 0;padding-left:2ch;",
+	"ul":         "margin:12px 0;padding-left:2ch;",
+	"ol":         "margin:12px 0;padding-left:2ch;",
+	"img":        "max-width:100%;height:auto;border:0;",
+	"table":      "border-collapse:collapse;",
+	"th":         "padding:2px 8px;text-align:left;",
+	"td":         "padding:2px 8px;",
+	"del":        "background-color:#ffdddd;",
+	"ins":        "background-color:#ddffdd;text-decoration:none;",
+}
+
+// chroma token classes, mapped to the template's syntax colors.
+var emailCodeStyles = tokenStyles(map[string][]string{
+	"color:#0000aa;font-weight:bold;":  {"k", "kd", "kn", "kp", "kr"},
+	"color:#006666;":                   {"kt", "nc", "no", "nd"},
+	"color:#008800;":                   {"s", "s1", "s2", "sa", "sb", "sc", "sd", "se", "sh", "si", "sr", "ss", "sx", "dl"},
+	"color:#666666;font-style:italic;": {"c", "c1", "cm", "ch", "cs", "cp", "cpf"},
+	"color:#aa0000;":                   {"m", "mb", "mf", "mh", "mi", "il", "mo"},
+})
+
+func tokenStyles(groups map[string][]string) map[string]string {
+	styles := make(map[string]string)
+	for style, classes := range groups {
+		for _, c := range classes {
+			styles[c] = style
+		}
+	}
+	return styles
+}
+
+var (
+	startTagRe  = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9]*)(\s[^>]*)?>`)
+	styleAttrRe = regexp.MustCompile(`\sstyle="([^"]*)"`)
+	classAttrRe = regexp.MustCompile(`\sclass="([^"]*)"`)
+)
+
+// InlineStyles adds email-safe inline styles to the elements of an HTML
+// fragment, including the token spans of highlighted code.
+func InlineStyles(fragment []byte) []byte {
+	return startTagRe.ReplaceAllFunc(fragment, func(tag []byte) []byte {
+		m := startTagRe.FindSubmatch(tag)
+		name, attrs := string(bytes.ToLower(m[1])), m[2]
+
+		style := emailStyles[name]
+		if name == "span" {
+			if c := classAttrRe.FindSubmatch(attrs); c != nil {
+				style = emailCodeStyles[string(c[1])]
+			}
+		}
+		if style == "" {
+			return tag
+		}
+
+		// Styles already on the element take precedence.
+		if s := styleAttrRe.FindSubmatch(attrs); s != nil {
+			attrs = styleAttrRe.ReplaceAll(attrs, []byte(fmt.Sprintf(` style="%s%s"`, style, s[1])))
+		} else {
+			attrs = append([]byte(fmt.Sprintf(` style="%s"`, style)), attrs...)
+		}
+		return []byte("<" + name + string(attrs) + ">")
+	})
+}
+
+// ComposeEmail wraps a rendered body in a minimal, inline-styled HTML
+// document suitable for email, with a finger-style header line instead of the
+// site template.
+func (r *Renderer) ComposeEmail(bodyHTML []byte, subject string, updated time.Time) []byte {
+	var header string
+	if r.config != nil {
+		header = fmt.Sprintf("Login: %s &nbsp; Name: %s<br>Updated %s",
+			stdhtml.EscapeString(r.config.Username), stdhtml.EscapeString(r.config.FullName),
+			updated.In(r.loc).Format("Mon Jan _2 15:04 (MST)"))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"UTF-8\">\n")
+	fmt.Fprintf(&buf, "<title>%s</title>\n</head>\n", stdhtml.EscapeString(subject))
+	buf.WriteString(`<body style="margin:0;padding:16px;background-color:#ffffff;color:#000000;font-family:'Courier New',Courier,monospace;font-size:14px;line-height:1.3;">` + "\n")
+	buf.WriteString(`<div style="max-width:80ch;">` + "\n")
+	if header != "" {
+		fmt.Fprintf(&buf, `<div style="margin-bottom:16px;padding-bottom:8px;border-bottom:1px dashed #555555;">%s</div>`+"\n", header)
+	}
+	buf.Write(InlineStyles(bodyHTML))
+	buf.WriteString("\n</div>\n</body>\n</html>\n")
+	return buf.Bytes()
+}
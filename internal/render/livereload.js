@@ -0,0 +1,165 @@
+(function() {
+	// Preview pushes every rebuilt page over /events. The plan's content,
+	// between the plan:content comments, is patched in place so the reader
+	// keeps their place; a "reload" message, sent when the template or
+	// settings change, reloads the whole page instead. Every message carries
+	// the errors of the latest build, shown over the page until it succeeds.
+	var es = new EventSource('/events');
+	es.onmessage = function(e) {
+		var msg = JSON.parse(e.data);
+		showErrors(msg.errors || []);
+		if (msg.type === 'reload') {
+			location.reload();
+			return;
+		}
+		if (msg.type !== 'morph' || !isCurrentPage(msg.path)) {
+			return;
+		}
+		var doc = new DOMParser().parseFromString(msg.html, 'text/html');
+		var from = contentRange(document);
+		var to = contentRange(doc);
+		if (!from || !to) {
+			location.reload();
+			return;
+		}
+		var changed = [];
+		morphNodes(from[0].parentNode, from[0].nextSibling, from[1], to[0].nextSibling, to[1], changed);
+		reveal(changed[0]);
+	};
+
+	function showErrors(errors) {
+		var overlay = document.getElementById('plan-build-errors');
+		if (!errors.length) {
+			if (overlay) {
+				overlay.remove();
+			}
+			return;
+		}
+		if (!overlay) {
+			overlay = document.createElement('pre');
+			overlay.id = 'plan-build-errors';
+			overlay.style.cssText = 'position: fixed; top: 0; left: 0; right: 0; z-index: 2147483647; margin: 0; ' +
+				'padding: 1em 1.5em; max-height: 50vh; overflow: auto; white-space: pre-wrap; ' +
+				'font: 14px/1.5 monospace; color: #fdd; background: rgba(60, 0, 0, 0.95); border-bottom: 3px solid #e55;';
+			document.body.appendChild(overlay);
+		}
+		overlay.textContent = 'Build failed; showing the last good version.\n\n' + errors.map(function(err) {
+			var where = err.file || '';
+			if (where && err.line) {
+				where += ':' + err.line;
+			}
+			return (where ? where + ': ' : '') + err.message;
+		}).join('\n');
+	}
+
+	function isCurrentPage(path) {
+		var here = location.pathname;
+		if (here.endsWith('/')) {
+			here += 'index.html';
+		}
+		return here === path;
+	}
+
+	// contentRange returns the comments around the plan's content in doc.
+	function contentRange(doc) {
+		var it = doc.createNodeIterator(doc.body, NodeFilter.SHOW_COMMENT);
+		var start, end, n;
+		while ((n = it.nextNode())) {
+			if (n.nodeValue === 'plan:content') {
+				start = n;
+			} else if (n.nodeValue === '/plan:content') {
+				end = n;
+			}
+		}
+		if (!start || !end || start.parentNode !== end.parentNode) {
+			return null;
+		}
+		return [start, end];
+	}
+
+	// morphNodes makes the children of parent from a up to aEnd match the
+	// nodes from b up to bEnd, which are moved over as needed. Nodes that
+	// change are added to changed, in document order.
+	function morphNodes(parent, a, aEnd, b, bEnd, changed) {
+		while (b !== bEnd) {
+			var next = b.nextSibling;
+			if (a !== aEnd && a.nextSibling !== aEnd && !a.isEqualNode(b) && a.nextSibling.isEqualNode(b)) {
+				// a was removed.
+				var gone = a;
+				a = a.nextSibling;
+				parent.removeChild(gone);
+				changed.push(a);
+				continue;
+			}
+			if (a === aEnd) {
+				parent.insertBefore(b, aEnd);
+				changed.push(b);
+			} else if (next !== bEnd && !a.isEqualNode(b) && a.isEqualNode(next)) {
+				// b was inserted.
+				parent.insertBefore(b, a);
+				changed.push(b);
+			} else if (a.nodeType === b.nodeType && a.nodeName === b.nodeName) {
+				morph(a, b, changed);
+				a = a.nextSibling;
+			} else {
+				var old = a;
+				a = a.nextSibling;
+				parent.replaceChild(b, old);
+				changed.push(b);
+			}
+			b = next;
+		}
+		if (a !== aEnd) {
+			while (a !== aEnd) {
+				var rest = a;
+				a = a.nextSibling;
+				parent.removeChild(rest);
+			}
+			changed.push((aEnd ? aEnd.previousSibling : parent.lastChild) || parent);
+		}
+	}
+
+	function morph(a, b, changed) {
+		if (a.isEqualNode(b)) {
+			return;
+		}
+		if (a.nodeType !== Node.ELEMENT_NODE) {
+			a.nodeValue = b.nodeValue;
+			changed.push(a.parentNode);
+			return;
+		}
+		var attrsChanged = false;
+		for (var i = a.attributes.length - 1; i >= 0; i--) {
+			if (!b.hasAttribute(a.attributes[i].name)) {
+				a.removeAttribute(a.attributes[i].name);
+				attrsChanged = true;
+			}
+		}
+		for (var j = 0; j < b.attributes.length; j++) {
+			var attr = b.attributes[j];
+			if (a.getAttribute(attr.name) !== attr.value) {
+				a.setAttribute(attr.name, attr.value);
+				attrsChanged = true;
+			}
+		}
+		if (attrsChanged) {
+			changed.push(a);
+		}
+		morphNodes(a, a.firstChild, null, b.firstChild, null, changed);
+	}
+
+	// reveal scrolls the block containing node into view, unless it is
+	// already visible.
+	function reveal(node) {
+		while (node && node.nodeType !== Node.ELEMENT_NODE) {
+			node = node.previousSibling || node.parentNode;
+		}
+		if (!node || node === document.body) {
+			return;
+		}
+		var r = node.getBoundingClientRect();
+		if (r.bottom < 0 || r.top > window.innerHeight) {
+			node.scrollIntoView({block: 'center'});
+		}
+	}
+})();
@@ -0,0 +1,119 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Private blocks are parts of a plan left out of everything published from
+// it. A block is either between two comments or in a container, with each
+// marker on a line of its own:
+//
+//	<!-- private -->
+//	Notes to self.
+//	<!-- /private -->
+//
+//	:::private
+//	Notes to self.
+//	:::
+//
+// Markers inside fenced code blocks are left alone.
+var (
+	privateCommentOpen   = regexp.MustCompile(`(?i)^<!--\s*private\s*-->$`)
+	privateCommentClose  = regexp.MustCompile(`(?i)^<!--\s*/private\s*-->$`)
+	privateContainerOpen = regexp.MustCompile(`(?i)^:::\s*private$`)
+)
+
+// PrivateError is a private block that is never closed.
+type PrivateError struct {
+	Line int // where the block opens
+}
+
+func (e *PrivateError) Error() string {
+	return fmt.Sprintf("line %d: private block is never closed", e.Line)
+}
+
+// privatePart is a run of lines of a plan, without the markers around it.
+type privatePart struct {
+	text    []byte
+	private bool
+}
+
+// splitPrivate splits md into its public and private parts. A block left
+// open runs to the end of md, and is reported with a *PrivateError.
+func splitPrivate(md []byte) ([]privatePart, error) {
+	var parts []privatePart
+	var cur []byte
+	var closes func(string) bool
+	private, opened, fence := false, 0, ""
+	flush := func() {
+		if len(cur) > 0 {
+			parts = append(parts, privatePart{text: cur, private: private})
+		}
+		cur = nil
+	}
+	for i, line := range bytes.SplitAfter(md, []byte("\n")) {
+		s := strings.TrimSpace(string(line))
+		switch {
+		case fence != "":
+			if strings.HasPrefix(s, fence) {
+				fence = ""
+			}
+		case strings.HasPrefix(s, "```") || strings.HasPrefix(s, "~~~"):
+			fence = s[:3]
+		case !private && privateCommentOpen.MatchString(s):
+			flush()
+			private, opened, closes = true, i+1, privateCommentClose.MatchString
+			continue
+		case !private && privateContainerOpen.MatchString(s):
+			flush()
+			private, opened, closes = true, i+1, func(s string) bool { return s == ":::" }
+			continue
+		case private && closes(s):
+			flush()
+			private = false
+			continue
+		}
+		cur = append(cur, line...)
+	}
+	flush()
+	if private {
+		return parts, &PrivateError{Line: opened}
+	}
+	return parts, nil
+}
+
+// StripPrivate returns md without its private blocks. A block left open is
+// removed to the end of md, and reported with a *PrivateError.
+func StripPrivate(md []byte) ([]byte, error) {
+	parts, err := splitPrivate(md)
+	var out []byte
+	for _, p := range parts {
+		if !p.private {
+			out = append(out, p.text...)
+		}
+	}
+	return out, err
+}
+
+// highlightPrivate marks up the private blocks of md to stand out, for
+// preview.
+func highlightPrivate(md []byte) ([]byte, error) {
+	parts, err := splitPrivate(md)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	for _, p := range parts {
+		if !p.private {
+			out = append(out, p.text...)
+			continue
+		}
+		out = append(out, "\n<div class=\"plan-private\" title=\"Private: left out of the published site\" style=\"outline: 1px dashed #c90; background: rgba(204, 153, 0, 0.15)\">\n\n"...)
+		out = append(out, p.text...)
+		out = append(out, "\n\n</div>\n\n"...)
+	}
+	return out, nil
+}
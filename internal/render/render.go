@@ -4,6 +4,8 @@ import (
 	"bytes"
 	_ "embed"
 	"fmt"
+	stdhtml "html"
+	"slices"
 	"strings"
 	"time"
 
@@ -50,6 +52,15 @@ func (t *assetTransformer) Transform(node *ast.Document, reader text.Reader, pc
 //go:embed template.html
 var defaultTemplateHTML string
 
+//go:embed livereload.js
+var liveReloadJS string
+
+// Comments surrounding the plan's content in live reload pages.
+const (
+	contentStart = "<!--plan:content-->"
+	contentEnd   = "<!--/plan:content-->"
+)
+
 // Renderer handles the conversion of markdown to HTML with dynamic headers.
 type Renderer struct {
 	mdRenderer   goldmark.Markdown
@@ -58,6 +69,11 @@ type Renderer struct {
 	templateHTML string
 	liveReload   bool
 	AssetPrefix  string
+	// ShowPrivate highlights private blocks instead of leaving them out,
+	// for preview.
+	ShowPrivate bool
+	// SelfURL is the page's canonical URL, advertised with the WebSub hub.
+	SelfURL string
 }
 
 // New creates a new Renderer.
@@ -112,8 +128,19 @@ func New(cfg *config.Config, customTemplate string, liveReload bool, assetPrefix
 	}
 }
 
-// RenderBody converts markdown content to an HTML fragment.
+// RenderBody converts markdown content to an HTML fragment, without its
+// private blocks unless ShowPrivate is set. A private block left open is an
+// error.
 func (r *Renderer) RenderBody(md []byte) ([]byte, error) {
+	var err error
+	if r.ShowPrivate {
+		md, err = highlightPrivate(md)
+	} else {
+		md, err = StripPrivate(md)
+	}
+	if err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
 	if err := r.mdRenderer.Convert(md, &buf); err != nil {
 		return nil, fmt.Errorf("failed to convert markdown: %w", err)
@@ -140,19 +167,27 @@ func (r *Renderer) Compose(bodyHTML []byte, created, updated time.Time) ([]byte,
 		outputStr = strings.ReplaceAll(outputStr, "{{title}}", r.config.Title)
 	}
 
+	// Advertise the Webmention endpoint
+	if r.config != nil && r.config.Webmention != "" {
+		link := fmt.Sprintf(`<link rel="webmention" href="%s">`, stdhtml.EscapeString(r.config.Webmention))
+		outputStr = strings.Replace(outputStr, "</head>", link+"\n</head>", 1)
+	}
+
+	// Advertise the WebSub hub
+	if r.config != nil && r.config.WebSubHub != "" {
+		link := fmt.Sprintf(`<link rel="hub" href="%s">`, stdhtml.EscapeString(r.config.WebSubHub))
+		if r.SelfURL != "" {
+			link += fmt.Sprintf("\n"+`<link rel="self" href="%s">`, stdhtml.EscapeString(r.SelfURL))
+		}
+		outputStr = strings.Replace(outputStr, "</head>", link+"\n</head>", 1)
+	}
+
 	// Live Reload Injection
 	liveReloadScript := ""
 	if r.liveReload {
-		liveReloadScript = `<script>
-(function() {
-	var es = new EventSource('/events');
-	es.onmessage = function(e) {
-		if (e.data === 'reload') {
-			location.reload();
-		}
-	};
-})();
-</script>`
+		liveReloadScript = "<script>\n" + liveReloadJS + "</script>"
+		// Mark where the plan's content is, so live reload can patch it.
+		bodyHTML = slices.Concat([]byte(contentStart), bodyHTML, []byte(contentEnd))
 	}
 	
 	// If the template has a specific marker, use it (though not standard yet)
@@ -167,7 +202,7 @@ func (r *Renderer) Compose(bodyHTML []byte, created, updated time.Time) ([]byte,
 	// Inject Content
 	parts := strings.Split(outputStr, "{{content}}")
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid template: missing {{content}} marker")
+		return nil, contentMarkerError(r.templateHTML)
 	}
 
 	// Pre-allocate buffer
@@ -180,3 +215,64 @@ func (r *Renderer) Compose(bodyHTML []byte, created, updated time.Time) ([]byte,
 
 	return finalBuf.Bytes(), nil
 }
+
+// TemplateError is a problem with a page template that stops pages from
+// being composed.
+type TemplateError struct {
+	Line    int // 0 when the problem is not at a particular line
+	Message string
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("invalid template: line %d: %s", e.Line, e.Message)
+	}
+	return "invalid template: " + e.Message
+}
+
+// contentMarkerError explains why tmpl does not have exactly one {{content}}
+// marker.
+func contentMarkerError(tmpl string) *TemplateError {
+	const marker = "{{content}}"
+	first := strings.Index(tmpl, marker)
+	if first < 0 {
+		return &TemplateError{Message: "missing " + marker + " marker"}
+	}
+	rest := tmpl[first+len(marker):]
+	next := strings.Index(rest, marker)
+	if next < 0 {
+		return &TemplateError{Message: "a setting adds another " + marker + " to the page"}
+	}
+	second := len(tmpl) - len(rest) + next
+	return &TemplateError{
+		Line:    strings.Count(tmpl[:second], "\n") + 1,
+		Message: fmt.Sprintf("%s appears %d times; it must appear exactly once", marker, strings.Count(tmpl, marker)),
+	}
+}
+
+// Links returns the destinations of all links in the markdown, in order of
+// appearance and without duplicates. Bare URLs are included.
+func Links(md []byte) []string {
+	doc := goldmark.New(goldmark.WithExtensions(extension.GFM)).Parser().Parse(text.NewReader(md))
+	var links []string
+	seen := make(map[string]bool)
+	add := func(dest string) {
+		if dest != "" && !seen[dest] {
+			seen[dest] = true
+			links = append(links, dest)
+		}
+	}
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch v := n.(type) {
+		case *ast.Link:
+			add(string(v.Destination))
+		case *ast.AutoLink:
+			add(string(v.URL(md)))
+		}
+		return ast.WalkContinue, nil
+	})
+	return links
+}
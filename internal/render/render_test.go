@@ -2,6 +2,7 @@ package render
 
 import (
 	"bytes"
+	"errors"
 	"strings"
 	"testing"
 	"time"
@@ -62,3 +63,171 @@ func TestRender_AssetRewriting(t *testing.T) {
 		t.Errorf("Asset link path not rewritten correctly: %s", string(body))
 	}
 }
+
+func TestRender_LiveReload(t *testing.T) {
+	now := time.Now()
+	body := []byte("<p>Hello</p>\n")
+	for _, live := range []bool{false, true} {
+		output, err := New(nil, "", live, "").Compose(body, now, now)
+		if err != nil {
+			t.Fatalf("Compose failed: %v", err)
+		}
+		marked := strings.Contains(string(output), contentStart+"<p>Hello</p>\n"+contentEnd)
+		script := strings.Contains(string(output), "new EventSource('/events')")
+		if marked != live || script != live {
+			t.Errorf("liveReload=%v: content marked %v, script injected %v", live, marked, script)
+		}
+	}
+}
+
+func TestCompose_TemplateError(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		tmpl string
+		want TemplateError
+	}{
+		{"<body></body>", TemplateError{Message: "missing {{content}} marker"}},
+		{"<body>\n{{content}}\n<p>{{content}}</p>\n</body>", TemplateError{Line: 3, Message: "{{content}} appears 2 times; it must appear exactly once"}},
+	} {
+		_, err := New(nil, tc.tmpl, false, "").Compose(nil, now, now)
+		terr, ok := err.(*TemplateError)
+		if !ok || *terr != tc.want {
+			t.Errorf("Compose(%q) = %v, want %v", tc.tmpl, err, &tc.want)
+		}
+	}
+}
+
+func TestInlineStyles(t *testing.T) {
+	input := []byte(`<p>See <a href="x" style="color:red;">this</a></p><pre class="chroma"><span class="k">func</span></pre>`)
+	out := string(InlineStyles(input))
+
+	if !strings.Contains(out, `<p style="margin:0 0 12px 0;">`) {
+		t.Errorf("Paragraph not styled: %s", out)
+	}
+	if !strings.Contains(out, `<a href="x" style="color:#0000ee;text-decoration:underline;color:red;">`) {
+		t.Errorf("Existing style not preserved after defaults: %s", out)
+	}
+	if !strings.Contains(out, `<span style="color:#0000aa;font-weight:bold;" class="k">func</span>`) {
+		t.Errorf("Code token not styled: %s", out)
+	}
+	if strings.Contains(out, "<style") {
+		t.Errorf("Unexpected style block: %s", out)
+	}
+}
+
+func TestCheckTemplate_Default(t *testing.T) {
+	if problems := CheckTemplate(defaultTemplateHTML); len(problems) > 0 {
+		t.Errorf("Default template has problems: %v", problems)
+	}
+}
+
+func TestCheckTemplate(t *testing.T) {
+	tmpl := `<html>
+<head>
+<script src="https://cdn.example.com/app.js"></script>
+<style>@import url("https://fonts.example.com/mono.css"); p > span { }</style>
+</head>
+<body>
+<div class="header">{{fulname}} {{ title }}
+<p>One
+<p>Two</span>
+{{content}}{{content}}
+</html>`
+	var got []string
+	for _, p := range CheckTemplate(tmpl) {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"error: {{content}} appears 2 times; it must appear exactly once",
+		"error: missing </body>; the live reload script cannot be injected in preview",
+		"warning: missing <title>",
+		"3: warning: external script https://cdn.example.com/app.js blocks rendering; add async or defer, or serve it from assets/",
+		`4: warning: external resource https://fonts.example.com/mono.css in CSS adds a request to another host on every page`,
+		"7: error: unknown placeholder {{fulname}} will appear literally (did you mean {{fullname}}?)",
+		"7: error: placeholder {{ title }} must be written without spaces, as {{title}}",
+		"9: error: </span> has no matching opening tag",
+		"11: error: <div> opened on line 7 is not closed before </html>",
+		"11: error: <body> opened on line 6 is not closed before </html>",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected problems:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestThemes(t *testing.T) {
+	themes := Themes()
+	if len(themes) < 4 || themes[0].Name != DefaultTheme {
+		t.Fatalf("Unexpected themes: %v", themes)
+	}
+	for _, theme := range themes {
+		if theme.Description == "" {
+			t.Errorf("Theme %s has no description", theme.Name)
+		}
+		tmpl, err := ThemeTemplate(theme.Name)
+		if err != nil {
+			t.Fatalf("ThemeTemplate(%s) failed: %v", theme.Name, err)
+		}
+		if problems := CheckTemplate(tmpl); len(problems) > 0 {
+			t.Errorf("Theme %s has problems: %v", theme.Name, problems)
+		}
+	}
+	if _, err := ThemeTemplate("nope"); err == nil {
+		t.Error("Expected an error for an unknown theme")
+	}
+
+	amber, _ := ThemeCSS("amber")
+	page, _ := ThemeTemplate("paper")
+	page = ApplyTheme(page, amber)
+	if strings.Count(page, `<style id="plan-theme">`) != 1 || !strings.Contains(page, "#ffb000") || strings.Contains(page, "Georgia") {
+		t.Errorf("Theme not replaced")
+	}
+	if strings.Contains(ApplyTheme(page, ""), "plan-theme") {
+		t.Errorf("Theme not removed")
+	}
+}
+
+func TestStripPrivate(t *testing.T) {
+	input := "Public.\n\n<!-- private -->\nComment secret.\n<!-- /private -->\n\n:::private\nContainer secret.\n:::\n\n```\n:::private\n```\n\nEnd.\n"
+	got, err := StripPrivate([]byte(input))
+	if err != nil {
+		t.Fatalf("StripPrivate failed: %v", err)
+	}
+	want := "Public.\n\n\n\n```\n:::private\n```\n\nEnd.\n"
+	if string(got) != want {
+		t.Errorf("StripPrivate = %q, want %q", got, want)
+	}
+
+	got, err = StripPrivate([]byte("Public.\n\n:::private\nNever closed.\n<!-- /private -->\n"))
+	var perr *PrivateError
+	if !errors.As(err, &perr) || perr.Line != 3 {
+		t.Fatalf("error = %v, want a PrivateError at line 3", err)
+	}
+	if string(got) != "Public.\n\n" {
+		t.Errorf("unterminated block not stripped to the end: %q", got)
+	}
+}
+
+func TestRender_Private(t *testing.T) {
+	input := []byte("Public.\n\n<!-- private -->\nSecret.\n<!-- /private -->\n")
+	r := New(nil, "", false, "")
+	body, err := r.RenderBody(input)
+	if err != nil {
+		t.Fatalf("RenderBody failed: %v", err)
+	}
+	if strings.Contains(string(body), "Secret.") {
+		t.Errorf("private block was rendered: %s", body)
+	}
+
+	r.ShowPrivate = true
+	body, err = r.RenderBody(input)
+	if err != nil {
+		t.Fatalf("RenderBody failed: %v", err)
+	}
+	if !strings.Contains(string(body), `<div class="plan-private"`) || !strings.Contains(string(body), "<p>Secret.</p>") {
+		t.Errorf("private block not highlighted: %s", body)
+	}
+
+	if _, err := r.RenderBody([]byte(":::private\nSecret.\n")); err == nil {
+		t.Error("RenderBody accepted an unterminated private block")
+	}
+}
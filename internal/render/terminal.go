@@ -0,0 +1,453 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// ANSI SGR sequences used by the terminal renderer. Each style is closed with
+// its specific "off" code rather than a full reset so that styles can nest.
+const (
+	sgrBold      = "1"
+	sgrBoldOff   = "22"
+	sgrDim       = "2"
+	sgrItalic    = "3"
+	sgrItalicOff = "23"
+	sgrUnder     = "4"
+	sgrUnderOff  = "24"
+	sgrStrike    = "9"
+	sgrStrikeOff = "29"
+	sgrCyan      = "36"
+	sgrFgOff     = "39"
+)
+
+// TerminalRenderer converts markdown to text for display in a terminal.
+// When color is enabled the output is styled with ANSI escape sequences,
+// otherwise it is plain text with the same layout.
+type TerminalRenderer struct {
+	md    goldmark.Markdown
+	width int
+	color bool
+	style string
+}
+
+// NewTerminal creates a TerminalRenderer that wraps text at width columns.
+func NewTerminal(width int, color bool) *TerminalRenderer {
+	if width <= 0 {
+		width = 80
+	}
+	md := goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.Typographer,
+		),
+	)
+	return &TerminalRenderer{
+		md:    md,
+		width: width,
+		color: color,
+		style: "monokai",
+	}
+}
+
+// Render converts markdown to terminal text. Link destinations are collected
+// as numbered footnotes and listed after the body.
+func (t *TerminalRenderer) Render(src []byte) ([]byte, error) {
+	doc := t.md.Parser().Parse(text.NewReader(src))
+	w := &termWriter{src: src, color: t.color, style: t.style}
+
+	lines := w.blocks(doc, t.width)
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if len(w.links) > 0 {
+		buf.WriteByte('\n')
+		for i, url := range w.links {
+			buf.WriteString(w.sgr(sgrDim, sgrBoldOff, fmt.Sprintf("[%d]: %s", i+1, url)))
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// termWriter holds the state of a single Render call.
+type termWriter struct {
+	src   []byte
+	color bool
+	style string
+	links []string
+}
+
+func (w *termWriter) sgr(on, off, s string) string {
+	if !w.color || s == "" {
+		return s
+	}
+	return "\x1b[" + on + "m" + s + "\x1b[" + off + "m"
+}
+
+// footnote registers a link destination and returns its marker.
+func (w *termWriter) footnote(url string) string {
+	for i, u := range w.links {
+		if u == url {
+			return fmt.Sprintf("[%d]", i+1)
+		}
+	}
+	w.links = append(w.links, url)
+	return fmt.Sprintf("[%d]", len(w.links))
+}
+
+// blocks renders the block children of parent, separated by blank lines.
+func (w *termWriter) blocks(parent ast.Node, width int) []string {
+	var out []string
+	for c := parent.FirstChild(); c != nil; c = c.NextSibling() {
+		lines := w.block(c, width)
+		if len(lines) == 0 {
+			continue
+		}
+		if len(out) > 0 {
+			out = append(out, "")
+		}
+		out = append(out, lines...)
+	}
+	return out
+}
+
+func (w *termWriter) block(n ast.Node, width int) []string {
+	switch v := n.(type) {
+	case *ast.Heading:
+		title := w.inline(v)
+		if !w.color {
+			lines := wrap(title, width)
+			if v.Level <= 2 {
+				rule := "="
+				if v.Level == 2 {
+					rule = "-"
+				}
+				lines = append(lines, strings.Repeat(rule, min(visibleWidth(title), width)))
+			}
+			return lines
+		}
+		if v.Level == 1 {
+			return wrap(w.sgr(sgrBold+";"+sgrUnder, sgrBoldOff+";"+sgrUnderOff, title), width)
+		}
+		return wrap(w.sgr(sgrBold, sgrBoldOff, title), width)
+
+	case *ast.Paragraph, *ast.TextBlock:
+		return wrap(w.inline(n), width)
+
+	case *ast.FencedCodeBlock:
+		return w.code(n, string(v.Language(w.src)))
+
+	case *ast.CodeBlock:
+		return w.code(n, "")
+
+	case *ast.HTMLBlock:
+		var lines []string
+		for _, line := range w.rawLines(n) {
+			lines = append(lines, w.sgr(sgrDim, sgrBoldOff, line))
+		}
+		if v.HasClosure() {
+			lines = append(lines, w.sgr(sgrDim, sgrBoldOff, strings.TrimRight(string(v.ClosureLine.Value(w.src)), "\n")))
+		}
+		return lines
+
+	case *ast.Blockquote:
+		bar := "> "
+		if w.color {
+			bar = w.sgr(sgrDim, sgrBoldOff, "│") + " "
+		}
+		return prefixLines(w.blocks(v, width-2), bar, bar)
+
+	case *ast.List:
+		return w.list(v, width)
+
+	case *ast.ThematicBreak:
+		rule := "-"
+		if w.color {
+			rule = "─"
+		}
+		return []string{w.sgr(sgrDim, sgrBoldOff, strings.Repeat(rule, min(width, 40)))}
+
+	case *east.Table:
+		return w.table(v)
+
+	default:
+		return w.blocks(n, width)
+	}
+}
+
+func (w *termWriter) list(l *ast.List, width int) []string {
+	var out []string
+	num := l.Start
+	for item := l.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "- "
+		if w.color {
+			marker = "• "
+		}
+		if l.IsOrdered() {
+			marker = strconv.Itoa(num) + string(l.Marker) + " "
+			num++
+		}
+		indent := strings.Repeat(" ", visibleWidth(marker))
+
+		var inner []string
+		for c := item.FirstChild(); c != nil; c = c.NextSibling() {
+			lines := w.block(c, width-len(indent))
+			if len(lines) == 0 {
+				continue
+			}
+			if len(inner) > 0 && !l.IsTight {
+				inner = append(inner, "")
+			}
+			inner = append(inner, lines...)
+		}
+		if len(inner) == 0 {
+			inner = []string{""}
+		}
+
+		if len(out) > 0 && !l.IsTight {
+			out = append(out, "")
+		}
+		out = append(out, prefixLines(inner, marker, indent)...)
+	}
+	return out
+}
+
+func (w *termWriter) table(t *east.Table) []string {
+	var rows [][]string
+	for row := t.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			content := w.inline(cell)
+			if _, ok := row.(*east.TableHeader); ok {
+				content = w.sgr(sgrBold, sgrBoldOff, content)
+			}
+			cells = append(cells, content)
+		}
+		rows = append(rows, cells)
+	}
+
+	var widths []int
+	for _, cells := range rows {
+		for i, c := range cells {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], visibleWidth(c))
+		}
+	}
+
+	var out []string
+	for r, cells := range rows {
+		var line strings.Builder
+		for i, c := range cells {
+			if i > 0 {
+				line.WriteString(" | ")
+			}
+			pad := widths[i] - visibleWidth(c)
+			align := east.AlignNone
+			if i < len(t.Alignments) {
+				align = t.Alignments[i]
+			}
+			switch align {
+			case east.AlignRight:
+				line.WriteString(strings.Repeat(" ", pad) + c)
+			case east.AlignCenter:
+				line.WriteString(strings.Repeat(" ", pad/2) + c + strings.Repeat(" ", pad-pad/2))
+			default:
+				line.WriteString(c + strings.Repeat(" ", pad))
+			}
+		}
+		out = append(out, strings.TrimRight(line.String(), " "))
+		if r == 0 {
+			var sep []string
+			for _, wd := range widths {
+				sep = append(sep, strings.Repeat("-", wd))
+			}
+			out = append(out, strings.Join(sep, "-+-"))
+		}
+	}
+	return out
+}
+
+// code renders a code block, indented and highlighted with chroma when color
+// is enabled. Code is never wrapped.
+func (w *termWriter) code(n ast.Node, lang string) []string {
+	lines := w.rawLines(n)
+	source := strings.Join(lines, "\n")
+
+	if w.color {
+		lexer := lexers.Get(lang)
+		if lexer == nil {
+			lexer = lexers.Analyse(source)
+		}
+		if lexer == nil {
+			lexer = lexers.Fallback
+		}
+		lexer = chroma.Coalesce(lexer)
+		style := styles.Get(w.style)
+		if it, err := lexer.Tokenise(nil, source); err == nil {
+			var buf bytes.Buffer
+			if err := formatters.TTY256.Format(&buf, style, it); err == nil {
+				lines = strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+			}
+		}
+	}
+	return prefixLines(lines, "    ", "    ")
+}
+
+func (w *termWriter) rawLines(n ast.Node) []string {
+	var lines []string
+	segs := n.Lines()
+	for i := 0; i < segs.Len(); i++ {
+		seg := segs.At(i)
+		lines = append(lines, strings.TrimRight(string(seg.Value(w.src)), "\n"))
+	}
+	return lines
+}
+
+// inline renders the inline children of n as a single string. Hard line
+// breaks are kept as newlines for wrap to honour.
+func (w *termWriter) inline(n ast.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch v := c.(type) {
+		case *ast.Text:
+			sb.Write(v.Value(w.src))
+			if v.HardLineBreak() {
+				sb.WriteByte('\n')
+			} else if v.SoftLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			// The typographer's quotes and dashes are HTML entities.
+			sb.WriteString(html.UnescapeString(string(v.Value)))
+		case *ast.Emphasis:
+			if v.Level >= 2 {
+				sb.WriteString(w.sgr(sgrBold, sgrBoldOff, w.inline(v)))
+			} else {
+				sb.WriteString(w.sgr(sgrItalic, sgrItalicOff, w.inline(v)))
+			}
+		case *ast.CodeSpan:
+			code := w.inline(v)
+			if w.color {
+				sb.WriteString(w.sgr(sgrCyan, sgrFgOff, code))
+			} else {
+				sb.WriteString("`" + code + "`")
+			}
+		case *ast.Link:
+			label := w.inline(v)
+			url := string(v.Destination)
+			sb.WriteString(w.sgr(sgrUnder, sgrUnderOff, label))
+			if label != url {
+				sb.WriteString(w.footnote(url))
+			}
+		case *ast.AutoLink:
+			sb.WriteString(w.sgr(sgrUnder, sgrUnderOff, string(v.Label(w.src))))
+		case *ast.Image:
+			alt := w.inline(v)
+			if alt == "" {
+				alt = "image"
+			}
+			sb.WriteString(w.sgr(sgrDim, sgrBoldOff, "[image: "+alt+"]"))
+			sb.WriteString(w.footnote(string(v.Destination)))
+		case *ast.RawHTML:
+			segs := v.Segments
+			for i := 0; i < segs.Len(); i++ {
+				seg := segs.At(i)
+				sb.WriteString(w.sgr(sgrDim, sgrBoldOff, string(seg.Value(w.src))))
+			}
+		case *east.Strikethrough:
+			sb.WriteString(w.sgr(sgrStrike, sgrStrikeOff, w.inline(v)))
+		case *east.TaskCheckBox:
+			if v.IsChecked {
+				sb.WriteString("[x] ")
+			} else {
+				sb.WriteString("[ ] ")
+			}
+		default:
+			sb.WriteString(w.inline(c))
+		}
+	}
+	return sb.String()
+}
+
+// wrap breaks s into lines no wider than width, measuring only visible
+// characters so escape sequences do not count towards the width.
+func wrap(s string, width int) []string {
+	if width < 10 {
+		width = 10
+	}
+	var lines []string
+	for _, para := range strings.Split(s, "\n") {
+		var line strings.Builder
+		lineWidth := 0
+		for _, word := range strings.Fields(para) {
+			ww := visibleWidth(word)
+			if lineWidth > 0 && lineWidth+1+ww > width {
+				lines = append(lines, line.String())
+				line.Reset()
+				lineWidth = 0
+			}
+			if lineWidth > 0 {
+				line.WriteByte(' ')
+				lineWidth++
+			}
+			line.WriteString(word)
+			lineWidth += ww
+		}
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+// visibleWidth returns the number of runes in s, ignoring ANSI escape sequences.
+func visibleWidth(s string) int {
+	n := 0
+	inEscape := false
+	for _, r := range s {
+		switch {
+		case inEscape:
+			if r >= '@' && r <= '~' && r != '[' {
+				inEscape = false
+			}
+		case r == '\x1b':
+			inEscape = true
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+func prefixLines(lines []string, first, rest string) []string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		p := rest
+		if i == 0 {
+			p = first
+		}
+		if line == "" {
+			out[i] = strings.TrimRight(p, " ")
+		} else {
+			out[i] = p + line
+		}
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTerminal_PlainText(t *testing.T) {
+	input := []byte("# Title\n\nSee [the site](https://example.com) and **this**.\n\n- one\n- two\n")
+	out, err := NewTerminal(80, false).Render(input)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	s := string(out)
+
+	if strings.Contains(s, "\x1b[") {
+		t.Errorf("Plain output contains escape sequences: %q", s)
+	}
+	if !strings.Contains(s, "See the site[1] and this.") {
+		t.Errorf("Link not footnoted in paragraph: %s", s)
+	}
+	if !strings.Contains(s, "[1]: https://example.com") {
+		t.Errorf("Footnote URL missing: %s", s)
+	}
+	if !strings.Contains(s, "- one\n- two\n") {
+		t.Errorf("Tight list not rendered: %s", s)
+	}
+}
+
+func TestTerminal_Wrapping(t *testing.T) {
+	input := []byte("- " + strings.Repeat("word ", 20))
+	out, err := NewTerminal(30, false).Render(input)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("Expected wrapped list item, got: %q", out)
+	}
+	for i, line := range lines {
+		if len(line) > 30 {
+			t.Errorf("Line %d exceeds width: %q", i, line)
+		}
+		if i > 0 && !strings.HasPrefix(line, "  word") {
+			t.Errorf("Continuation line %d not indented under marker: %q", i, line)
+		}
+	}
+}
+
+func TestTerminal_Color(t *testing.T) {
+	input := []byte("*it* and [link](https://example.com)\n\n```go\nfunc main() {}\n```\n")
+	out, err := NewTerminal(80, true).Render(input)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	s := string(out)
+
+	if !strings.Contains(s, "\x1b[3mit\x1b[23m") {
+		t.Errorf("Italic not styled: %q", s)
+	}
+	if !strings.Contains(s, "\x1b[4mlink\x1b[24m[1]") {
+		t.Errorf("Link not underlined and footnoted: %q", s)
+	}
+	if !strings.Contains(s, "    \x1b[") {
+		t.Errorf("Code block not highlighted: %q", s)
+	}
+}
+
+func TestTerminal_Typography(t *testing.T) {
+	input := []byte("I don't know \"quoted\" -- dash...\n")
+	out, err := NewTerminal(80, false).Render(input)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if s := string(out); strings.Contains(s, "&") || !strings.Contains(s, "I don’t know “quoted” – dash…") {
+		t.Errorf("Typography not rendered as text: %q", s)
+	}
+}
@@ -0,0 +1,86 @@
+package render
+
+import (
+	"embed"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//go:embed themes/*.css
+var themeFS embed.FS
+
+// DefaultTheme is the look of the built-in template: xterm, black on white,
+// or white on black in dark mode.
+const DefaultTheme = "xterm"
+
+// Theme is a built-in look for the site.
+type Theme struct {
+	Name        string
+	Description string
+}
+
+var themeDescRe = regexp.MustCompile(`^/\*\s*(.*?)\s*\*/`)
+
+// Themes returns the built-in themes, the default first.
+func Themes() []Theme {
+	themes := []Theme{{Name: DefaultTheme, Description: "Workstation xterm: black on white, or white on black in dark mode"}}
+	entries, _ := themeFS.ReadDir("themes")
+	var rest []Theme
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".css")
+		css, _ := ThemeCSS(name)
+		desc := ""
+		if m := themeDescRe.FindStringSubmatch(css); m != nil {
+			desc = m[1]
+		}
+		rest = append(rest, Theme{Name: name, Description: desc})
+	}
+	sort.Slice(rest, func(i, j int) bool { return rest[i].Name < rest[j].Name })
+	return append(themes, rest...)
+}
+
+// ThemeCSS returns the styles the named theme adds to the built-in template.
+// The default theme adds none.
+func ThemeCSS(name string) (string, error) {
+	if name == DefaultTheme || name == "" {
+		return "", nil
+	}
+	css, err := themeFS.ReadFile("themes/" + name + ".css")
+	if err != nil {
+		var names []string
+		for _, t := range Themes() {
+			names = append(names, t.Name)
+		}
+		return "", fmt.Errorf("unknown theme %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return string(css), nil
+}
+
+// ThemeTemplate returns the complete page template for the named theme: the
+// built-in template with the theme's styles added after its own.
+func ThemeTemplate(name string) (string, error) {
+	css, err := ThemeCSS(name)
+	if err != nil {
+		return "", err
+	}
+	return ApplyTheme(defaultTemplateHTML, css), nil
+}
+
+var themeStyleRe = regexp.MustCompile(`(?s)<style id="plan-theme">.*?</style>\n?`)
+
+// ApplyTheme replaces the theme styles in a page or template with css, or
+// removes them when css is empty. New styles go at the end of <head> so
+// that they override the template's own.
+func ApplyTheme(page, css string) string {
+	page = themeStyleRe.ReplaceAllString(page, "")
+	if css == "" {
+		return page
+	}
+	style := `<style id="plan-theme">` + "\n" + css + "</style>\n"
+	if i := strings.Index(page, "</head>"); i >= 0 {
+		return page[:i] + style + page[i:]
+	}
+	return style + page
+}
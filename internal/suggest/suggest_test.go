@@ -0,0 +1,38 @@
+package suggest
+
+import "testing"
+
+func TestDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"title", "title", 0},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"tiemzone", "timezone", 2},
+	}
+	for _, c := range cases {
+		if got := Distance(c.a, c.b); got != c.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestClosest(t *testing.T) {
+	candidates := []string{"title", "timezone", "base_url", "fullName"}
+	cases := map[string]string{
+		"titel":    "title",
+		"TimeZone": "timezone",
+		"fullname": "fullName",
+		"baseurl":  "base_url",
+		"shell":    "",
+		"":         "",
+	}
+	for name, want := range cases {
+		if got := Closest(name, candidates); got != want {
+			t.Errorf("Closest(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package webmention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/publicnet"
+)
+
+// Mention is a verified Webmention received for a page of the site.
+type Mention struct {
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	Title    string    `json:"title,omitempty"`
+	Received time.Time `json:"received"`
+}
+
+// Store persists received mentions as a JSON file.
+type Store struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Load returns all stored mentions. A missing file is not an error.
+func (s *Store) Load() ([]Mention, error) {
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mentions []Mention
+	if err := json.Unmarshal(data, &mentions); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.Path, err)
+	}
+	return mentions, nil
+}
+
+// Put adds m, replacing any earlier mention with the same source and target.
+// It reports whether the stored mentions changed.
+func (s *Store) Put(m Mention) (bool, error) {
+	return s.update(func(mentions []Mention) ([]Mention, bool) {
+		for i, old := range mentions {
+			if old.Source == m.Source && old.Target == m.Target {
+				m.Received = old.Received
+				if m == old {
+					return mentions, false
+				}
+				mentions[i] = m
+				return mentions, true
+			}
+		}
+		return append(mentions, m), true
+	})
+}
+
+// Delete removes the mention with the given source and target, if any. It
+// reports whether there was one.
+func (s *Store) Delete(source, target string) (bool, error) {
+	return s.update(func(mentions []Mention) ([]Mention, bool) {
+		out := mentions[:0]
+		for _, m := range mentions {
+			if m.Source != source || m.Target != target {
+				out = append(out, m)
+			}
+		}
+		return out, len(out) != len(mentions)
+	})
+}
+
+// update applies fn to the stored mentions, and writes them back if fn
+// reports a change.
+func (s *Store) update(fn func([]Mention) ([]Mention, bool)) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mentions, err := s.Load()
+	if err != nil {
+		return false, err
+	}
+	mentions, changed := fn(mentions)
+	if !changed {
+		return false, nil
+	}
+	sort.Slice(mentions, func(i, j int) bool {
+		return mentions[i].Received.Before(mentions[j].Received)
+	})
+
+	data, err := json.MarshalIndent(mentions, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return false, err
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, s.Path)
+}
+
+// Receiver is an http.Handler implementing a Webmention endpoint. Requests
+// are checked and answered with 202 Accepted at once, and their sources are
+// fetched and verified one at a time in the background.
+type Receiver struct {
+	// BaseURL is the site's public URL. Only targets under it are accepted.
+	BaseURL string
+	Store   *Store
+	Client  *Client
+	// OnChange, if set, is called after a mention is stored or removed.
+	OnChange func(Mention)
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+	// Queue is how many mentions may wait for verification before new
+	// ones are turned away; it defaults to 100.
+	Queue int
+	// AllowPrivate accepts sources on private and loopback addresses,
+	// which are otherwise refused so that a mention cannot make the
+	// server fetch from its own network.
+	AllowPrivate bool
+
+	once    sync.Once
+	pending chan Mention
+	fetcher *Client
+	wg      sync.WaitGroup
+}
+
+var (
+	errUnsupportedTarget = errors.New("target is not on this site")
+	errNoLink            = errors.New("source does not link to target")
+	errPrivateSource     = publicnet.ErrNotPublic
+)
+
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	source, target := r.PostFormValue("source"), r.PostFormValue("target")
+	if err := rc.validate(source, target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rc.once.Do(rc.start)
+	rc.wg.Add(1)
+	select {
+	case rc.pending <- Mention{Source: source, Target: target}:
+	default:
+		rc.wg.Done()
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many mentions waiting for verification", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "Mention accepted for verification.")
+}
+
+// Wait blocks until every mention accepted so far has been processed.
+func (rc *Receiver) Wait() {
+	rc.wg.Wait()
+}
+
+// start sets up the queue and the worker that drains it.
+func (rc *Receiver) start() {
+	size := rc.Queue
+	if size <= 0 {
+		size = 100
+	}
+	rc.pending = make(chan Mention, size)
+	rc.fetcher = rc.Client
+	if rc.fetcher == nil {
+		rc.fetcher = NewClient()
+	}
+	if !rc.AllowPrivate {
+		rc.fetcher = rc.fetcher.publicOnly()
+	}
+	go func() {
+		for m := range rc.pending {
+			rc.process(m.Source, m.Target)
+			rc.wg.Done()
+		}
+	}()
+}
+
+// process verifies a mention and stores or removes it accordingly.
+func (rc *Receiver) process(source, target string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	m, err := rc.verify(ctx, rc.fetcher, source, target)
+	switch {
+	case errors.Is(err, errNoLink):
+		// A mention whose source no longer links to us is a deletion.
+		removed, err := rc.Store.Delete(source, target)
+		if err != nil {
+			log.Printf("webmention: deleting %s: %v", source, err)
+		}
+		if removed {
+			rc.changed(Mention{Source: source, Target: target})
+		}
+		return
+	case err != nil:
+		log.Printf("webmention: verifying %s: %v", source, err)
+		return
+	}
+
+	stored, err := rc.Store.Put(m)
+	if err != nil {
+		log.Printf("webmention: storing %s: %v", source, err)
+		return
+	}
+	if stored {
+		rc.changed(m)
+	}
+}
+
+func (rc *Receiver) changed(m Mention) {
+	if rc.OnChange != nil {
+		rc.OnChange(m)
+	}
+}
+
+// validate checks the request parameters as required by the specification.
+func (rc *Receiver) validate(source, target string) error {
+	su, err := url.Parse(source)
+	if err != nil || (su.Scheme != "http" && su.Scheme != "https") || su.Host == "" {
+		return fmt.Errorf("invalid source URL %q", source)
+	}
+	tu, err := url.Parse(target)
+	if err != nil || (tu.Scheme != "http" && tu.Scheme != "https") || tu.Host == "" {
+		return fmt.Errorf("invalid target URL %q", target)
+	}
+	if source == target {
+		return errors.New("source and target are the same")
+	}
+	if !rc.AllowPrivate {
+		if err := publicnet.CheckURL(su); err != nil {
+			return fmt.Errorf("source: %w", err)
+		}
+	}
+	base := strings.TrimSuffix(rc.BaseURL, "/")
+	if target != base && !strings.HasPrefix(target, base+"/") {
+		return errUnsupportedTarget
+	}
+	return nil
+}
+
+// Verify fetches source and checks that it links to target.
+func (rc *Receiver) Verify(ctx context.Context, source, target string) (Mention, error) {
+	client := rc.Client
+	if client == nil {
+		client = NewClient()
+	}
+	return rc.verify(ctx, client, source, target)
+}
+
+func (rc *Receiver) verify(ctx context.Context, client *Client, source, target string) (Mention, error) {
+	resp, body, err := client.get(ctx, source)
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound) {
+			return Mention{}, errNoLink
+		}
+		return Mention{}, err
+	}
+	if !linksTo(resp.Request.URL, string(body), target) {
+		return Mention{}, errNoLink
+	}
+
+	now := time.Now
+	if rc.Now != nil {
+		now = rc.Now
+	}
+	m := Mention{Source: source, Target: target, Received: now().UTC()}
+	if t := titleRe.FindStringSubmatch(string(body)); t != nil {
+		m.Title = strings.Join(strings.Fields(html.UnescapeString(t[1])), " ")
+	}
+	return m, nil
+}
+
+// linksTo reports whether the page has an <a> or <link> pointing at target.
+func linksTo(base *url.URL, body, target string) bool {
+	for _, tag := range tagRe.FindAllStringSubmatch(body, -1) {
+		name := strings.ToLower(tag[1])
+		if name != "a" && name != "link" {
+			continue
+		}
+		href, ok := parseAttrs(tag[2])["href"]
+		if !ok {
+			continue
+		}
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil {
+			continue
+		}
+		if sameURL(u.String(), target) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameURL compares two URLs ignoring a trailing slash and fragment.
+func sameURL(a, b string) bool {
+	norm := func(s string) string {
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		return strings.TrimSuffix(s, "/")
+	}
+	return norm(a) == norm(b)
+}
+
+// publicOnly returns a copy of c that refuses to connect to addresses that
+// are not public.
+func (c *Client) publicOnly() *Client {
+	return &Client{HTTP: publicnet.Client(c.HTTP), UserAgent: c.UserAgent}
+}
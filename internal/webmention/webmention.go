@@ -0,0 +1,237 @@
+// Package webmention implements sending and receiving Webmentions
+// (https://www.w3.org/TR/webmention/).
+package webmention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNoEndpoint is returned when a target does not advertise an endpoint.
+var ErrNoEndpoint = errors.New("no webmention endpoint")
+
+// maxBody limits how much of a fetched page is read.
+const maxBody = 1 << 20
+
+// Client sends Webmentions and fetches pages for discovery and verification.
+type Client struct {
+	HTTP      *http.Client
+	UserAgent string
+}
+
+// NewClient returns a Client with a sensible timeout.
+func NewClient() *Client {
+	return &Client{
+		HTTP:      &http.Client{Timeout: 15 * time.Second},
+		UserAgent: "a-simple-plan (webmention)",
+	}
+}
+
+func (c *Client) get(ctx context.Context, target string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Accept", "text/html, */*;q=0.5")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, body, fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	return resp, body, nil
+}
+
+// Discover returns the Webmention endpoint advertised by target, either in
+// an HTTP Link header or a <link> or <a> element with rel="webmention".
+// Relative endpoints are resolved against the final URL of target.
+func (c *Client) Discover(ctx context.Context, target string) (string, error) {
+	resp, body, err := c.get(ctx, target)
+	if err != nil {
+		return "", err
+	}
+	base := resp.Request.URL
+
+	for _, header := range resp.Header.Values("Link") {
+		if href, ok := linkHeaderEndpoint(header); ok {
+			return resolve(base, href)
+		}
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return "", ErrNoEndpoint
+	}
+	for _, tag := range tagRe.FindAllStringSubmatch(string(body), -1) {
+		name := strings.ToLower(tag[1])
+		if name != "link" && name != "a" {
+			continue
+		}
+		attrs := parseAttrs(tag[2])
+		href, hasHref := attrs["href"]
+		if hasHref && hasRel(attrs["rel"], "webmention") {
+			return resolve(base, href)
+		}
+	}
+	return "", ErrNoEndpoint
+}
+
+// Send notifies endpoint that source links to target.
+func (c *Client) Send(ctx context.Context, endpoint, source, target string) error {
+	form := url.Values{"source": {source}, "target": {target}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", c.UserAgent)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// Mention sends a Webmention from source to target, discovering target's
+// endpoint first.
+func (c *Client) Mention(ctx context.Context, source, target string) error {
+	endpoint, err := c.Discover(ctx, target)
+	if err != nil {
+		return err
+	}
+	return c.Send(ctx, endpoint, source, target)
+}
+
+var (
+	tagRe      = regexp.MustCompile(`(?is)<(link|a|title)\b([^>]*)>`)
+	attrRe     = regexp.MustCompile(`(?s)([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	linkPartRe = regexp.MustCompile(`<([^>]*)>((?:\s*;\s*[^,;]+)*)`)
+	relParamRe = regexp.MustCompile(`(?i);\s*rel\s*=\s*(?:"([^"]*)"|([^\s;,]+))`)
+	titleRe    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+func parseAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attrRe.FindAllStringSubmatch(s, -1) {
+		name := strings.ToLower(m[1])
+		if _, ok := attrs[name]; ok {
+			continue
+		}
+		attrs[name] = html.UnescapeString(m[2] + m[3] + m[4])
+	}
+	return attrs
+}
+
+func hasRel(rel, want string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == want {
+			return true
+		}
+	}
+	return false
+}
+
+// linkHeaderEndpoint finds a rel="webmention" target in an HTTP Link header.
+func linkHeaderEndpoint(header string) (string, bool) {
+	for _, m := range linkPartRe.FindAllStringSubmatch(header, -1) {
+		for _, rel := range relParamRe.FindAllStringSubmatch(m[2], -1) {
+			if hasRel(rel[1]+rel[2], "webmention") {
+				return m[1], true
+			}
+		}
+	}
+	return "", false
+}
+
+func resolve(base *url.URL, href string) (string, error) {
+	u, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %w", href, err)
+	}
+	return u.String(), nil
+}
+
+// SentState records which mentions have been sent, so that rebuilding the
+// site never sends the same mention twice.
+type SentState struct {
+	path string
+	// Sources maps each page whose outbound links have been processed to
+	// the commit it was processed at.
+	Sources map[string]string `json:"sources"`
+	// Sent maps each source to the targets it was successfully sent for.
+	Sent map[string]map[string]time.Time `json:"sent"`
+}
+
+// LoadSentState reads the state file at path. A missing file yields an empty
+// state, for which IsNew reports true.
+func LoadSentState(path string) (*SentState, error) {
+	s := &SentState{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	if s.Sources == nil {
+		s.Sources = make(map[string]string)
+	}
+	if s.Sent == nil {
+		s.Sent = make(map[string]map[string]time.Time)
+	}
+	return s, nil
+}
+
+// IsNew reports whether nothing has been recorded yet.
+func (s *SentState) IsNew() bool {
+	return len(s.Sources) == 0 && len(s.Sent) == 0
+}
+
+// WasSent reports whether a mention from source to target was sent.
+func (s *SentState) WasSent(source, target string) bool {
+	_, ok := s.Sent[source][target]
+	return ok
+}
+
+// MarkSent records that a mention from source to target was sent at t.
+func (s *SentState) MarkSent(source, target string, t time.Time) {
+	if s.Sent[source] == nil {
+		s.Sent[source] = make(map[string]time.Time)
+	}
+	s.Sent[source][target] = t
+}
+
+// Save writes the state back to its file.
+func (s *SentState) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, append(data, '\n'), 0644)
+}
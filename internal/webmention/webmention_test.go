@@ -0,0 +1,240 @@
+package webmention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiscover(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/header", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `<https://other.example/x>; rel="other", </wm/header>; rel="webmention"`)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><link rel="webmention" href="/wm/ignored"></head></html>`)
+	})
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><link href="wm/link?a=1&amp;b=2" rel="me webmention"></head></html>`)
+	})
+	mux.HandleFunc("/anchor", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<p><a rel=webmention href='/wm/anchor'>endpoint</a></p>`)
+	})
+	mux.HandleFunc("/none", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<p>nothing here</p>`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := NewClient()
+	tests := map[string]string{
+		"/header": srv.URL + "/wm/header",
+		"/link":   srv.URL + "/wm/link?a=1&b=2",
+		"/anchor": srv.URL + "/wm/anchor",
+	}
+	for path, want := range tests {
+		got, err := c.Discover(context.Background(), srv.URL+path)
+		if err != nil {
+			t.Errorf("Discover(%s) failed: %v", path, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Discover(%s) = %q, want %q", path, got, want)
+		}
+	}
+
+	if _, err := c.Discover(context.Background(), srv.URL+"/none"); err != ErrNoEndpoint {
+		t.Errorf("Discover(/none) error = %v, want ErrNoEndpoint", err)
+	}
+}
+
+// TestRoundTrip sends a mention from one local site to another and checks
+// that the receiver verifies and stores it.
+func TestRoundTrip(t *testing.T) {
+	store := &Store{Path: filepath.Join(t.TempDir(), "webmentions.json")}
+	var changed []Mention
+
+	receiverMux := http.NewServeMux()
+	receiver := httptest.NewServer(receiverMux)
+	defer receiver.Close()
+	rc := &Receiver{
+		BaseURL:  receiver.URL,
+		Store:    store,
+		Client:   NewClient(),
+		OnChange: func(m Mention) { changed = append(changed, m) },
+		Now:      func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC) },
+		// The test servers are on the loopback interface.
+		AllowPrivate: true,
+	}
+	receiverMux.Handle("/webmention", rc)
+	receiverMux.HandleFunc("/2025/01/02", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><link rel="webmention" href="/webmention"></head></html>`)
+	})
+	target := receiver.URL + "/2025/01/02"
+
+	linking := true
+	sender := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if linking {
+			fmt.Fprintf(w, `<html><title>A &amp; B</title><p>See <a href="%s">this</a>.</p></html>`, target)
+		} else {
+			fmt.Fprint(w, `<html><title>A &amp; B</title><p>Nothing.</p></html>`)
+		}
+	}))
+	defer sender.Close()
+	source := sender.URL + "/2025/01/03"
+
+	c := NewClient()
+	if err := c.Mention(context.Background(), source, target); err != nil {
+		t.Fatalf("Mention failed: %v", err)
+	}
+	rc.Wait()
+
+	mentions, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(mentions) != 1 {
+		t.Fatalf("Expected 1 stored mention, got %d", len(mentions))
+	}
+	m := mentions[0]
+	if m.Source != source || m.Target != target || m.Title != "A & B" {
+		t.Errorf("Unexpected mention: %+v", m)
+	}
+	if len(changed) != 1 {
+		t.Errorf("Expected OnChange to be called once, got %d", len(changed))
+	}
+
+	// Sending the same mention again changes nothing.
+	if err := c.Mention(context.Background(), source, target); err != nil {
+		t.Fatalf("Mention failed: %v", err)
+	}
+	rc.Wait()
+	if len(changed) != 1 {
+		t.Errorf("Expected an unchanged mention not to call OnChange, got %d calls", len(changed))
+	}
+
+	// Sending again after the link is removed deletes the mention, once.
+	linking = false
+	for i := 0; i < 2; i++ {
+		if err := c.Mention(context.Background(), source, target); err != nil {
+			t.Fatalf("Mention failed: %v", err)
+		}
+		rc.Wait()
+	}
+	if mentions, _ := store.Load(); len(mentions) != 0 {
+		t.Errorf("Expected mention to be deleted, got %+v", mentions)
+	}
+	if len(changed) != 2 {
+		t.Errorf("Expected OnChange to be called once for the deletion, got %d calls", len(changed))
+	}
+}
+
+func TestReceiver_RefusesPrivateSources(t *testing.T) {
+	fetched := false
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		fmt.Fprint(w, `<a href="https://plan.example/2025/01/02">link</a>`)
+	}))
+	defer private.Close()
+	rc := &Receiver{
+		BaseURL: "https://plan.example",
+		Store:   &Store{Path: filepath.Join(t.TempDir(), "webmentions.json")},
+	}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	target := "https://plan.example/2025/01/02"
+	for _, source := range []string{private.URL, "http://localhost/x", "http://10.0.0.1/x", "http://[::1]/x", "http://169.254.169.254/latest"} {
+		resp, err := http.PostForm(srv.URL, url.Values{"source": {source}, "target": {target}})
+		if err != nil {
+			t.Fatalf("PostForm failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", source, resp.StatusCode)
+		}
+	}
+
+	// Names and redirects are checked again when the source is fetched.
+	if _, err := rc.verify(context.Background(), NewClient().publicOnly(), private.URL, target); !errors.Is(err, errPrivateSource) {
+		t.Errorf("verify(%s) error = %v, want errPrivateSource", private.URL, err)
+	}
+	if fetched {
+		t.Error("fetched a source on a private network")
+	}
+}
+
+func TestReceiver_RejectsInvalid(t *testing.T) {
+	rc := &Receiver{
+		BaseURL: "https://plan.example",
+		Store:   &Store{Path: filepath.Join(t.TempDir(), "webmentions.json")},
+	}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	cases := []url.Values{
+		{"source": {"ftp://a.example/"}, "target": {"https://plan.example/"}},
+		{"source": {"https://a.example/"}, "target": {"https://elsewhere.example/"}},
+		{"source": {"https://plan.example/x"}, "target": {"https://plan.example/x"}},
+	}
+	for _, form := range cases {
+		resp, err := http.PostForm(srv.URL, form)
+		if err != nil {
+			t.Fatalf("PostForm failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v: status = %d, want 400", form, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestSentState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "sent.json")
+	s, err := LoadSentState(path)
+	if err != nil {
+		t.Fatalf("LoadSentState failed: %v", err)
+	}
+	if !s.IsNew() {
+		t.Errorf("Expected new state")
+	}
+	s.MarkSent("https://a.example/1", "https://b.example/", time.Now())
+	s.Sources["https://a.example/1"] = "abc"
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	s, err = LoadSentState(path)
+	if err != nil {
+		t.Fatalf("LoadSentState failed: %v", err)
+	}
+	if !s.WasSent("https://a.example/1", "https://b.example/") {
+		t.Errorf("Expected mention to be recorded as sent")
+	}
+	if s.WasSent("https://a.example/1", "https://c.example/") || s.IsNew() {
+		t.Errorf("Unexpected state: %+v", s)
+	}
+	if !strings.Contains(s.Sources["https://a.example/1"], "abc") {
+		t.Errorf("Source commit not persisted")
+	}
+}
@@ -0,0 +1,522 @@
+// Package websub implements notifying WebSub hubs of new content, and a
+// small hub of its own (https://www.w3.org/TR/websub/).
+package websub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/publicnet"
+)
+
+// maxBody limits how much of a topic or a response is read.
+const maxBody = 10 << 20
+
+// Publish tells hub that topic has new content, so it is delivered to the
+// topic's subscribers.
+func Publish(ctx context.Context, client *http.Client, hub, topic string) error {
+	form := url.Values{"hub.mode": {"publish"}, "hub.url": {topic}, "hub.topic": {topic}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("hub %s: %s: %s", hub, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Subscription is a verified request to have a topic delivered to a
+// callback until it expires.
+type Subscription struct {
+	Topic    string    `json:"topic"`
+	Callback string    `json:"callback"`
+	Secret   string    `json:"secret,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Store persists subscriptions as a JSON file.
+type Store struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Load returns all stored subscriptions. A missing file is not an error.
+func (s *Store) Load() ([]Subscription, error) {
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var subs []Subscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.Path, err)
+	}
+	return subs, nil
+}
+
+// Put adds sub, replacing any earlier subscription of its callback to its
+// topic.
+func (s *Store) Put(sub Subscription) error {
+	return s.update(func(subs []Subscription) []Subscription {
+		for i, old := range subs {
+			if old.Topic == sub.Topic && old.Callback == sub.Callback {
+				subs[i] = sub
+				return subs
+			}
+		}
+		return append(subs, sub)
+	})
+}
+
+// Delete removes the subscription of callback to topic, if any.
+func (s *Store) Delete(topic, callback string) error {
+	return s.update(func(subs []Subscription) []Subscription {
+		out := subs[:0]
+		for _, sub := range subs {
+			if sub.Topic != topic || sub.Callback != callback {
+				out = append(out, sub)
+			}
+		}
+		return out
+	})
+}
+
+func (s *Store) update(fn func([]Subscription) []Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs, err := s.Load()
+	if err != nil {
+		return err
+	}
+	subs = fn(subs)
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Topic != subs[j].Topic {
+			return subs[i].Topic < subs[j].Topic
+		}
+		return subs[i].Callback < subs[j].Callback
+	})
+
+	data, err := json.MarshalIndent(subs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// Hub is an http.Handler implementing a WebSub hub for the topics of a site.
+// Subscription requests are answered at once and their intent verified with
+// the subscriber afterwards; a publish request fetches the topic and
+// delivers it to every subscriber. Both wait in a bounded queue, where
+// repeated publishes of a topic are merged, and are handled one at a time.
+type Hub struct {
+	// BaseURL is the site's public URL. Only topics under it are accepted.
+	BaseURL string
+	// URL is the hub's own public URL, sent with every delivery.
+	URL    string
+	Store  *Store
+	Client *http.Client
+	// Lease is the longest a subscription lasts before it must be renewed,
+	// and how long it lasts when the subscriber does not ask; ten days by
+	// default.
+	Lease time.Duration
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+	// Queue is how many requests may wait to be handled before new ones
+	// are turned away; it defaults to 100.
+	Queue int
+	// AllowPrivate accepts callbacks on private and loopback addresses,
+	// which are otherwise refused so that a subscription cannot make the
+	// hub reach into its own network.
+	AllowPrivate bool
+
+	once      sync.Once
+	pending   chan hubTask
+	mu        sync.Mutex
+	publishes map[string]bool // the topics with a publish waiting
+	callbacks *http.Client
+	wg        sync.WaitGroup
+}
+
+// hubTask is a request waiting to be handled: a publish of topic, or the
+// verification of a subscribe or unsubscribe.
+type hubTask struct {
+	mode  string
+	topic string
+	sub   Subscription
+}
+
+const defaultLease = 10 * 24 * time.Hour
+
+var errUnsupportedTopic = errors.New("topic is not on this site")
+
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var task hubTask
+	switch mode := r.PostFormValue("hub.mode"); mode {
+	case "subscribe", "unsubscribe":
+		sub, err := h.subscription(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		task = hubTask{mode: mode, sub: sub}
+	case "publish":
+		topic := r.PostFormValue("hub.url")
+		if topic == "" {
+			topic = r.PostFormValue("hub.topic")
+		}
+		if err := h.checkTopic(topic); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		task = hubTask{mode: mode, topic: topic}
+	default:
+		http.Error(w, fmt.Sprintf("unsupported hub.mode %q", mode), http.StatusBadRequest)
+		return
+	}
+	if !h.enqueue(task) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many requests waiting", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Wait waits for the verifications and deliveries accepted so far to
+// finish.
+func (h *Hub) Wait() {
+	h.wg.Wait()
+}
+
+// enqueue adds task to the queue, and reports whether there was room. A
+// publish of a topic that is already waiting is merged with it.
+func (h *Hub) enqueue(task hubTask) bool {
+	h.once.Do(h.start)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if task.mode == "publish" && h.publishes[task.topic] {
+		return true
+	}
+	h.wg.Add(1)
+	select {
+	case h.pending <- task:
+	default:
+		h.wg.Done()
+		return false
+	}
+	if task.mode == "publish" {
+		h.publishes[task.topic] = true
+	}
+	return true
+}
+
+// start sets up the queue and the worker that drains it.
+func (h *Hub) start() {
+	size := h.Queue
+	if size <= 0 {
+		size = 100
+	}
+	h.pending = make(chan hubTask, size)
+	h.publishes = make(map[string]bool)
+	h.callbacks = h.client()
+	if !h.AllowPrivate {
+		h.callbacks = publicnet.Client(h.callbacks)
+	}
+	go func() {
+		for task := range h.pending {
+			h.handle(task)
+			h.wg.Done()
+		}
+	}()
+}
+
+func (h *Hub) handle(task hubTask) {
+	if task.mode != "publish" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := h.verify(ctx, h.callbacks, task.mode, task.sub); err != nil {
+			log.Printf("websub: %s %s to %s: %v", task.mode, task.sub.Callback, task.sub.Topic, err)
+		}
+		return
+	}
+	// A publish from now on needs another delivery.
+	h.mu.Lock()
+	delete(h.publishes, task.topic)
+	h.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if _, err := h.Distribute(ctx, task.topic); err != nil {
+		log.Printf("websub: distributing %s: %v", task.topic, err)
+	}
+}
+
+// subscription reads a subscribe or unsubscribe request.
+func (h *Hub) subscription(r *http.Request) (Subscription, error) {
+	sub := Subscription{
+		Topic:    r.PostFormValue("hub.topic"),
+		Callback: r.PostFormValue("hub.callback"),
+		Secret:   r.PostFormValue("hub.secret"),
+	}
+	if err := h.checkTopic(sub.Topic); err != nil {
+		return Subscription{}, err
+	}
+	u, err := url.Parse(sub.Callback)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, fmt.Errorf("invalid hub.callback %q", sub.Callback)
+	}
+	if !h.AllowPrivate {
+		if err := publicnet.CheckURL(u); err != nil {
+			return Subscription{}, fmt.Errorf("hub.callback: %w", err)
+		}
+	}
+	if len(sub.Secret) >= 200 {
+		return Subscription{}, errors.New("hub.secret must be less than 200 bytes")
+	}
+
+	lease := h.lease()
+	if s := r.PostFormValue("hub.lease_seconds"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return Subscription{}, fmt.Errorf("invalid hub.lease_seconds %q", s)
+		}
+		lease = min(lease, time.Duration(n)*time.Second)
+	}
+	sub.Expires = h.now().Add(lease).UTC().Truncate(time.Second)
+	return sub, nil
+}
+
+func (h *Hub) checkTopic(topic string) error {
+	u, err := url.Parse(topic)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid topic URL %q", topic)
+	}
+	base := strings.TrimSuffix(h.BaseURL, "/")
+	if topic != base && !strings.HasPrefix(topic, base+"/") {
+		return errUnsupportedTopic
+	}
+	return nil
+}
+
+// Verify confirms with the subscriber that it asked to subscribe or
+// unsubscribe, by having it echo a challenge, and then stores or removes
+// the subscription.
+func (h *Hub) Verify(ctx context.Context, mode string, sub Subscription) error {
+	client := h.client()
+	if !h.AllowPrivate {
+		client = publicnet.Client(client)
+	}
+	return h.verify(ctx, client, mode, sub)
+}
+
+func (h *Hub) verify(ctx context.Context, client *http.Client, mode string, sub Subscription) error {
+	challenge, err := newChallenge()
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(sub.Callback)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("hub.mode", mode)
+	q.Set("hub.topic", sub.Topic)
+	q.Set("hub.challenge", challenge)
+	if mode == "subscribe" {
+		q.Set("hub.lease_seconds", strconv.Itoa(int(sub.Expires.Sub(h.now()).Seconds())))
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || strings.TrimSpace(string(body)) != challenge {
+		return fmt.Errorf("subscriber did not confirm (%s)", resp.Status)
+	}
+
+	if mode == "unsubscribe" {
+		return h.Store.Delete(sub.Topic, sub.Callback)
+	}
+	return h.Store.Put(sub)
+}
+
+// Distribute fetches topic and delivers it to each of its subscribers,
+// returning how many received it. Expired subscriptions, and those whose
+// subscriber answers 410 Gone, are removed.
+func (h *Hub) Distribute(ctx context.Context, topic string) (int, error) {
+	subs, err := h.Store.Load()
+	if err != nil {
+		return 0, err
+	}
+	var current []Subscription
+	for _, sub := range subs {
+		switch {
+		case sub.Topic != topic:
+		case !sub.Expires.After(h.now()):
+			if err := h.Store.Delete(sub.Topic, sub.Callback); err != nil {
+				log.Printf("websub: removing expired %s: %v", sub.Callback, err)
+			}
+		default:
+			current = append(current, sub)
+		}
+	}
+	if len(current) == 0 {
+		return 0, nil
+	}
+
+	content, contentType, err := h.fetch(ctx, topic)
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, sub := range current {
+		gone, err := h.deliver(ctx, sub, content, contentType)
+		switch {
+		case gone:
+			if err := h.Store.Delete(sub.Topic, sub.Callback); err != nil {
+				log.Printf("websub: removing %s: %v", sub.Callback, err)
+			}
+		case err != nil:
+			log.Printf("websub: delivering %s to %s: %v", topic, sub.Callback, err)
+		default:
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+func (h *Hub) fetch(ctx context.Context, topic string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, topic, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, "", fmt.Errorf("GET %s: %s", topic, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, "", err
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// deliver posts content to the subscriber of sub, signed with its secret,
+// and reports whether the subscriber said it is gone.
+func (h *Hub) deliver(ctx context.Context, sub Subscription, content []byte, contentType string) (gone bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Callback, bytes.NewReader(content))
+	if err != nil {
+		return false, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, h.URL))
+	req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="self"`, sub.Topic))
+	if sub.Secret != "" {
+		req.Header.Set("X-Hub-Signature", "sha256="+Sign(sub.Secret, content))
+	}
+	h.once.Do(h.start)
+	resp, err := h.callbacks.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return true, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Errorf("subscriber answered %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of content with secret, as sent in the
+// X-Hub-Signature header of a delivery.
+func Sign(secret string, content []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newChallenge() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (h *Hub) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return http.DefaultClient
+}
+
+func (h *Hub) lease() time.Duration {
+	if h.Lease > 0 {
+		return h.Lease
+	}
+	return defaultLease
+}
+
+func (h *Hub) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,228 @@
+package websub
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// subscriber is a local WebSub subscriber that confirms every request and
+// records the deliveries it receives.
+type subscriber struct {
+	mu         sync.Mutex
+	deliveries []*http.Request
+	bodies     []string
+	gone       bool
+}
+
+func (s *subscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		io.WriteString(w, r.URL.Query().Get("hub.challenge"))
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = append(s.deliveries, r)
+	s.bodies = append(s.bodies, string(body))
+	if s.gone {
+		w.WriteHeader(http.StatusGone)
+	}
+}
+
+func TestHub(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		io.WriteString(w, "<rss>new day</rss>")
+	}))
+	defer site.Close()
+	sub := &subscriber{}
+	callback := httptest.NewServer(sub)
+	defer callback.Close()
+
+	store := &Store{Path: filepath.Join(t.TempDir(), "websub.json")}
+	hub := &Hub{BaseURL: site.URL, URL: "https://hub.example/", Store: store, AllowPrivate: true}
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	topic := site.URL + "/rss.xml"
+	post := func(form url.Values) *http.Response {
+		t.Helper()
+		resp, err := http.PostForm(srv.URL, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		hub.Wait()
+		return resp
+	}
+
+	resp := post(url.Values{"hub.mode": {"subscribe"}, "hub.topic": {topic}, "hub.callback": {callback.URL + "/cb?id=1"}, "hub.secret": {"s3cret"}})
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("subscribe status = %d, want 202", resp.StatusCode)
+	}
+	subs, err := store.Load()
+	if err != nil || len(subs) != 1 || subs[0].Callback != callback.URL+"/cb?id=1" {
+		t.Fatalf("subscriptions = %+v, %v", subs, err)
+	}
+	if lease := time.Until(subs[0].Expires); lease < 9*24*time.Hour {
+		t.Errorf("lease = %v, want the default", lease)
+	}
+
+	if resp := post(url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"https://elsewhere.example/rss.xml"}, "hub.callback": {callback.URL}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("subscribe to another site status = %d, want 400", resp.StatusCode)
+	}
+
+	if err := Publish(context.Background(), http.DefaultClient, srv.URL, topic); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	hub.Wait()
+	sub.mu.Lock()
+	if len(sub.deliveries) != 1 || sub.bodies[0] != "<rss>new day</rss>" {
+		t.Fatalf("deliveries = %q", sub.bodies)
+	}
+	d := sub.deliveries[0]
+	if got := d.Header.Get("X-Hub-Signature"); got != "sha256="+Sign("s3cret", []byte("<rss>new day</rss>")) {
+		t.Errorf("signature = %q", got)
+	}
+	if links := strings.Join(d.Header.Values("Link"), ", "); !strings.Contains(links, `<https://hub.example/>; rel="hub"`) || !strings.Contains(links, `<`+topic+`>; rel="self"`) {
+		t.Errorf("Link = %q", links)
+	}
+	if d.Header.Get("Content-Type") != "application/rss+xml" {
+		t.Errorf("Content-Type = %q", d.Header.Get("Content-Type"))
+	}
+	sub.gone = true
+	sub.mu.Unlock()
+
+	// A subscriber that is gone is removed on the next delivery.
+	if n, err := hub.Distribute(context.Background(), topic); err != nil || n != 0 {
+		t.Errorf("Distribute = %d, %v", n, err)
+	}
+	if subs, _ := store.Load(); len(subs) != 0 {
+		t.Errorf("subscriptions after 410 = %+v", subs)
+	}
+}
+
+func TestHub_Unsubscribe(t *testing.T) {
+	callback := httptest.NewServer(&subscriber{})
+	defer callback.Close()
+	store := &Store{Path: filepath.Join(t.TempDir(), "websub.json")}
+	hub := &Hub{BaseURL: "https://plan.example", Store: store, AllowPrivate: true}
+	topic := "https://plan.example/rss.xml"
+
+	ctx := context.Background()
+	if err := hub.Verify(ctx, "subscribe", Subscription{Topic: topic, Callback: callback.URL, Expires: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Verify(ctx, "unsubscribe", Subscription{Topic: topic, Callback: callback.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if subs, _ := store.Load(); len(subs) != 0 {
+		t.Errorf("subscriptions = %+v, want none", subs)
+	}
+
+	// A subscriber that does not echo the challenge did not ask.
+	deny := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer deny.Close()
+	if err := hub.Verify(ctx, "subscribe", Subscription{Topic: topic, Callback: deny.URL}); err == nil {
+		t.Error("Verify accepted a subscriber that did not confirm")
+	}
+}
+
+func TestHub_Queue(t *testing.T) {
+	fetching := make(chan bool)
+	release := make(chan bool)
+	var mu sync.Mutex
+	fetches := 0
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		first := fetches == 1
+		mu.Unlock()
+		if first {
+			fetching <- true
+			<-release
+		}
+		io.WriteString(w, "<rss></rss>")
+	}))
+	defer site.Close()
+	callback := httptest.NewServer(&subscriber{})
+	defer callback.Close()
+
+	store := &Store{Path: filepath.Join(t.TempDir(), "websub.json")}
+	topic := site.URL + "/rss.xml"
+	if err := store.Put(Subscription{Topic: topic, Callback: callback.URL, Expires: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	hub := &Hub{BaseURL: site.URL, Store: store, Queue: 1, AllowPrivate: true}
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+	post := func(form url.Values) int {
+		t.Helper()
+		resp, err := http.PostForm(srv.URL, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	publish := url.Values{"hub.mode": {"publish"}, "hub.url": {topic}}
+
+	// While the first publish is being delivered, the next ones are merged
+	// into one, and requests beyond the queue are turned away.
+	if code := post(publish); code != http.StatusAccepted {
+		t.Fatalf("publish status = %d, want 202", code)
+	}
+	<-fetching
+	for range 5 {
+		if code := post(publish); code != http.StatusAccepted {
+			t.Errorf("repeated publish status = %d, want 202", code)
+		}
+	}
+	if code := post(url.Values{"hub.mode": {"subscribe"}, "hub.topic": {topic}, "hub.callback": {callback.URL + "/other"}}); code != http.StatusServiceUnavailable {
+		t.Errorf("subscribe with a full queue status = %d, want 503", code)
+	}
+	close(release)
+	hub.Wait()
+	if fetches != 2 {
+		t.Errorf("topic fetched %d times, want 2", fetches)
+	}
+}
+
+func TestHub_PrivateCallback(t *testing.T) {
+	callback := httptest.NewServer(&subscriber{})
+	defer callback.Close()
+	store := &Store{Path: filepath.Join(t.TempDir(), "websub.json")}
+	hub := &Hub{BaseURL: "https://plan.example", Store: store}
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	topic := "https://plan.example/rss.xml"
+	for _, cb := range []string{callback.URL, "http://localhost:9/cb", "http://169.254.169.254/latest"} {
+		resp, err := http.PostForm(srv.URL, url.Values{"hub.mode": {"subscribe"}, "hub.topic": {topic}, "hub.callback": {cb}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("subscribe %s status = %d, want 400", cb, resp.StatusCode)
+		}
+	}
+	// Verify checks the address it connects to, too.
+	err := hub.Verify(context.Background(), "subscribe", Subscription{Topic: topic, Callback: callback.URL, Expires: time.Now().Add(time.Hour)})
+	if err == nil {
+		t.Error("Verify reached a callback on a private address")
+	}
+	if subs, _ := store.Load(); len(subs) != 0 {
+		t.Errorf("subscriptions = %+v, want none", subs)
+	}
+}
@@ -0,0 +1,471 @@
+package plan
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+// Build builds the whole site: the current page, the assets, the page of
+// every day in the history with its indexes, the feed and the 404 page. A
+// history that cannot be read is a warning, so a plan with no commits yet
+// still builds, but one that cannot be built is an error.
+func (b *Builder) Build() (*Result, error) {
+	res := &Result{}
+	page, err := b.BuildIndex()
+	if err != nil {
+		return nil, err
+	}
+	res.Pages = append(res.Pages, page)
+
+	assets, err := b.CopyAssets()
+	if err != nil {
+		b.warn("Failed to copy assets: %v", err)
+	}
+	res.Pages = append(res.Pages, assets...)
+
+	if err := b.loadHistory(); err != nil {
+		b.warn("Failed to read history (is this a git repo with commits?): %v", err)
+	} else {
+		pages, items, err := b.BuildHistory()
+		if err != nil {
+			return nil, fmt.Errorf("building history: %w", err)
+		}
+		res.Pages = append(res.Pages, pages...)
+		res.Items = items
+	}
+
+	feeds, err := b.WriteFeed(res.Items)
+	if err != nil {
+		return nil, err
+	}
+	res.Pages = append(res.Pages, feeds...)
+
+	page, err = b.BuildNotFound()
+	if err != nil {
+		b.warn("Failed to generate 404 page: %v", err)
+	} else {
+		res.Pages = append(res.Pages, page)
+	}
+
+	res.NextPublish = b.next
+	res.Warnings, b.warnings = b.warnings, nil
+	return res, nil
+}
+
+// BuildIndex renders the working copy of the plan to index.html.
+func (b *Builder) BuildIndex() (Page, error) {
+	p := filepath.Join(b.dir, b.file)
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return Page{}, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return Page{}, err
+	}
+	modTime := info.ModTime()
+	// A plan last committed as private or on a retracted day shows its
+	// newest published version instead until it is changed again; one last
+	// committed under embargo, until the embargo is over.
+	if v, ok := b.unpublishedHead(content); ok {
+		if err := b.loadHistory(); err != nil {
+			return Page{}, err
+		}
+		content, modTime = nil, v.Time
+		if len(b.versions) > 0 {
+			if content, err = b.history.Content(b.versions[0]); err != nil {
+				return Page{}, err
+			}
+			modTime = b.versions[0].Time
+		}
+	}
+	page, err := b.writePage(Page{Path: "index.html", Kind: KindIndex}, content, modTime)
+	if err != nil {
+		return Page{}, fmt.Errorf("%s: %w", b.file, err)
+	}
+	return page, nil
+}
+
+// unpublishedHead returns the newest version of the plan if it is not
+// published and content should not be either: content is the plan as of a
+// private or retracted version, or anything at all after an embargoed one,
+// which it was edited from. Only the newest version is read, so that
+// rebuilding the index alone stays quick.
+func (b *Builder) unpublishedHead(content []byte) (Version, bool) {
+	head, err := b.newest()
+	if err != nil || head.Hash == "" {
+		return Version{}, false
+	}
+	if b.held(head) {
+		return head, true
+	}
+	if _, retracted := b.retractions[head.Date]; !isPrivate(head) && !retracted {
+		return Version{}, false
+	}
+	c, err := b.history.Content(head)
+	return head, err == nil && bytes.Equal(c, content)
+}
+
+// BuildDay renders the page of the day date, as 2006-01-02, with the
+// mentions it received, or the note left on it if it was retracted. It
+// returns ErrNoVersion if the plan has no published version on that day.
+func (b *Builder) BuildDay(date string) (Page, error) {
+	if err := b.loadHistory(); err != nil {
+		return Page{}, err
+	}
+	v, ok := b.days[date]
+	if !ok {
+		for _, r := range b.retracted {
+			if r.Date == date && b.retractions[date].Note != "" {
+				return b.writeRetracted(r)
+			}
+		}
+		return Page{}, fmt.Errorf("%s: %w", date, ErrNoVersion)
+	}
+	content, err := b.history.Content(v)
+	if err != nil {
+		return Page{}, fmt.Errorf("reading %s: %w", date, err)
+	}
+	return b.writeDay(v, content)
+}
+
+// writeRetracted writes the note left on the retracted day of v in place of
+// its page.
+func (b *Builder) writeRetracted(v Version) (Page, error) {
+	note := "*This day was retracted.*\n\n" + b.retractions[v.Date].Note + "\n"
+	name := path.Join(DayPath(v.Time)[1:], "index.html")
+	return b.writePage(Page{Path: name, Kind: KindRetracted, Date: v.Date}, []byte(note), v.Time)
+}
+
+func (b *Builder) writeDay(v Version, content []byte) (Page, error) {
+	// Received mentions are shown under the day, but not in its feed item.
+	page := append([]byte(nil), content...)
+	page = append(page, mentionsHTML(b.mentions[v.Date])...)
+	name := path.Join(DayPath(v.Time)[1:], "index.html")
+	return b.writePage(Page{Path: name, Kind: KindDay, Date: v.Date, Commit: v.Hash}, page, v.Time)
+}
+
+// BuildHistory renders the page of every day in the history and the history
+// indexes, and returns the feed items of the days, newest first. A day whose
+// content cannot be read is skipped with a warning. Retracted days are left
+// out, but for the notes left on them.
+func (b *Builder) BuildHistory() ([]Page, []Item, error) {
+	if err := b.loadHistory(); err != nil {
+		return nil, nil, err
+	}
+
+	// Feed items may be compared with the day before, so read every day
+	// first.
+	contents := make([][]byte, len(b.versions))
+	read := make([]bool, len(b.versions))
+	for i, v := range b.versions {
+		content, err := b.history.Content(v)
+		if err != nil {
+			b.warn("Failed to get content for %s: %v", v.Date, err)
+			continue
+		}
+		contents[i], read[i] = content, true
+	}
+
+	var pages []Page
+	var items []Item
+	r := render.New(&b.cfg, b.tmpl, false, "")
+	for i, v := range b.versions {
+		if !read[i] {
+			continue
+		}
+		page, err := b.writeDay(v, contents[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", v.Date, err)
+		}
+		pages = append(pages, page)
+
+		var prev []byte
+		if j := slices.Index(read[i+1:], true); j >= 0 {
+			prev = contents[i+1+j]
+		}
+		desc, content, err := b.feedBody(r, contents[i], prev)
+		if err != nil {
+			b.warn("Failed to render the feed item for %s: %v", v.Date, err)
+			continue
+		}
+		link := b.cfg.BaseURL + b.basePath + DayPath(v.Time)
+		items = append(items, Item{
+			Title:       v.Date,
+			Link:        link,
+			Description: desc,
+			Content:     content,
+			PubDate:     v.Time.Format(time.RFC1123Z),
+			Guid:        link,
+		})
+	}
+
+	for _, v := range b.retracted {
+		if b.retractions[v.Date].Note == "" {
+			continue
+		}
+		page, err := b.writeRetracted(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	indexes, err := b.BuildIndexes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(pages, indexes...), items, nil
+}
+
+// BuildIndexes renders the year, month and archives pages. Each is dated by
+// the newest day it lists, so they only change when the history does.
+func (b *Builder) BuildIndexes() ([]Page, error) {
+	if err := b.loadHistory(); err != nil {
+		return nil, err
+	}
+
+	// The versions are newest first, so every list below is too.
+	newest := b.newestCommit()
+	var years []string
+	months := make(map[string][]string)
+	days := make(map[string][]Version)
+	for _, v := range b.versions {
+		year, month := v.Date[:4], v.Date[:7]
+		if len(months[year]) == 0 {
+			years = append(years, year)
+		}
+		if len(days[month]) == 0 {
+			months[year] = append(months[year], month)
+		}
+		days[month] = append(days[month], v)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(years)))
+
+	dayLinks := func(buf *bytes.Buffer, vs []Version) {
+		for _, v := range vs {
+			fmt.Fprintf(buf, "- [%s](%s)\n", v.Date, b.basePath+DayPath(v.Time))
+		}
+	}
+
+	var pages []Page
+	var archives bytes.Buffer
+	archives.WriteString("# Archives\n\n")
+	for _, year := range years {
+		var yearDays []Version
+		for _, month := range months[year] {
+			var content bytes.Buffer
+			monthName := month[5:]
+			if t, err := time.Parse("2006-01", month); err == nil {
+				monthName = t.Format("January")
+			}
+			fmt.Fprintf(&content, "# History for %s %s\n\n", monthName, year)
+			dayLinks(&content, days[month])
+			page, err := b.writePage(Page{Path: path.Join(year, month[5:], "index.html"), Kind: KindMonth, Commit: newest}, content.Bytes(), days[month][0].Time)
+			if err != nil {
+				return nil, err
+			}
+			pages = append(pages, page)
+			yearDays = append(yearDays, days[month]...)
+		}
+		sort.SliceStable(yearDays, func(i, j int) bool {
+			return yearDays[i].Date > yearDays[j].Date
+		})
+
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "# History for %s\n\n", year)
+		dayLinks(&content, yearDays)
+		page, err := b.writePage(Page{Path: path.Join(year, "index.html"), Kind: KindYear, Commit: newest}, content.Bytes(), yearDays[0].Time)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+
+		fmt.Fprintf(&archives, "## %s\n\n", year)
+		dayLinks(&archives, yearDays)
+		archives.WriteString("\n")
+	}
+
+	updated := b.now()
+	if len(b.versions) > 0 {
+		updated = b.versions[0].Time
+	}
+	page, err := b.writePage(Page{Path: "archives/index.html", Kind: KindArchives, Commit: newest}, archives.Bytes(), updated)
+	if err != nil {
+		return nil, err
+	}
+	return append(pages, page), nil
+}
+
+// newestCommit returns the hash of the newest version, if the history has
+// been read and has one.
+func (b *Builder) newestCommit() string {
+	if len(b.versions) == 0 {
+		return ""
+	}
+	return b.versions[0].Hash
+}
+
+// BuildNotFound writes the 404 page, dated like the current page.
+func (b *Builder) BuildNotFound() (Page, error) {
+	updated := b.now()
+	if info, err := os.Stat(filepath.Join(b.dir, b.file)); err == nil {
+		updated = info.ModTime()
+	}
+	return b.writePage(Page{Path: "404.html", Kind: KindNotFound}, []byte("Not found."), updated)
+}
+
+// WritePage renders markdown in the page template to name, such as
+// "debug/index.html".
+func (b *Builder) WritePage(name string, markdown []byte, modTime time.Time) (Page, error) {
+	return b.writePage(Page{Path: path.Clean(name), Kind: KindPage}, markdown, modTime)
+}
+
+func (b *Builder) writePage(page Page, content []byte, modTime time.Time) (Page, error) {
+	r := render.New(&b.cfg, b.tmpl, b.liveReload, assetPrefix(page.Path))
+	r.ShowPrivate = b.showPrivate
+	if b.cfg.BaseURL != "" {
+		r.SelfURL = b.cfg.BaseURL + b.basePath + "/" + strings.TrimSuffix(page.Path, "index.html")
+	}
+
+	body, err := r.RenderBody(content)
+	if err != nil {
+		return Page{}, fmt.Errorf("rendering body: %w", err)
+	}
+
+	html, err := r.Compose(body, b.createdTime(), modTime)
+	if err != nil {
+		return Page{}, fmt.Errorf("composing html: %w", err)
+	}
+
+	page, err = b.WriteFile(page, html)
+	if err != nil {
+		return Page{}, fmt.Errorf("writing file %s: %w", page.Path, err)
+	}
+	return page, nil
+}
+
+// WriteFile writes data to page.Path in the output, unless the file there
+// already has that content, and returns page with its size, hash and content
+// type filled in.
+func (b *Builder) WriteFile(page Page, data []byte) (Page, error) {
+	if old, err := b.out.ReadFile(page.Path); err != nil || !bytes.Equal(old, data) {
+		if err := b.out.WriteFile(page.Path, data); err != nil {
+			return Page{}, err
+		}
+	}
+	sum := sha256.Sum256(data)
+	page.Size = len(data)
+	page.SHA256 = hex.EncodeToString(sum[:])
+	page.ContentType = contentType(page.Path)
+	return page, nil
+}
+
+func contentType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// CopyAssets copies the plan's assets directory, if it has one, to assets/
+// in the output. Only files that changed are written; Prune removes the ones
+// deleted since the last build.
+func (b *Builder) CopyAssets() ([]Page, error) {
+	if info, err := os.Stat(filepath.Join(b.dir, "assets")); err != nil || !info.IsDir() {
+		return nil, nil
+	}
+	return b.SyncAsset(".")
+}
+
+// SyncAsset copies the asset name, a path relative to the assets directory,
+// to the output, along with everything in it if it is a directory. An asset
+// that no longer exists is removed from the output.
+func (b *Builder) SyncAsset(name string) ([]Page, error) {
+	src := filepath.Join(b.dir, "assets", filepath.FromSlash(name))
+	dst := path.Join("assets", filepath.ToSlash(name))
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return nil, b.out.Remove(dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+		page, err := b.WriteFile(Page{Path: dst, Kind: KindAsset}, data)
+		if err != nil {
+			return nil, err
+		}
+		return []Page{page}, nil
+	}
+
+	var pages []Page
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		copied, err := b.SyncAsset(path.Join(filepath.ToSlash(name), filepath.ToSlash(rel)))
+		pages = append(pages, copied...)
+		return err
+	})
+	return pages, err
+}
+
+// Prune removes the files of a previous build, as listed in its manifest,
+// that res did not write again, such as the page of a day that is no longer
+// in the history or a deleted asset. With all, it removes every file in the
+// output that res did not write, except the manifest. It returns the names
+// of the files removed.
+func (b *Builder) Prune(prev *Manifest, res *Result, all bool) ([]string, error) {
+	keep := map[string]bool{ManifestFile: true}
+	for _, p := range res.Pages {
+		keep[p.Path] = true
+	}
+
+	var candidates []string
+	if all {
+		files, err := b.out.Files()
+		if err != nil {
+			return nil, err
+		}
+		candidates = files
+	} else if prev != nil {
+		for _, p := range prev.Files {
+			candidates = append(candidates, p.Path)
+		}
+	}
+
+	var removed []string
+	for _, name := range candidates {
+		// A manifest only ever lists names inside the output.
+		if keep[name] || !fs.ValidPath(name) {
+			continue
+		}
+		if err := b.out.Remove(name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
@@ -0,0 +1,300 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+// Feed is an RSS feed.
+type Feed struct {
+	Title       string
+	Link        string
+	Description string
+	Items       []Item // newest first
+	// Links are the RFC 5005 links to the other documents of an archived
+	// feed, such as "prev-archive".
+	Links []FeedLink
+	// Archive marks the feed as an RFC 5005 archive document, whose items
+	// do not change.
+	Archive bool
+}
+
+// FeedLink is a link from a feed to a related document.
+type FeedLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// Item is an entry in a feed: the plan as it was on a day.
+type Item struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Content     string `xml:"content:encoded,omitempty"`
+	PubDate     string `xml:"pubDate"`
+	Guid        string `xml:"guid"`
+}
+
+type rss struct {
+	XMLName xml.Name `xml:"rss"`
+
+	Version string `xml:"version,attr"`
+
+	ContentNs string `xml:"xmlns:content,attr"`
+	AtomNs    string `xml:"xmlns:atom,attr,omitempty"`
+	HistoryNs string `xml:"xmlns:fh,attr,omitempty"`
+
+	Channel channel `xml:"channel"`
+}
+
+type channel struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description"`
+	Links       []FeedLink `xml:"atom:link"`
+	Archive     *struct{}  `xml:"fh:archive"`
+	Items       []Item     `xml:"item"`
+}
+
+// XML encodes the feed as an RSS 2.0 document.
+func (f Feed) XML() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	doc := rss{
+		Version:   "2.0",
+		ContentNs: "http://purl.org/rss/1.0/modules/content/",
+		Channel: channel{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: f.Description,
+			Links:       f.Links,
+			Items:       f.Items,
+		},
+	}
+	if len(f.Links) > 0 {
+		doc.AtomNs = "http://www.w3.org/2005/Atom"
+	}
+	if f.Archive {
+		doc.HistoryNs = "http://purl.org/syndication/history/1.0"
+		doc.Channel.Archive = &struct{}{}
+	}
+	err := enc.Encode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("encoding RSS: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// FeedArchiveDir is where the archive documents of a capped feed are
+// written, numbered from 1.xml for the oldest items.
+const FeedArchiveDir = "feed"
+
+// noChanges stands in for a day's changes when nothing was added or changed.
+const noChanges = "*Nothing was added or changed since the previous day.*\n"
+
+// WriteFeed writes rss.xml with items, newest first, as WriteFeeds does.
+func (b *Builder) WriteFeed(items []Item) ([]Page, error) {
+	return b.WriteFeeds(Feed{
+		Title:       b.cfg.Title,
+		Link:        b.cfg.BaseURL + b.basePath,
+		Description: fmt.Sprintf("Updates for %s", b.cfg.Title),
+		Items:       items,
+	})
+}
+
+// WriteFeeds writes f as rss.xml. With feed.max_items set, rss.xml holds
+// only the newest items, and every full run of that many items, oldest
+// first, is kept in an archive document under FeedArchiveDir that does not
+// change once written. The documents are linked as RFC 5005 describes, and
+// rss.xml to the WebSub hub, if there is one.
+func (b *Builder) WriteFeeds(f Feed) ([]Page, error) {
+	base := strings.TrimSuffix(b.cfg.BaseURL+b.basePath, "/")
+	// rss.xml names its WebSub hub, and itself as the topic.
+	var hub []FeedLink
+	if b.cfg.WebSubHub != "" {
+		hub = []FeedLink{{Rel: "hub", Href: b.cfg.WebSubHub}, {Rel: "self", Href: base + "/rss.xml"}}
+	}
+
+	n := b.cfg.Feed.MaxItems
+	if n <= 0 || len(f.Items) <= n {
+		f.Links = append(slices.Clone(f.Links), hub...)
+		page, err := b.writeFeed("rss.xml", f)
+		if err != nil {
+			return nil, err
+		}
+		return []Page{page}, nil
+	}
+
+	archive := func(i int) string { return fmt.Sprintf("%s/%d.xml", FeedArchiveDir, i) }
+	oldest := slices.Clone(f.Items)
+	slices.Reverse(oldest)
+	count := len(oldest) / n
+
+	var pages []Page
+	for i := 1; i <= count; i++ {
+		a := f
+		a.Items = slices.Clone(oldest[(i-1)*n : i*n])
+		slices.Reverse(a.Items)
+		a.Archive = true
+		a.Links = []FeedLink{{Rel: "current", Href: base + "/rss.xml"}}
+		if i > 1 {
+			a.Links = append(a.Links, FeedLink{Rel: "prev-archive", Href: base + "/" + archive(i-1)})
+		}
+		if i < count {
+			a.Links = append(a.Links, FeedLink{Rel: "next-archive", Href: base + "/" + archive(i+1)})
+		}
+		page, err := b.writeFeed(archive(i), a)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	// The newest items may also be in the newest archive, which RFC 5005
+	// allows; the archives never hold a partial run.
+	cur := f
+	cur.Items = f.Items[:n]
+	cur.Links = append([]FeedLink{{Rel: "prev-archive", Href: base + "/" + archive(count)}}, hub...)
+	page, err := b.writeFeed("rss.xml", cur)
+	if err != nil {
+		return nil, err
+	}
+	return append(pages, page), nil
+}
+
+func (b *Builder) writeFeed(name string, f Feed) (Page, error) {
+	data, err := f.XML()
+	if err != nil {
+		return Page{}, err
+	}
+	page, err := b.WriteFile(Page{Path: name, Kind: KindFeed, Commit: b.newestCommit()}, data)
+	if err != nil {
+		return Page{}, fmt.Errorf("writing RSS: %w", err)
+	}
+	return page, nil
+}
+
+// feedBody returns the description and content of the feed item for a day
+// whose plan is content, as feed.mode asks. prev is the plan of the day
+// before, or nil for the first day, which is new in every mode. A summary
+// has no content of its own.
+func (b *Builder) feedBody(r *render.Renderer, content, prev []byte) (desc, full string, err error) {
+	md := content
+	switch b.cfg.Feed.Mode {
+	case "diff":
+		md = changedSections(content, prev)
+	case "summary":
+		md = firstChange(content, prev)
+	}
+	body, err := r.RenderBody(md)
+	if err != nil {
+		return "", "", err
+	}
+	if b.cfg.Feed.Mode == "summary" {
+		return string(body), "", nil
+	}
+	return string(body), string(body), nil
+}
+
+// changedSections returns the sections of md, each a heading with the text
+// under it, that are not in prev.
+func changedSections(md, prev []byte) []byte {
+	if prev == nil {
+		return md
+	}
+	md, _ = render.StripPrivate(md)
+	prev, _ = render.StripPrivate(prev)
+	old := make(map[string]bool)
+	for _, s := range splitMarkdown(string(prev), true) {
+		old[strings.TrimSpace(s)] = true
+	}
+	var out strings.Builder
+	for _, s := range splitMarkdown(string(md), true) {
+		if !old[strings.TrimSpace(s)] {
+			out.WriteString(strings.Trim(s, "\n") + "\n\n")
+		}
+	}
+	if out.Len() == 0 {
+		return []byte(noChanges)
+	}
+	return []byte(out.String())
+}
+
+// firstChange returns the first paragraph of md that is not in prev,
+// without its heading.
+func firstChange(md, prev []byte) []byte {
+	md, _ = render.StripPrivate(md)
+	prev, _ = render.StripPrivate(prev)
+	old := make(map[string]bool)
+	for _, p := range splitMarkdown(string(prev), false) {
+		old[strings.TrimSpace(p)] = true
+	}
+	for _, p := range splitMarkdown(string(md), false) {
+		if old[strings.TrimSpace(p)] {
+			continue
+		}
+		var text strings.Builder
+		for _, line := range strings.SplitAfter(p, "\n") {
+			if !isHeading(line) {
+				text.WriteString(line)
+			}
+		}
+		if strings.TrimSpace(text.String()) != "" {
+			return []byte(text.String())
+		}
+	}
+	return []byte(noChanges)
+}
+
+// splitMarkdown splits md before each heading and, unless headingsOnly, at
+// each blank line. Lines in fenced code blocks are never split at.
+func splitMarkdown(md string, headingsOnly bool) []string {
+	var parts []string
+	var cur strings.Builder
+	flush := func() {
+		if strings.TrimSpace(cur.String()) != "" {
+			parts = append(parts, cur.String())
+		}
+		cur.Reset()
+	}
+	fence := ""
+	for _, line := range strings.SplitAfter(md, "\n") {
+		s := strings.TrimSpace(line)
+		switch {
+		case fence != "":
+			if strings.HasPrefix(s, fence) {
+				fence = ""
+			}
+		case strings.HasPrefix(s, "```") || strings.HasPrefix(s, "~~~"):
+			fence = s[:3]
+		case isHeading(line):
+			flush()
+		case s == "" && !headingsOnly:
+			flush()
+		}
+		cur.WriteString(line)
+	}
+	flush()
+	return parts
+}
+
+// isHeading reports whether line is an ATX heading, such as "## Today".
+func isHeading(line string) bool {
+	s := strings.TrimLeft(line, " ")
+	if len(line)-len(s) > 3 {
+		return false
+	}
+	level := len(s) - len(strings.TrimLeft(s, "#"))
+	if level == 0 || level > 6 {
+		return false
+	}
+	rest := s[level:]
+	return rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n'
+}
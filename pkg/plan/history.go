@@ -0,0 +1,106 @@
+package plan
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Version is a version of a plan: a commit that changed it.
+type Version struct {
+	Date string // the day, as 2006-01-02
+	Hash string
+	Time time.Time
+	// Trailers are the trailers of the commit message, such as
+	// "Plan-Visibility: private", by key.
+	Trailers map[string]string
+}
+
+// Trailer returns the value of the trailer key, ignoring case, or "".
+func (v Version) Trailer(key string) string {
+	for k, val := range v.Trailers {
+		if strings.EqualFold(k, key) {
+			return val
+		}
+	}
+	return ""
+}
+
+// History is where the past versions of a plan come from.
+type History interface {
+	// Versions returns every version of the plan, newest first.
+	Versions() ([]Version, error)
+	// Content returns the plan as of v.
+	Content(v Version) ([]byte, error)
+}
+
+// HeadHistory is a History that can read its newest version on its own,
+// which is cheaper than reading them all.
+type HeadHistory interface {
+	History
+	// Head returns the newest version of the plan, or the zero Version if
+	// it has none.
+	Head() (Version, error)
+}
+
+// GitHistory is the history of File in the git repository at Dir.
+type GitHistory struct {
+	Dir  string
+	File string
+}
+
+func (g GitHistory) Versions() ([]Version, error) {
+	return g.log()
+}
+
+func (g GitHistory) Head() (Version, error) {
+	versions, err := g.log("-1")
+	if err != nil || len(versions) == 0 {
+		return Version{}, err
+	}
+	return versions[0], nil
+}
+
+// log returns the versions git log lists with args.
+func (g GitHistory) log(args ...string) ([]Version, error) {
+	// Each commit is a record of its hash and date, then its trailers, one
+	// per line.
+	args = append([]string{"log", "--date=iso-strict", "--format=%x1e%H %ad%n%(trailers:only,unfold)"}, args...)
+	cmd := exec.Command("git", append(args, "--", g.File)...)
+	cmd.Dir = g.Dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log failed: %w", err)
+	}
+
+	var versions []Version
+	for _, record := range strings.Split(string(out), "\x1e") {
+		lines := strings.Split(strings.TrimSpace(record), "\n")
+		hash, date, ok := strings.Cut(lines[0], " ")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			return nil, fmt.Errorf("parsing date %q: %w", date, err)
+		}
+		v := Version{Date: t.Format("2006-01-02"), Hash: hash, Time: t}
+		for _, line := range lines[1:] {
+			if key, val, ok := strings.Cut(line, ":"); ok {
+				if v.Trailers == nil {
+					v.Trailers = make(map[string]string)
+				}
+				v.Trailers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+			}
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+func (g GitHistory) Content(v Version) ([]byte, error) {
+	cmd := exec.Command("git", "show", fmt.Sprintf("%s:%s", v.Hash, g.File))
+	cmd.Dir = g.Dir
+	return cmd.Output()
+}
@@ -0,0 +1,93 @@
+package plan
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestFile is where the manifest of a site is kept, relative to the
+// output. It is not listed in the manifest itself.
+const ManifestFile = "manifest.json"
+
+// Manifest lists every file of a built site, and what changed since the
+// previous build, for deployment scripts and cache purgers.
+type Manifest struct {
+	Built   time.Time    `json:"built"`
+	Files   []Page       `json:"files"` // sorted by path
+	Changes ManifestDiff `json:"changes"`
+}
+
+// ManifestDiff lists the paths of files added, changed and removed between
+// two builds.
+type ManifestDiff struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+// NewManifest returns the manifest of the pages of a build at built, with the
+// changes since prev. Without a previous manifest, every file is added. A
+// path written more than once is listed as last written.
+func NewManifest(pages []Page, built time.Time, prev *Manifest) *Manifest {
+	byPath := make(map[string]Page)
+	for _, p := range pages {
+		if p.Path != ManifestFile {
+			byPath[p.Path] = p
+		}
+	}
+	m := &Manifest{Built: built, Files: make([]Page, 0, len(byPath))}
+	for _, p := range byPath {
+		m.Files = append(m.Files, p)
+	}
+	slices.SortFunc(m.Files, func(a, b Page) int { return strings.Compare(a.Path, b.Path) })
+
+	var old []Page
+	if prev != nil {
+		old = prev.Files
+	}
+	m.Changes = diffFiles(old, m.Files)
+	return m
+}
+
+func diffFiles(old, cur []Page) ManifestDiff {
+	d := ManifestDiff{Added: []string{}, Changed: []string{}, Removed: []string{}}
+	hashes := make(map[string]string)
+	for _, p := range old {
+		hashes[p.Path] = p.SHA256
+	}
+	for _, p := range cur {
+		h, ok := hashes[p.Path]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, p.Path)
+		case h != p.SHA256:
+			d.Changed = append(d.Changed, p.Path)
+		}
+		delete(hashes, p.Path)
+	}
+	for p := range hashes {
+		d.Removed = append(d.Removed, p)
+	}
+	slices.Sort(d.Removed)
+	return d
+}
+
+// ParseManifest decodes a manifest written by JSON.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// JSON encodes the manifest, indented.
+func (m *Manifest) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
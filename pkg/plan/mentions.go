@@ -0,0 +1,76 @@
+package plan
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/webmention"
+)
+
+var dayPathRe = regexp.MustCompile(`^/(\d{4})/(\d{2})/(\d{2})/?$`)
+
+// DayPath returns the path of the page for the day of t, e.g. /2025/12/01,
+// relative to the root of the site.
+func DayPath(t time.Time) string {
+	return t.Format("/2006/01/02")
+}
+
+// ParseDayPath returns the day, as 2006-01-02, whose page is at the site
+// path p.
+func ParseDayPath(p string) (string, bool) {
+	parts := dayPathRe.FindStringSubmatch(p)
+	if parts == nil {
+		return "", false
+	}
+	return parts[1] + "-" + parts[2] + "-" + parts[3], true
+}
+
+// mentionsByDay groups mentions by the history day they refer to. A mention
+// of a day page, under prefix, belongs to that day; a mention of any other
+// page belongs to the day that was current when it was received.
+func mentionsByDay(prefix string, loc *time.Location, mentions []webmention.Mention, dates []string) map[string][]webmention.Mention {
+	sorted := append([]string(nil), dates...)
+	sort.Strings(sorted)
+
+	byDay := make(map[string][]webmention.Mention)
+	for _, m := range mentions {
+		day := ""
+		if path, ok := strings.CutPrefix(m.Target, prefix); ok {
+			day, _ = ParseDayPath(path)
+		}
+		if day == "" {
+			received := m.Received.In(loc).Format("2006-01-02")
+			for _, d := range sorted {
+				if d <= received {
+					day = d
+				}
+			}
+		}
+		if day != "" {
+			byDay[day] = append(byDay[day], m)
+		}
+	}
+	return byDay
+}
+
+// mentionsHTML renders mentions as a block of raw HTML to append to a page.
+func mentionsHTML(mentions []webmention.Mention) string {
+	if len(mentions) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\n<section class=\"mentions\">\n<h2>Mentions</h2>\n<ul>\n")
+	for _, m := range mentions {
+		title := m.Title
+		if title == "" {
+			title = m.Source
+		}
+		fmt.Fprintf(&sb, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(m.Source), html.EscapeString(title))
+	}
+	sb.WriteString("</ul>\n</section>\n")
+	return sb.String()
+}
@@ -0,0 +1,125 @@
+package plan
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Output is where a Builder writes the site. Names are slash-separated paths
+// relative to the root of the site, such as "2025/12/01/index.html".
+type Output interface {
+	// WriteFile writes data to the file name, creating or replacing it.
+	WriteFile(name string, data []byte) error
+	// ReadFile returns the contents of the file name.
+	ReadFile(name string) ([]byte, error)
+	// Remove removes the file or directory name and anything in it. It is
+	// not an error if name does not exist.
+	Remove(name string) error
+	// Files returns the names of every file in the output, sorted.
+	Files() ([]string, error)
+}
+
+// DirOutput writes the site to a directory.
+type DirOutput string
+
+// WriteFile writes a new file and renames it over name, so a file being
+// served is never seen half written, and a hard link to it is left as it was.
+func (d DirOutput) WriteFile(name string, data []byte) error {
+	p := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".plan-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (d DirOutput) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// Remove removes name, and then any directories it leaves empty.
+func (d DirOutput) Remove(name string) error {
+	p := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.RemoveAll(p); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(p); dir != string(d) && strings.HasPrefix(dir, string(d)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (d DirOutput) Files() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(string(d), func(p string, e fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == string(d) {
+			return fs.SkipDir
+		}
+		if err != nil || !e.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(string(d), p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+// MemOutput keeps the site in memory, by file name.
+type MemOutput map[string][]byte
+
+func (m MemOutput) WriteFile(name string, data []byte) error {
+	m[path.Clean(name)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m MemOutput) ReadFile(name string) ([]byte, error) {
+	data, ok := m[path.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (m MemOutput) Remove(name string) error {
+	name = path.Clean(name)
+	for k := range m {
+		if k == name || strings.HasPrefix(k, name+"/") {
+			delete(m, k)
+		}
+	}
+	return nil
+}
+
+func (m MemOutput) Files() ([]string, error) {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	return names, nil
+}
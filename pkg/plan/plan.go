@@ -0,0 +1,401 @@
+// Package plan builds the static site of a plan: the current page, a page for
+// every day in the plan's history, year, month and archive indexes, and an
+// RSS feed.
+//
+// A Builder is configured with options and writes to an Output:
+//
+//	b, err := plan.New("/home/alice/plan", plan.WithOutput(plan.DirOutput("/srv/www")))
+//	if err != nil {
+//		return err
+//	}
+//	result, err := b.Build()
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+	"github.com/dewitt/a-simple-plan/internal/webmention"
+)
+
+// ErrNoVersion is returned by BuildDay for a day the plan has no version on.
+var ErrNoVersion = errors.New("no version of the plan on that day")
+
+// PageKind says what a generated page is.
+type PageKind string
+
+const (
+	KindIndex     PageKind = "index"     // the current plan
+	KindDay       PageKind = "day"       // the plan as it was on a day
+	KindRetracted PageKind = "retracted" // the note left on a retracted day
+	KindYear      PageKind = "year"      // the days of a year
+	KindMonth     PageKind = "month"     // the days of a month
+	KindArchives  PageKind = "archives"  // every day
+	KindFeed      PageKind = "feed"      // the RSS feed
+	KindAsset     PageKind = "asset"     // a file copied from assets/
+	KindNotFound  PageKind = "notfound"  // the 404 page
+	KindPage      PageKind = "page"      // any other page, see WritePage
+)
+
+// Page is a file written by a build.
+type Page struct {
+	Path string   `json:"path"` // slash-separated, relative to the output
+	Kind PageKind `json:"kind"`
+	Date string   `json:"date,omitempty"` // the day of KindDay pages, as 2006-01-02
+	// Commit is the version the page was built from: the day's for a day
+	// page, the newest for the history indexes and the feed. It is empty for
+	// pages built from the working copy, and for assets.
+	Commit string `json:"commit,omitempty"`
+
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type"`
+}
+
+// Result is the outcome of a build.
+type Result struct {
+	Pages []Page
+	// Items are the feed items of the plan's history, newest first.
+	Items []Item
+	// Warnings are problems that did not stop the build, such as a day
+	// whose content could not be read.
+	Warnings []string
+	// NextPublish is when the next embargoed version may be published, and
+	// the site should be built again, or the zero time.
+	NextPublish time.Time
+}
+
+// Builder builds the site of a plan. A Builder reads the plan's history once,
+// when it is first needed; create a new one to see later commits.
+type Builder struct {
+	dir         string
+	file        string
+	cfg         config.Config
+	cfgSet      bool
+	tmpl        string
+	tmplSet     bool
+	out         Output
+	history     History
+	logger      *log.Logger
+	now         func() time.Time
+	created     time.Time
+	liveReload  bool
+	showPrivate bool
+	basePath    string
+
+	retractions    map[string]Retraction
+	retractionsSet bool
+
+	loaded    bool
+	head      *Version        // the newest version, published or not, once read
+	all       []Version       // every version, once read, newest first
+	allRead   bool            // whether all has been read
+	heldBack  map[string]bool // the hashes of the versions that are embargoed
+	next      time.Time       // when the next embargoed version is due
+	versions  []Version       // the published version of each day, newest first
+	retracted []Version       // the last version of each retracted day
+	days      map[string]Version
+	mentions  map[string][]webmention.Mention
+	warnings  []string
+}
+
+// Option configures a Builder.
+type Option func(*Builder)
+
+// WithFile sets the plan file, relative to the plan directory. The default is
+// plan.md.
+func WithFile(name string) Option {
+	return func(b *Builder) { b.file = name }
+}
+
+// WithConfig sets the configuration. By default it is loaded from the
+// settings files in the plan directory.
+func WithConfig(cfg config.Config) Option {
+	return func(b *Builder) { b.cfg, b.cfgSet = cfg, true }
+}
+
+// WithTemplate sets the page template; empty means the built-in template. By
+// default it is the plan's template.html, or its configured theme.
+func WithTemplate(tmpl string) Option {
+	return func(b *Builder) { b.tmpl, b.tmplSet = tmpl, true }
+}
+
+// WithOutput sets where the site is written. The default is the public
+// directory of the plan.
+func WithOutput(out Output) Option {
+	return func(b *Builder) { b.out = out }
+}
+
+// WithHistory sets where the plan's past versions come from. The default is
+// the git history of the plan file.
+func WithHistory(h History) Option {
+	return func(b *Builder) { b.history = h }
+}
+
+// WithLogger sets where progress and warnings are logged. By default they are
+// discarded; warnings are returned in the Result either way.
+func WithLogger(l *log.Logger) Option {
+	return func(b *Builder) { b.logger = l }
+}
+
+// WithClock sets the function used for the current time.
+func WithClock(now func() time.Time) Option {
+	return func(b *Builder) { b.now = now }
+}
+
+// WithCreated sets when the plan was started, shown as "On since" on every
+// page. The default is the time of its oldest version.
+func WithCreated(t time.Time) Option {
+	return func(b *Builder) { b.created = t }
+}
+
+// WithRetractions sets the days retracted from the published history. By
+// default they are read from the plan's retractions.json.
+func WithRetractions(rs []Retraction) Option {
+	return func(b *Builder) {
+		b.retractions, b.retractionsSet = make(map[string]Retraction), true
+		for _, r := range rs {
+			b.retractions[r.Date] = r
+		}
+	}
+}
+
+// WithShowPrivate highlights the private blocks of the plan in its pages
+// instead of leaving them out, for preview. Feed items never show them.
+func WithShowPrivate(on bool) Option {
+	return func(b *Builder) { b.showPrivate = on }
+}
+
+// WithLiveReload adds the live reload script used by preview to every page.
+func WithLiveReload(on bool) Option {
+	return func(b *Builder) { b.liveReload = on }
+}
+
+// WithBasePath sets the URL path the site is served under, e.g. "/~alice".
+func WithBasePath(p string) Option {
+	return func(b *Builder) { b.basePath = strings.TrimSuffix(p, "/") }
+}
+
+// New returns a Builder for the plan in dir.
+func New(dir string, opts ...Option) (*Builder, error) {
+	b := &Builder{dir: dir, file: "plan.md", now: time.Now}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if !b.cfgSet {
+		cfg, _, err := config.LoadLayers(dir, config.Options{})
+		if err != nil {
+			return nil, fmt.Errorf("loading config: %w", err)
+		}
+		b.cfg = cfg
+	}
+	if !b.tmplSet {
+		tmpl, err := LoadTemplate(dir, b.cfg)
+		if err != nil {
+			return nil, fmt.Errorf("theme: %w", err)
+		}
+		b.tmpl = tmpl
+	}
+	if !b.retractionsSet {
+		rs, err := LoadRetractions(dir)
+		if err != nil {
+			return nil, err
+		}
+		WithRetractions(rs)(b)
+	}
+	if b.out == nil {
+		b.out = DirOutput(filepath.Join(dir, "public"))
+	}
+	if b.history == nil {
+		b.history = GitHistory{Dir: dir, File: b.file}
+	}
+	if b.logger == nil {
+		b.logger = log.New(io.Discard, "", 0)
+	}
+	return b, nil
+}
+
+// Days returns the version published on each day, newest first. Private
+// versions and retracted days are left out.
+func (b *Builder) Days() ([]Version, error) {
+	if err := b.loadHistory(); err != nil {
+		return nil, err
+	}
+	return b.versions, nil
+}
+
+// Config returns the configuration the Builder uses.
+func (b *Builder) Config() config.Config {
+	return b.cfg
+}
+
+// Output returns where the Builder writes the site.
+func (b *Builder) Output() Output {
+	return b.out
+}
+
+func (b *Builder) location() *time.Location {
+	loc, err := time.LoadLocation(b.cfg.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// warn records a warning for the Result and logs it.
+func (b *Builder) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	b.warnings = append(b.warnings, msg)
+	b.logger.Printf("Warning: %s", msg)
+}
+
+// loadHistory reads the plan's versions and the mentions of each day, once.
+func (b *Builder) loadHistory() error {
+	if b.loaded {
+		return nil
+	}
+	if err := b.readVersions(); err != nil {
+		return err
+	}
+	versions, retracted := publishedDays(b.all, b.retractions, b.heldBack)
+	mentions, err := (&webmention.Store{Path: filepath.Join(b.dir, "webmentions.json")}).Load()
+	if err != nil {
+		b.warn("Failed to load webmentions: %v", err)
+	}
+	var dates []string
+	b.days = make(map[string]Version)
+	for _, v := range versions {
+		dates = append(dates, v.Date)
+		b.days[v.Date] = v
+	}
+	prefix := strings.TrimSuffix(b.cfg.BaseURL+b.basePath, "/")
+	b.versions, b.retracted = versions, retracted
+	b.mentions = mentionsByDay(prefix, b.location(), mentions, dates)
+	b.loaded = true
+	return nil
+}
+
+// readVersions reads every version of the plan, and which of them are
+// embargoed, once. Unlike loadHistory, it reads nothing else.
+func (b *Builder) readVersions() error {
+	if b.allRead {
+		return nil
+	}
+	all, err := b.history.Versions()
+	if err != nil {
+		return err
+	}
+	b.all, b.heldBack, b.allRead = all, b.embargoed(all), true
+	b.head = &Version{}
+	if len(all) > 0 {
+		b.head = &all[0]
+	}
+	return nil
+}
+
+// embargoed returns the hashes of the versions that may not be published
+// yet, and notes when the first of them may be. A version is edited from the
+// ones before it, so it is held back until every embargo before it is over
+// as well as its own. A version whose embargo cannot be read is held back
+// with the versions after it, with a warning.
+func (b *Builder) embargoed(versions []Version) map[string]bool {
+	held := make(map[string]bool)
+	now := b.now()
+	var until time.Time // the latest embargo of the versions so far
+	unreadable := false
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		at, err := publishAt(v, b.location())
+		if err != nil {
+			b.warn("Holding back the version of %s and those after it: %v", v.Date, err)
+			unreadable = true
+		} else if at.After(until) {
+			until = at
+		}
+		if unreadable {
+			held[v.Hash] = true
+			continue
+		}
+		if until.After(now) {
+			held[v.Hash] = true
+			if b.next.IsZero() || until.Before(b.next) {
+				b.next = until
+			}
+		}
+	}
+	return held
+}
+
+// newest returns the newest version of the plan, published or not, or the
+// zero Version if there is none. Unless the versions are already read, only
+// that one is read when the history allows it.
+func (b *Builder) newest() (Version, error) {
+	if b.head == nil {
+		h, ok := b.history.(HeadHistory)
+		if !ok {
+			if err := b.readVersions(); err != nil {
+				return Version{}, err
+			}
+			return *b.head, nil
+		}
+		v, err := h.Head()
+		if err != nil {
+			return Version{}, err
+		}
+		b.head = &v
+	}
+	return *b.head, nil
+}
+
+// held reports whether v may not be published yet, by its own embargo or one
+// of a version before it. The other versions are only read if its own does
+// not hold it back.
+func (b *Builder) held(v Version) bool {
+	at, err := publishAt(v, b.location())
+	if err != nil || at.After(b.now()) {
+		return true
+	}
+	if err := b.readVersions(); err != nil {
+		return true
+	}
+	return b.heldBack[v.Hash]
+}
+
+// NextPublish returns when the next embargoed version of the plan may be
+// published, and so when the site should be built again, or the zero time if
+// no version is embargoed.
+func (b *Builder) NextPublish() (time.Time, error) {
+	if err := b.readVersions(); err != nil {
+		return time.Time{}, err
+	}
+	return b.next, nil
+}
+
+// createdTime returns when the plan was started.
+func (b *Builder) createdTime() time.Time {
+	if !b.created.IsZero() {
+		return b.created
+	}
+	if b.loadHistory() == nil && len(b.versions) > 0 {
+		b.created = b.versions[len(b.versions)-1].Time
+	} else if info, err := os.Stat(filepath.Join(b.dir, b.file)); err == nil {
+		b.created = info.ModTime()
+	} else {
+		b.created = b.now()
+	}
+	return b.created
+}
+
+// assetPrefix returns the relative path from the page at name back to the
+// root of the site, e.g. "../../../" for a day page.
+func assetPrefix(name string) string {
+	return strings.Repeat("../", strings.Count(path.Clean(name), "/"))
+}
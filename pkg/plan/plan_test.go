@@ -0,0 +1,675 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+)
+
+// staticHistory is a History kept in memory, by hash.
+type staticHistory struct {
+	versions []Version
+	content  map[string]string
+}
+
+func (h staticHistory) Versions() ([]Version, error) {
+	return h.versions, nil
+}
+
+func (h staticHistory) Content(v Version) ([]byte, error) {
+	c, ok := h.content[v.Hash]
+	if !ok {
+		return nil, errors.New("missing object")
+	}
+	return []byte(c), nil
+}
+
+// headHistory is a staticHistory that can read its newest version alone,
+// and counts how often the whole history is read.
+type headHistory struct {
+	staticHistory
+	reads *int
+}
+
+func (h headHistory) Versions() ([]Version, error) {
+	*h.reads++
+	return h.staticHistory.Versions()
+}
+
+func (h headHistory) Head() (Version, error) {
+	return h.versions[0], nil
+}
+
+func newTestBuilder(t *testing.T, opts ...Option) (*Builder, MemOutput) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "plan.md"), []byte("# Today\n\nWorking on the builder.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = "https://example.com"
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	history := staticHistory{
+		versions: []Version{
+			{Date: "2025-12-02", Hash: "b", Time: day("2025-12-02T09:00:00Z")},
+			{Date: "2025-11-30", Hash: "a", Time: day("2025-11-30T18:00:00Z")},
+		},
+		content: map[string]string{
+			"a": "First entry.\n",
+			"b": "Second entry.\n\n![Desk](assets/desk.jpg)\n",
+		},
+	}
+	out := MemOutput{}
+	opts = append([]Option{
+		WithConfig(cfg),
+		WithTemplate(""),
+		WithOutput(out),
+		WithHistory(history),
+		WithClock(func() time.Time { return day("2025-12-03T00:00:00Z") }),
+	}, opts...)
+	b, err := New(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, out
+}
+
+func TestBuild(t *testing.T) {
+	b, out := newTestBuilder(t)
+	res, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(res.Warnings) > 0 {
+		t.Errorf("Build warnings: %v", res.Warnings)
+	}
+
+	want := []string{
+		"2025/11/30/index.html",
+		"2025/11/index.html",
+		"2025/12/02/index.html",
+		"2025/12/index.html",
+		"2025/index.html",
+		"404.html",
+		"archives/index.html",
+		"index.html",
+		"rss.xml",
+	}
+	var got []string
+	for name := range out {
+		got = append(got, name)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("output = %v, want %v", got, want)
+	}
+	if len(res.Pages) != len(want) {
+		t.Errorf("got %d pages, want %d", len(res.Pages), len(want))
+	}
+
+	if page := string(out["2025/12/02/index.html"]); !strings.Contains(page, "Second entry.") || !strings.Contains(page, `src="../../../assets/desk.jpg"`) {
+		t.Errorf("day page missing content or asset prefix:\n%s", page)
+	}
+	if archives := string(out["archives/index.html"]); strings.Index(archives, "2025-12-02") > strings.Index(archives, "2025-11-30") {
+		t.Errorf("archives not newest first:\n%s", archives)
+	}
+
+	if len(res.Items) != 2 || res.Items[0].Link != "https://example.com/2025/12/02" {
+		t.Errorf("items = %+v", res.Items)
+	}
+	if feed := string(out["rss.xml"]); !strings.Contains(feed, "<link>https://example.com/2025/11/30</link>") {
+		t.Errorf("feed missing item:\n%s", feed)
+	}
+}
+
+func TestBuild_UnreadableDay(t *testing.T) {
+	b, out := newTestBuilder(t, WithHistory(staticHistory{
+		versions: []Version{{Date: "2025-12-02", Hash: "gone", Time: time.Date(2025, 12, 2, 9, 0, 0, 0, time.UTC)}},
+	}))
+	res, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "2025-12-02") {
+		t.Errorf("warnings = %v, want one for 2025-12-02", res.Warnings)
+	}
+	if _, ok := out["2025/12/02/index.html"]; ok {
+		t.Error("unreadable day was written")
+	}
+}
+
+// failingHistory is a History that cannot be read, like a repository with no
+// commits yet.
+type failingHistory struct{}
+
+func (failingHistory) Versions() ([]Version, error)    { return nil, errors.New("no commits") }
+func (failingHistory) Content(Version) ([]byte, error) { return nil, errors.New("no commits") }
+
+func TestBuild_HistoryErrors(t *testing.T) {
+	// A plan with no history yet still builds.
+	b, out := newTestBuilder(t, WithHistory(failingHistory{}))
+	res, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed without a history: %v", err)
+	}
+	if len(res.Warnings) != 1 {
+		t.Errorf("warnings = %v, want one", res.Warnings)
+	}
+	if _, ok := out["index.html"]; !ok {
+		t.Error("index.html not written without a history")
+	}
+
+	// One that cannot be built does not.
+	b, _ = newTestBuilder(t, WithHistory(staticHistory{
+		versions: []Version{{Date: "2025-12-02", Hash: "b", Time: time.Date(2025, 12, 2, 9, 0, 0, 0, time.UTC)}},
+		content:  map[string]string{"b": "<!-- private -->\nNever closed.\n"},
+	}))
+	if _, err := b.Build(); err == nil || !strings.Contains(err.Error(), "2025-12-02") {
+		t.Errorf("Build error = %v, want one for 2025-12-02", err)
+	}
+}
+
+func TestBuildDay(t *testing.T) {
+	b, out := newTestBuilder(t, WithBasePath("/~alice/"))
+	page, err := b.BuildDay("2025-11-30")
+	if err != nil {
+		t.Fatalf("BuildDay failed: %v", err)
+	}
+	if page.Path != "2025/11/30/index.html" || page.Kind != KindDay || page.Date != "2025-11-30" || page.Commit != "a" {
+		t.Errorf("page = %+v", page)
+	}
+	if page.ContentType != "text/html; charset=utf-8" || page.Size != len(out[page.Path]) || len(page.SHA256) != 64 {
+		t.Errorf("page = %+v, want its type, size and hash", page)
+	}
+	if len(out) != 1 {
+		t.Errorf("BuildDay wrote %d files, want 1", len(out))
+	}
+
+	if _, err := b.BuildDay("2025-12-01"); !errors.Is(err, ErrNoVersion) {
+		t.Errorf("BuildDay(2025-12-01) error = %v, want ErrNoVersion", err)
+	}
+
+	if _, err := b.BuildIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	if month := string(out["2025/11/index.html"]); !strings.Contains(month, `href="/~alice/2025/11/30"`) {
+		t.Errorf("month page links not under the base path:\n%s", month)
+	}
+}
+
+func TestNewManifest(t *testing.T) {
+	b, _ := newTestBuilder(t)
+	res, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	built := time.Date(2025, 12, 3, 0, 0, 0, 0, time.UTC)
+	first := NewManifest(res.Pages, built, nil)
+	if len(first.Files) != len(res.Pages) || len(first.Changes.Added) != len(res.Pages) {
+		t.Errorf("first manifest: %d files, %d added, want %d", len(first.Files), len(first.Changes.Added), len(res.Pages))
+	}
+	if !slices.IsSortedFunc(first.Files, func(a, b Page) int { return strings.Compare(a.Path, b.Path) }) {
+		t.Error("files not sorted by path")
+	}
+
+	data, err := first.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, err := ParseManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pages []Page
+	for _, p := range res.Pages {
+		switch p.Path {
+		case "404.html":
+			continue
+		case "index.html":
+			p.SHA256 = "changed"
+		}
+		pages = append(pages, p)
+	}
+	pages = append(pages, Page{Path: "assets/new.png", Kind: KindAsset})
+	got := NewManifest(pages, built, prev).Changes
+	want := ManifestDiff{Added: []string{"assets/new.png"}, Changed: []string{"index.html"}, Removed: []string{"404.html"}}
+	if !slices.Equal(got.Added, want.Added) || !slices.Equal(got.Changed, want.Changed) || !slices.Equal(got.Removed, want.Removed) {
+		t.Errorf("changes = %+v, want %+v", got, want)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	b, out := newTestBuilder(t)
+	out.WriteFile("2025/10/01/index.html", []byte("gone from the history"))
+	out.WriteFile("notes.txt", []byte("not ours"))
+	out.WriteFile(ManifestFile, []byte("{}"))
+	prev := &Manifest{Files: []Page{{Path: "2025/10/01/index.html"}, {Path: "index.html"}, {Path: "../outside"}}}
+
+	res, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := b.Prune(prev, res, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, []string{"2025/10/01/index.html"}) {
+		t.Errorf("Prune removed %v, want the day no longer in the history", removed)
+	}
+	if out["notes.txt"] == nil || out["index.html"] == nil {
+		t.Error("Prune removed a file it does not own")
+	}
+
+	removed, err = b.Prune(nil, res, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, []string{"notes.txt"}) {
+		t.Errorf("Prune(all) removed %v, want notes.txt", removed)
+	}
+	if out[ManifestFile] == nil {
+		t.Error("Prune(all) removed the manifest")
+	}
+}
+
+func TestParseDayPath(t *testing.T) {
+	tests := map[string]string{
+		"/2025/12/01":  "2025-12-01",
+		"/2025/12/01/": "2025-12-01",
+		"/2025/12/":    "",
+		"/archives/":   "",
+	}
+	for p, want := range tests {
+		got, ok := ParseDayPath(p)
+		if got != want || ok != (want != "") {
+			t.Errorf("ParseDayPath(%q) = %q, %v, want %q", p, got, ok, want)
+		}
+	}
+}
+
+func TestMemOutput_Remove(t *testing.T) {
+	out := MemOutput{}
+	for _, name := range []string{"assets/a.png", "assets/img/b.png", "assetsx"} {
+		out.WriteFile(name, []byte(name))
+	}
+	out.Remove("assets")
+	if len(out) != 1 || out["assetsx"] == nil {
+		t.Errorf("after Remove(assets): %v", out)
+	}
+}
+
+func TestBuild_Visibility(t *testing.T) {
+	at := func(s string) time.Time {
+		d, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	history := staticHistory{
+		versions: []Version{
+			{Date: "2025-12-02", Hash: "d", Time: at("2025-12-02T20:00:00Z"), Trailers: map[string]string{"plan-visibility": "Private"}},
+			{Date: "2025-12-02", Hash: "c", Time: at("2025-12-02T09:00:00Z")},
+			{Date: "2025-12-01", Hash: "b", Time: at("2025-12-01T09:00:00Z")},
+			{Date: "2025-11-30", Hash: "a", Time: at("2025-11-30T09:00:00Z")},
+		},
+		content: map[string]string{
+			"a": "Retracted quietly.\n",
+			"b": "Retracted with a note.\n",
+			"c": "Public entry.\n",
+			"d": "Private entry.\n",
+		},
+	}
+	b, out := newTestBuilder(t, WithHistory(history), WithRetractions([]Retraction{
+		{Date: "2025-11-30"},
+		{Date: "2025-12-01", Note: "Posted by mistake."},
+	}))
+	res, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	days, err := b.Days()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || days[0].Hash != "c" {
+		t.Errorf("Days() = %+v, want only c", days)
+	}
+	if page := string(out["2025/12/02/index.html"]); !strings.Contains(page, "Public entry.") || strings.Contains(page, "Private entry.") {
+		t.Errorf("day page does not show its last public version:\n%s", page)
+	}
+	if _, ok := out["2025/11/30/index.html"]; ok {
+		t.Error("retracted day without a note was written")
+	}
+	if page := string(out["2025/12/01/index.html"]); !strings.Contains(page, "Posted by mistake.") || strings.Contains(page, "Retracted with a note.") {
+		t.Errorf("retracted day does not show only its note:\n%s", page)
+	}
+	for _, p := range res.Pages {
+		if p.Path == "2025/12/01/index.html" && p.Kind != KindRetracted {
+			t.Errorf("retracted page kind = %q, want %q", p.Kind, KindRetracted)
+		}
+	}
+
+	for _, name := range []string{"archives/index.html", "2025/index.html", "2025/11/index.html", "2025/12/index.html", "rss.xml"} {
+		if s := string(out[name]); strings.Contains(s, "2025/12/01") || strings.Contains(s, "2025-12-01") || strings.Contains(s, "2025-11-30") {
+			t.Errorf("%s lists a retracted day:\n%s", name, s)
+		}
+	}
+	if len(res.Items) != 1 || res.Items[0].Link != "https://example.com/2025/12/02" {
+		t.Errorf("items = %+v", res.Items)
+	}
+
+	// The plan as last committed is private, so the index shows the last
+	// public version.
+	if err := os.WriteFile(filepath.Join(b.dir, b.file), []byte("Private entry.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.BuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if index := string(out["index.html"]); !strings.Contains(index, "Public entry.") || strings.Contains(index, "Private entry.") {
+		t.Errorf("index does not show the last public version:\n%s", index)
+	}
+}
+
+func TestBuildIndex_ReadsOnlyHead(t *testing.T) {
+	at := func(s string) time.Time {
+		d, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	reads := 0
+	history := headHistory{staticHistory: staticHistory{
+		versions: []Version{
+			{Date: "2025-12-02", Hash: "b", Time: at("2025-12-02T09:00:00Z")},
+			{Date: "2025-12-01", Hash: "a", Time: at("2025-12-01T09:00:00Z")},
+		},
+		content: map[string]string{"a": "Public entry.\n", "b": "Newer entry.\n"},
+	}, reads: &reads}
+
+	// A head embargoed on its own is held back without reading the rest.
+	history.versions[0].Time = at("2025-12-04T09:00:00Z")
+	b, out := newTestBuilder(t, WithHistory(history), WithCreated(at("2025-11-01T00:00:00Z")))
+	if _, ok := b.unpublishedHead([]byte("Newer entry.\n")); !ok {
+		t.Error("embargoed head is not held back")
+	}
+	if reads != 0 {
+		t.Errorf("checking an embargoed head read the whole history %d times, want none", reads)
+	}
+
+	// Otherwise an embargo before it may hold it back, so the versions are
+	// read, but only once.
+	history.versions[0].Time = at("2025-12-02T09:00:00Z")
+	b, out = newTestBuilder(t, WithHistory(history), WithCreated(at("2025-11-01T00:00:00Z")))
+	for range 2 {
+		if _, err := b.BuildIndex(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("BuildIndex read the whole history %d times, want once", reads)
+	}
+	if index := string(out["index.html"]); !strings.Contains(index, "Working on the builder.") {
+		t.Errorf("index does not show the working copy:\n%s", index)
+	}
+
+	// A private head needs the whole history, for the version before it.
+	history.versions[0].Trailers = map[string]string{VisibilityTrailer: "private"}
+	b, out = newTestBuilder(t, WithHistory(history), WithCreated(at("2025-11-01T00:00:00Z")))
+	if err := os.WriteFile(filepath.Join(b.dir, b.file), []byte("Newer entry.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.BuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if index := string(out["index.html"]); !strings.Contains(index, "Public entry.") {
+		t.Errorf("index does not show the last public version:\n%s", index)
+	}
+}
+
+func TestLoadRetractions(t *testing.T) {
+	dir := t.TempDir()
+	if rs, err := LoadRetractions(dir); err != nil || rs != nil {
+		t.Errorf("LoadRetractions without a file = %v, %v", rs, err)
+	}
+	path := filepath.Join(dir, RetractionsFile)
+	if err := os.WriteFile(path, []byte(`[{"date": "2025-12-01", "note": "Gone."}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := LoadRetractions(dir)
+	if err != nil || len(rs) != 1 || rs[0].Note != "Gone." {
+		t.Errorf("LoadRetractions = %v, %v", rs, err)
+	}
+	if err := os.WriteFile(path, []byte(`[{"date": "Dec 1"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRetractions(dir); err == nil {
+		t.Error("LoadRetractions accepted an invalid date")
+	}
+}
+
+func TestBuild_Embargo(t *testing.T) {
+	history := staticHistory{
+		versions: []Version{
+			{Date: "2025-12-04", Hash: "d", Time: time.Date(2025, 12, 4, 8, 0, 0, 0, time.UTC)},
+			{Date: "2025-12-02", Hash: "c", Time: time.Date(2025, 12, 2, 20, 0, 0, 0, time.UTC), Trailers: map[string]string{PublishAtTrailer: "2025-12-03T12:00:00Z"}},
+			{Date: "2025-12-02", Hash: "b", Time: time.Date(2025, 12, 2, 9, 0, 0, 0, time.UTC), Trailers: map[string]string{PublishAtTrailer: "2025-12-01T00:00:00Z"}},
+		},
+		content: map[string]string{
+			"b": "Published entry.\n",
+			"c": "Evening entry.\n",
+			"d": "Tomorrow's entry.\n",
+		},
+	}
+	b, out := newTestBuilder(t, WithHistory(history))
+	if err := os.WriteFile(filepath.Join(b.dir, b.file), []byte("Tomorrow's entry.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if want := time.Date(2025, 12, 3, 12, 0, 0, 0, time.UTC); !res.NextPublish.Equal(want) {
+		t.Errorf("NextPublish = %v, want %v", res.NextPublish, want)
+	}
+	days, err := b.Days()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || days[0].Hash != "b" {
+		t.Errorf("Days() = %+v, want only b", days)
+	}
+	if _, ok := out["2025/12/04/index.html"]; ok {
+		t.Error("embargoed day was written")
+	}
+	if index := string(out["index.html"]); !strings.Contains(index, "Published entry.") {
+		t.Errorf("index does not show the last published version:\n%s", index)
+	}
+	if feed := string(out["rss.xml"]); strings.Contains(feed, "Evening entry.") || strings.Contains(feed, "2025/12/04") {
+		t.Errorf("feed lists an embargoed version:\n%s", feed)
+	}
+
+	// Edits made after an embargoed version are held back with it.
+	if err := os.WriteFile(filepath.Join(b.dir, b.file), []byte("Tomorrow's entry, edited.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.BuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if index := string(out["index.html"]); !strings.Contains(index, "Published entry.") || strings.Contains(index, "Tomorrow") {
+		t.Errorf("index shows an edit of an embargoed version:\n%s", index)
+	}
+
+	// A version committed after an embargoed one is held back with it, as
+	// it was edited from it.
+	fixed := staticHistory{
+		versions: append([]Version{{Date: "2025-12-02", Hash: "e", Time: time.Date(2025, 12, 2, 21, 0, 0, 0, time.UTC)}}, history.versions[1:]...),
+		content:  map[string]string{"b": "Published entry.\n", "c": "Evening entry.\n", "e": "Evening entry, typo fixed.\n"},
+	}
+	b, out = newTestBuilder(t, WithHistory(fixed))
+	if err := os.WriteFile(filepath.Join(b.dir, b.file), []byte("Evening entry, typo fixed.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err = b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if want := time.Date(2025, 12, 3, 12, 0, 0, 0, time.UTC); !res.NextPublish.Equal(want) {
+		t.Errorf("NextPublish = %v, want %v", res.NextPublish, want)
+	}
+	for name, data := range out {
+		if strings.Contains(string(data), "Evening entry") {
+			t.Errorf("%s shows a version committed after an embargoed one:\n%s", name, data)
+		}
+	}
+	if day := string(out["2025/12/02/index.html"]); !strings.Contains(day, "Published entry.") {
+		t.Errorf("day page does not show its last published version:\n%s", day)
+	}
+	b, out = newTestBuilder(t, WithHistory(fixed), WithClock(func() time.Time { return time.Date(2025, 12, 3, 13, 0, 0, 0, time.UTC) }))
+	if _, err := b.Build(); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if day := string(out["2025/12/02/index.html"]); !strings.Contains(day, "typo fixed") {
+		t.Errorf("day page does not show the fix once the embargo is over:\n%s", day)
+	}
+
+	// Once the embargo is over, the versions are published.
+	b, out = newTestBuilder(t, WithHistory(history), WithClock(func() time.Time { return time.Date(2025, 12, 5, 0, 0, 0, 0, time.UTC) }))
+	res, err = b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if !res.NextPublish.IsZero() {
+		t.Errorf("NextPublish = %v, want none", res.NextPublish)
+	}
+	if day := string(out["2025/12/02/index.html"]); !strings.Contains(day, "Evening entry.") {
+		t.Errorf("day page does not show its last version:\n%s", day)
+	}
+	if _, ok := out["2025/12/04/index.html"]; !ok {
+		t.Error("day whose embargo is over was not written")
+	}
+}
+
+func TestBuild_FeedModes(t *testing.T) {
+	history := staticHistory{
+		versions: []Version{
+			{Date: "2025-12-02", Hash: "b", Time: time.Date(2025, 12, 2, 9, 0, 0, 0, time.UTC)},
+			{Date: "2025-11-30", Hash: "a", Time: time.Date(2025, 11, 30, 9, 0, 0, 0, time.UTC)},
+		},
+		content: map[string]string{
+			"a": "# Work\n\nShipping the builder.\n\n# Home\n\nPainting the fence.\n",
+			"b": "# Work\n\nShipping the builder.\n\n# Home\n\nFence painted.\n\nGarden next.\n",
+		},
+	}
+	tests := []struct {
+		mode        string
+		desc, notIn string
+		content     bool
+	}{
+		{mode: "full", desc: "Shipping the builder.", content: true},
+		{mode: "diff", desc: "Fence painted.", notIn: "Shipping the builder.", content: true},
+		{mode: "summary", desc: "Fence painted.", notIn: "Garden next.", content: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Feed.Mode = tt.mode
+			b, _ := newTestBuilder(t, WithHistory(history), WithConfig(cfg))
+			res, err := b.Build()
+			if err != nil {
+				t.Fatalf("Build failed: %v", err)
+			}
+			if len(res.Items) != 2 {
+				t.Fatalf("got %d items, want 2", len(res.Items))
+			}
+			item := res.Items[0]
+			if !strings.Contains(item.Description, tt.desc) || (tt.notIn != "" && strings.Contains(item.Description, tt.notIn)) {
+				t.Errorf("description = %q, want %q without %q", item.Description, tt.desc, tt.notIn)
+			}
+			if (item.Content != "") != tt.content {
+				t.Errorf("content = %q", item.Content)
+			}
+			// The first day has nothing before it, so all of it is new.
+			if first := res.Items[1]; !strings.Contains(first.Description, "Shipping the builder.") {
+				t.Errorf("first item = %q", first.Description)
+			}
+		})
+	}
+}
+
+func TestWriteFeeds_Archives(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = "https://example.com"
+	cfg.Feed.MaxItems = 2
+	b, out := newTestBuilder(t, WithConfig(cfg))
+	var items []Item
+	for i := 5; i >= 1; i-- {
+		items = append(items, Item{Title: fmt.Sprintf("day %d", i)})
+	}
+	pages, err := b.WriteFeeds(Feed{Title: "Plan", Items: items})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 {
+		t.Errorf("wrote %d feeds, want rss.xml and 2 archives", len(pages))
+	}
+
+	current := string(out["rss.xml"])
+	if strings.Count(current, "<item>") != 2 || !strings.Contains(current, "day 5") || !strings.Contains(current, `rel="prev-archive" href="https://example.com/feed/2.xml"`) {
+		t.Errorf("rss.xml:\n%s", current)
+	}
+	first := string(out["feed/1.xml"])
+	if !strings.Contains(first, "day 1") || !strings.Contains(first, "day 2") || !strings.Contains(first, "<fh:archive>") || !strings.Contains(first, `rel="next-archive" href="https://example.com/feed/2.xml"`) {
+		t.Errorf("feed/1.xml:\n%s", first)
+	}
+	second := string(out["feed/2.xml"])
+	if !strings.Contains(second, "day 4") || strings.Contains(second, "day 5") || !strings.Contains(second, `rel="prev-archive" href="https://example.com/feed/1.xml"`) || strings.Contains(second, "next-archive") {
+		t.Errorf("feed/2.xml:\n%s", second)
+	}
+}
+
+func TestBuild_WebSubHub(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = "https://example.com"
+	cfg.WebSubHub = "https://hub.example/"
+	b, out := newTestBuilder(t, WithConfig(cfg))
+	if _, err := b.Build(); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	feed := string(out["rss.xml"])
+	if !strings.Contains(feed, `<atom:link rel="hub" href="https://hub.example/">`) || !strings.Contains(feed, `<atom:link rel="self" href="https://example.com/rss.xml">`) {
+		t.Errorf("feed does not advertise the hub:\n%s", feed)
+	}
+	if index := string(out["index.html"]); !strings.Contains(index, `<link rel="hub" href="https://hub.example/">`) || !strings.Contains(index, `<link rel="self" href="https://example.com/">`) {
+		t.Errorf("index does not advertise the hub:\n%s", index)
+	}
+	// Every page names itself as the topic.
+	for name, data := range out {
+		if !strings.HasSuffix(name, "/index.html") {
+			continue
+		}
+		self := `<link rel="self" href="https://example.com/` + strings.TrimSuffix(name, "index.html") + `">`
+		if !strings.Contains(string(data), self) {
+			t.Errorf("%s does not link to itself as %s", name, self)
+		}
+	}
+}
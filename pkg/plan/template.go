@@ -0,0 +1,22 @@
+package plan
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/dewitt/a-simple-plan/internal/config"
+	"github.com/dewitt/a-simple-plan/internal/render"
+)
+
+// LoadTemplate returns the page template for the plan in dir: its own
+// template.html if it has one, otherwise the configured theme. An empty
+// result means the built-in template.
+func LoadTemplate(dir string, cfg config.Config) (string, error) {
+	if data, err := os.ReadFile(filepath.Join(dir, "template.html")); err == nil {
+		return string(data), nil
+	}
+	if cfg.Theme == "" || cfg.Theme == render.DefaultTheme {
+		return "", nil
+	}
+	return render.ThemeTemplate(cfg.Theme)
+}
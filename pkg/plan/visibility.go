@@ -0,0 +1,98 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VisibilityTrailer is the commit trailer that keeps a version out of the
+// published history when set to "private". The day shows its last public
+// version instead, or nothing if it has none.
+const VisibilityTrailer = "Plan-Visibility"
+
+// PublishAtTrailer is the commit trailer that embargoes a version until a
+// time, given as RFC 3339 or as "2006-01-02 15:04" in the plan's timezone.
+// A version whose author date is in the future is embargoed until then too.
+// Until a version is published, its day shows the version before it.
+const PublishAtTrailer = "Plan-Publish-At"
+
+// RetractionsFile lists the retracted days of a plan, in its directory.
+const RetractionsFile = "retractions.json"
+
+// Retraction takes a day out of the published history after the fact: its
+// page, its entries in the indexes and its feed item are removed, while its
+// versions stay in git. With a note, the day's page says it was retracted
+// and shows the note instead.
+type Retraction struct {
+	Date string `json:"date"` // as 2006-01-02
+	Note string `json:"note,omitempty"`
+}
+
+// LoadRetractions reads the retractions of the plan in dir. A plan without a
+// retractions file has none.
+func LoadRetractions(dir string) ([]Retraction, error) {
+	path := filepath.Join(dir, RetractionsFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rs []Retraction
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("%s: %w", RetractionsFile, err)
+	}
+	for _, r := range rs {
+		if _, err := time.Parse("2006-01-02", r.Date); err != nil {
+			return nil, fmt.Errorf("%s: invalid date %q, want YYYY-MM-DD", RetractionsFile, r.Date)
+		}
+	}
+	return rs, nil
+}
+
+// isPrivate reports whether v is kept out of the published history.
+func isPrivate(v Version) bool {
+	return strings.EqualFold(v.Trailer(VisibilityTrailer), "private")
+}
+
+// publishAt returns when v may be published: its author date, or the time
+// in its PublishAtTrailer if that is later.
+func publishAt(v Version, loc *time.Location) (time.Time, error) {
+	s := v.Trailer(PublishAtTrailer)
+	if s == "" {
+		return v.Time, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			if t.Before(v.Time) {
+				return v.Time, nil
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s %q is not a time", PublishAtTrailer, s)
+}
+
+// publishedDays returns the version published on each day, newest first:
+// the last commit of the day that is neither private nor held back. The
+// versions of days that were retracted are returned separately.
+func publishedDays(versions []Version, retractions map[string]Retraction, held map[string]bool) (days, retracted []Version) {
+	seen := make(map[string]bool)
+	for _, v := range versions {
+		if seen[v.Date] || isPrivate(v) || held[v.Hash] {
+			continue
+		}
+		seen[v.Date] = true
+		if _, ok := retractions[v.Date]; ok {
+			retracted = append(retracted, v)
+		} else {
+			days = append(days, v)
+		}
+	}
+	return days, retracted
+}